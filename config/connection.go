@@ -0,0 +1,134 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+
+	"gorm.io/driver/postgres"
+	"gorm.io/driver/sqlite"
+	"gorm.io/driver/sqlserver"
+	"gorm.io/gorm"
+)
+
+// Databases DB_DRIVER may select. SQL Server stays the default, it is what
+// production runs on.
+const (
+	DriverSQLServer = "sqlserver"
+	DriverPostgres  = "postgres"
+	DriverSQLite    = "sqlite"
+)
+
+// DatabaseConfig holds the connection settings read from the environment.
+// DSN, when set, is used as is instead of being built from the other fields.
+type DatabaseConfig struct {
+	Driver   string
+	Server   string
+	Port     string
+	User     string
+	Password string
+	Name     string
+	SSLMode  string
+	Path     string
+	DSN      string
+}
+
+func getenv(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
+
+// DatabaseConfigFromEnv reads DB_DRIVER, DB_DSN, DB_SERVER, DB_PORT, DB_USER,
+// DB_PASSWORD, DB_NAME, DB_SSLMODE (PostgreSQL) and DB_PATH (SQLite, a local
+// planning_hager.db by default).
+func DatabaseConfigFromEnv() DatabaseConfig {
+	return DatabaseConfig{
+		Driver:   getenv("DB_DRIVER", DriverSQLServer),
+		Server:   os.Getenv("DB_SERVER"),
+		Port:     os.Getenv("DB_PORT"),
+		User:     os.Getenv("DB_USER"),
+		Password: os.Getenv("DB_PASSWORD"),
+		Name:     os.Getenv("DB_NAME"),
+		SSLMode:  getenv("DB_SSLMODE", "disable"),
+		Path:     getenv("DB_PATH", "planning_hager.db"),
+		DSN:      os.Getenv("DB_DSN"),
+	}
+}
+
+// ConnectionString builds the DSN of the selected driver.
+func (c DatabaseConfig) ConnectionString() (string, error) {
+	if c.DSN != "" {
+		return c.DSN, nil
+	}
+
+	switch c.Driver {
+	case DriverSQLServer:
+		host := c.Server
+		if c.Port != "" {
+			host += ":" + c.Port
+		}
+		dsn := url.URL{
+			Scheme:   "sqlserver",
+			User:     url.UserPassword(c.User, c.Password),
+			Host:     host,
+			RawQuery: url.Values{"database": {c.Name}}.Encode(),
+		}
+		return dsn.String(), nil
+
+	case DriverPostgres:
+		port := c.Port
+		if port == "" {
+			port = "5432"
+		}
+		return fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=%s",
+			quoteKeyword(c.Server), quoteKeyword(port), quoteKeyword(c.User), quoteKeyword(c.Password),
+			quoteKeyword(c.Name), quoteKeyword(c.SSLMode)), nil
+
+	case DriverSQLite:
+		return c.Path + "?_foreign_keys=on", nil
+
+	default:
+		return "", fmt.Errorf("unsupported DB_DRIVER %q", c.Driver)
+	}
+}
+
+// quoteKeyword quotes a value of a PostgreSQL keyword/value connection
+// string.
+func quoteKeyword(value string) string {
+	quoted := "'"
+	for _, r := range value {
+		if r == '\'' || r == '\\' {
+			quoted += `\`
+		}
+		quoted += string(r)
+	}
+	return quoted + "'"
+}
+
+// Dialector returns the GORM dialector of the selected driver.
+func (c DatabaseConfig) Dialector() (gorm.Dialector, error) {
+	dsn, err := c.ConnectionString()
+	if err != nil {
+		return nil, err
+	}
+
+	switch c.Driver {
+	case DriverSQLServer:
+		return sqlserver.Open(dsn), nil
+	case DriverPostgres:
+		return postgres.Open(dsn), nil
+	default:
+		return sqlite.Open(dsn), nil
+	}
+}
+
+// OpenDatabase connects to the database configured in the environment.
+func OpenDatabase() (*gorm.DB, error) {
+	dialector, err := DatabaseConfigFromEnv().Dialector()
+	if err != nil {
+		return nil, err
+	}
+	return gorm.Open(dialector, &gorm.Config{})
+}
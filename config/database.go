@@ -1,21 +1,59 @@
 package config
 
 import (
+	"fmt"
+
 	"gorm.io/gorm"
 	"planning_hager/models"
 )
 
-func MigrateDB(db *gorm.DB) {
-	err := db.AutoMigrate(
-		&models.Sector{},
-		&models.CE{},
-		&models.Skill{},
-		&models.Employee{},
-		&models.EmployeeSkill{},
-		&models.Planning{},
-		&models.Reservist{},
-	)
-	if err != nil {
-		return
+// MigrateDB applies the pending migrations, then seeds the reference data a
+// fresh database needs. The server must not start when it fails.
+func MigrateDB(db *gorm.DB) error {
+	if _, err := MigrateUp(db); err != nil {
+		return err
+	}
+	return SeedDB(db)
+}
+
+// SeedDB creates the default shifts and leave types, each only when none
+// exist yet, so it can run any number of times.
+func SeedDB(db *gorm.DB) error {
+	if err := seedShiftDefinitions(db); err != nil {
+		return fmt.Errorf("seeding shift definitions: %w", err)
 	}
+	if err := seedLeaveAccrualRules(db); err != nil {
+		return fmt.Errorf("seeding leave accrual rules: %w", err)
+	}
+	return nil
+}
+
+// seedShiftDefinitions creates the morning, evening and night shifts the
+// plant has always worked, unless shifts have already been defined.
+func seedShiftDefinitions(db *gorm.DB) error {
+	var count int64
+	if err := db.Model(&models.ShiftDefinition{}).Count(&count).Error; err != nil || count > 0 {
+		return err
+	}
+
+	return db.Create(&[]models.ShiftDefinition{
+		{Code: "M", Label: "Matin", StartTime: "05:00", EndTime: "13:00", Color: "#ffe58f"},
+		{Code: "S", Label: "Soir", StartTime: "13:00", EndTime: "21:00", Color: "#91d5ff"},
+		{Code: "N", Label: "Nuit", StartTime: "21:00", EndTime: "05:00", CrossesMidnight: true, Color: "#adc6ff"},
+	}).Error
+}
+
+// seedLeaveAccrualRules creates the leave types every employee has. Recovery
+// hours are earned through overtime, so they accrue nothing by default.
+func seedLeaveAccrualRules(db *gorm.DB) error {
+	var count int64
+	if err := db.Model(&models.LeaveAccrualRule{}).Count(&count).Error; err != nil || count > 0 {
+		return err
+	}
+
+	return db.Create(&[]models.LeaveAccrualRule{
+		{Type: "paid_leave", Label: "Congés payés", Unit: "days", YearlyAmount: 25, CarryOverMax: 5},
+		{Type: "rtt", Label: "RTT", Unit: "days", YearlyAmount: 10},
+		{Type: "recovery_hours", Label: "Heures de récupération", Unit: "hours", CarryOverMax: -1},
+	}).Error
 }
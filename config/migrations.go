@@ -0,0 +1,769 @@
+package config
+
+import (
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+	"planning_hager/models"
+)
+
+// Migration is one versioned change of the schema. Up and Down run in a
+// transaction together with the bookkeeping of schema_migrations.
+type Migration struct {
+	Version uint
+	Name    string
+	Up      func(tx *gorm.DB) error
+	Down    func(tx *gorm.DB) error
+}
+
+// MigrationState tells whether a migration has been applied, and when.
+type MigrationState struct {
+	Version   uint       `json:"version"`
+	Name      string     `json:"name"`
+	AppliedAt *time.Time `json:"applied_at"`
+}
+
+// Each migration declares the structs of the tables it creates or changes as
+// they were when it was written, instead of using the models, so that later
+// changes to the models never change what an old migration does. Structs only
+// referenced by a relation declare no more than their primary key.
+
+// createTables creates the tables of the structs, or adds what they lack when
+// they already exist, so databases created before versioned migrations are
+// adopted by the first ones.
+func createTables(tx *gorm.DB, tables ...interface{}) error {
+	return tx.AutoMigrate(tables...)
+}
+
+// dropTables drops the tables, given by name, in order.
+func dropTables(tables ...string) func(tx *gorm.DB) error {
+	return func(tx *gorm.DB) error {
+		for _, table := range tables {
+			if err := tx.Migrator().DropTable(table); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// addColumns adds fields of the struct to its table. Databases created by
+// AutoMigrate before versioned migrations may already have them.
+func addColumns(tx *gorm.DB, model interface{}, fields ...string) error {
+	for _, field := range fields {
+		if tx.Migrator().HasColumn(model, field) {
+			continue
+		}
+		if err := tx.Migrator().AddColumn(model, field); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func dropColumns(tx *gorm.DB, model interface{}, fields ...string) error {
+	return keepIndexes(tx, model, func() error {
+		for _, field := range fields {
+			if err := tx.Migrator().DropColumn(model, field); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// createIndex creates the index of a field of the struct unless it exists.
+func createIndex(tx *gorm.DB, model interface{}, field string) error {
+	if tx.Migrator().HasIndex(model, field) {
+		return nil
+	}
+	return tx.Migrator().CreateIndex(model, field)
+}
+
+func dropIndex(tx *gorm.DB, model interface{}, field string) error {
+	if !tx.Migrator().HasIndex(model, field) {
+		return nil
+	}
+	return tx.Migrator().DropIndex(model, field)
+}
+
+// createConstraint creates the foreign key of a relation of the struct unless
+// it exists.
+func createConstraint(tx *gorm.DB, model interface{}, relation string) error {
+	if tx.Migrator().HasConstraint(model, relation) {
+		return nil
+	}
+	return keepIndexes(tx, model, func() error {
+		return tx.Migrator().CreateConstraint(model, relation)
+	})
+}
+
+func dropConstraint(tx *gorm.DB, model interface{}, relation string) error {
+	if !tx.Migrator().HasConstraint(model, relation) {
+		return nil
+	}
+	return keepIndexes(tx, model, func() error {
+		return tx.Migrator().DropConstraint(model, relation)
+	})
+}
+
+// keepIndexes runs change on the table of the struct and creates again the
+// indexes it lost: SQLite rebuilds a table to drop a column or change its
+// foreign keys, and the driver does not copy the indexes.
+func keepIndexes(tx *gorm.DB, model interface{}, change func() error) error {
+	if tx.Dialector.Name() != "sqlite" {
+		return change()
+	}
+
+	stmt := &gorm.Statement{DB: tx}
+	if err := stmt.Parse(model); err != nil {
+		return err
+	}
+	var indexes []struct {
+		Name string
+		SQL  string
+	}
+	err := tx.Raw("SELECT name, sql FROM sqlite_master WHERE type = 'index' AND tbl_name = ? AND sql IS NOT NULL", stmt.Table).
+		Scan(&indexes).Error
+	if err != nil {
+		return err
+	}
+
+	if err := change(); err != nil {
+		return err
+	}
+	for _, index := range indexes {
+		if tx.Migrator().HasIndex(model, index.Name) {
+			continue
+		}
+		if err := tx.Exec(index.SQL).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// migrations lists every migration in version order. Applied migrations must
+// never be edited, changes go in a new one.
+var migrations = []Migration{
+	{
+		Version: 1,
+		Name:    "initial_schema",
+		Up: func(tx *gorm.DB) error {
+			type Skill struct {
+				ID        uint   `gorm:"primaryKey"`
+				Name      string `gorm:"not null"`
+				CreatedAt time.Time
+				UpdatedAt time.Time
+				DeletedAt gorm.DeletedAt `gorm:"index"`
+			}
+			type Sector struct {
+				ID             uint    `gorm:"primaryKey"`
+				Name           string  `gorm:"not null"`
+				RequiredSkills []Skill `gorm:"many2many:sector_required_skills;"`
+				CreatedAt      time.Time
+				UpdatedAt      time.Time
+				DeletedAt      gorm.DeletedAt `gorm:"index"`
+			}
+			type Employee struct {
+				gorm.Model
+				Name     string
+				CEID     uint
+				SectorID uint
+				Sector   Sector  `gorm:"foreignKey:SectorID"`
+				Skills   []Skill `gorm:"many2many:employee_skills;"`
+			}
+			type CE struct {
+				ID        uint       `gorm:"primaryKey"`
+				Name      string     `gorm:"not null"`
+				Employees []Employee `gorm:"foreignKey:CEID"`
+				CreatedAt time.Time
+				UpdatedAt time.Time
+				DeletedAt gorm.DeletedAt `gorm:"index"`
+			}
+			type EmployeeSkill struct {
+				EmployeeID uint `gorm:"primaryKey"`
+				SkillID    uint `gorm:"primaryKey"`
+			}
+			type Planning struct {
+				gorm.Model
+				Date         time.Time
+				Week         int
+				Year         int
+				Weekday      string `gorm:"->"`
+				Shift        string
+				CEID         *uint
+				CE           *CE `gorm:"foreignKey:CEID"`
+				SectorID     *uint
+				Sector       *Sector `gorm:"foreignKey:SectorID"`
+				EmployeeID   *uint
+				Employee     *Employee `gorm:"foreignKey:EmployeeID"`
+				Status       string
+				SubstituteID *uint
+				Substitute   *Employee `gorm:"foreignKey:SubstituteID"`
+			}
+			type User struct {
+				gorm.Model
+				Username string `gorm:"unique;not null"`
+				Password string `gorm:"not null"`
+				Role     string `gorm:"not null"`
+			}
+			return createTables(tx,
+				&User{},
+				&Sector{},
+				&CE{},
+				&Skill{},
+				&Employee{},
+				&EmployeeSkill{},
+				&Planning{},
+			)
+		},
+		Down: dropTables(
+			"plannings",
+			"employee_skills",
+			"employees",
+			"sector_required_skills",
+			"skills",
+			"ces",
+			"sectors",
+			"users",
+		),
+	},
+	{
+		Version: 2,
+		Name:    "reservists_rotations_and_shifts",
+		Up: func(tx *gorm.DB) error {
+			type Skill struct {
+				ID uint `gorm:"primaryKey"`
+			}
+			type ReservistAvailability struct {
+				ID               uint      `gorm:"primaryKey"`
+				ReservistID      uint      `gorm:"not null;index"`
+				StartDate        time.Time `gorm:"not null"`
+				EndDate          time.Time `gorm:"not null"`
+				Shifts           []string  `gorm:"serializer:json"`
+				MaxShiftsPerWeek int
+				CreatedAt        time.Time
+				UpdatedAt        time.Time
+				DeletedAt        gorm.DeletedAt `gorm:"index"`
+			}
+			type Reservist struct {
+				ID             uint                    `gorm:"primaryKey"`
+				Name           string                  `gorm:"not null"`
+				Skills         []Skill                 `gorm:"many2many:reservist_skills;"`
+				Availabilities []ReservistAvailability `gorm:"foreignKey:ReservistID"`
+				CreatedAt      time.Time
+				UpdatedAt      time.Time
+				DeletedAt      gorm.DeletedAt `gorm:"index"`
+			}
+			type RotationPattern struct {
+				ID            uint       `gorm:"primaryKey"`
+				Name          string     `gorm:"not null"`
+				CycleLength   int        `gorm:"not null"`
+				Weeks         [][]string `gorm:"serializer:json"`
+				CESlots       []uint     `gorm:"serializer:json"`
+				SaturdaySlots []int      `gorm:"serializer:json"`
+				SundaySlots   []int      `gorm:"serializer:json"`
+				Active        bool
+				CreatedAt     time.Time
+				UpdatedAt     time.Time
+				DeletedAt     gorm.DeletedAt `gorm:"index"`
+			}
+			type WeekRegime struct {
+				ID        uint   `gorm:"primaryKey"`
+				Year      int    `gorm:"not null;uniqueIndex:idx_week_regime_year_week"`
+				Week      int    `gorm:"not null;uniqueIndex:idx_week_regime_year_week"`
+				Regime    string `gorm:"not null"`
+				CreatedAt time.Time
+				UpdatedAt time.Time
+			}
+			type ShiftDefinition struct {
+				ID              uint   `gorm:"primaryKey"`
+				Code            string `gorm:"size:10;not null;uniqueIndex"`
+				Label           string `gorm:"not null"`
+				StartTime       string `gorm:"size:5;not null"`
+				EndTime         string `gorm:"size:5;not null"`
+				CrossesMidnight bool
+				Color           string
+				CreatedAt       time.Time
+				UpdatedAt       time.Time
+			}
+			type LabourRules struct {
+				ID                   uint `gorm:"primaryKey"`
+				MinRestHours         float64
+				MaxConsecutiveDays   int
+				MaxNightShifts       int
+				NightShiftPeriodDays int
+				WeeklyHourCap        float64
+				Enforcement          string `gorm:"size:10;not null"`
+				CreatedAt            time.Time
+				UpdatedAt            time.Time
+			}
+			type Planning struct {
+				ID                    uint `gorm:"primaryKey"`
+				Source                string
+				SubstituteReservistID *uint
+				SubstituteReservist   *Reservist `gorm:"foreignKey:SubstituteReservistID"`
+			}
+			err := createTables(tx,
+				&Reservist{},
+				&RotationPattern{},
+				&WeekRegime{},
+				&ShiftDefinition{},
+				&ReservistAvailability{},
+				&LabourRules{},
+			)
+			if err != nil {
+				return err
+			}
+			if err := addColumns(tx, &Planning{}, "Source", "SubstituteReservistID"); err != nil {
+				return err
+			}
+			return createConstraint(tx, &Planning{}, "SubstituteReservist")
+		},
+		Down: func(tx *gorm.DB) error {
+			type Reservist struct {
+				ID uint `gorm:"primaryKey"`
+			}
+			type Planning struct {
+				ID                    uint `gorm:"primaryKey"`
+				Source                string
+				SubstituteReservistID *uint
+				SubstituteReservist   *Reservist `gorm:"foreignKey:SubstituteReservistID"`
+			}
+			if err := dropConstraint(tx, &Planning{}, "SubstituteReservist"); err != nil {
+				return err
+			}
+			if err := dropColumns(tx, &Planning{}, "SubstituteReservistID", "Source"); err != nil {
+				return err
+			}
+			return dropTables(
+				"labour_rules",
+				"reservist_availabilities",
+				"shift_definitions",
+				"week_regimes",
+				"rotation_patterns",
+				"reservist_skills",
+				"reservists",
+			)(tx)
+		},
+	},
+	{
+		Version: 3,
+		Name:    "absences_leave_and_swaps",
+		Up: func(tx *gorm.DB) error {
+			type Employee struct {
+				ID uint `gorm:"primaryKey"`
+			}
+			type Planning struct {
+				ID               uint  `gorm:"primaryKey"`
+				AbsenceRequestID *uint `gorm:"index"`
+				LeaveType        string
+			}
+			type AbsenceRequest struct {
+				ID            uint      `gorm:"primaryKey"`
+				EmployeeID    uint      `gorm:"not null;index"`
+				Employee      *Employee `gorm:"foreignKey:EmployeeID"`
+				StartDate     time.Time `gorm:"not null"`
+				EndDate       time.Time `gorm:"not null"`
+				Reason        string    `gorm:"size:255"`
+				LeaveType     string    `gorm:"size:20"`
+				State         string    `gorm:"size:20;not null;index"`
+				RequestedBy   string    `gorm:"size:50"`
+				ReviewedBy    string    `gorm:"size:50"`
+				ReviewedAt    *time.Time
+				ReviewComment string `gorm:"size:255"`
+				CreatedAt     time.Time
+				UpdatedAt     time.Time
+			}
+			type LeaveAccrualRule struct {
+				ID           uint   `gorm:"primaryKey"`
+				Type         string `gorm:"size:20;uniqueIndex"`
+				Label        string `gorm:"size:50"`
+				Unit         string `gorm:"size:10;not null"`
+				YearlyAmount float64
+				CarryOverMax float64
+				CreatedAt    time.Time
+				UpdatedAt    time.Time
+			}
+			type LeaveMovement struct {
+				ID         uint   `gorm:"primaryKey"`
+				EmployeeID uint   `gorm:"not null;index"`
+				Type       string `gorm:"size:20;not null"`
+				Year       int    `gorm:"not null;index"`
+				Kind       string `gorm:"size:20;not null"`
+				Amount     float64
+				PlanningID *uint  `gorm:"index"`
+				Note       string `gorm:"size:255"`
+				CreatedBy  string `gorm:"size:50"`
+				CreatedAt  time.Time
+			}
+			type ShiftSwapRequest struct {
+				ID                  uint      `gorm:"primaryKey"`
+				RequesterID         uint      `gorm:"not null;index"`
+				Requester           *Employee `gorm:"foreignKey:RequesterID"`
+				RequesterPlanningID uint      `gorm:"not null"`
+				RequesterPlanning   *Planning `gorm:"foreignKey:RequesterPlanningID"`
+				ColleagueID         uint      `gorm:"not null;index"`
+				Colleague           *Employee `gorm:"foreignKey:ColleagueID"`
+				ColleaguePlanningID uint      `gorm:"not null"`
+				ColleaguePlanning   *Planning `gorm:"foreignKey:ColleaguePlanningID"`
+				Message             string    `gorm:"size:255"`
+				State               string    `gorm:"size:20;not null;index"`
+				RespondedAt         *time.Time
+				ReviewedBy          string `gorm:"size:50"`
+				ReviewedAt          *time.Time
+				ReviewComment       string `gorm:"size:255"`
+				CreatedAt           time.Time
+				UpdatedAt           time.Time
+			}
+			type WeekPublication struct {
+				ID          uint   `gorm:"primaryKey"`
+				Year        int    `gorm:"not null;uniqueIndex:idx_week_publication_year_week"`
+				Week        int    `gorm:"not null;uniqueIndex:idx_week_publication_year_week"`
+				State       string `gorm:"size:20;not null"`
+				PublishedBy string `gorm:"size:50"`
+				PublishedAt *time.Time
+				LockedBy    string `gorm:"size:50"`
+				LockedAt    *time.Time
+				CreatedAt   time.Time
+				UpdatedAt   time.Time
+			}
+			err := createTables(tx,
+				&AbsenceRequest{},
+				&LeaveAccrualRule{},
+				&LeaveMovement{},
+				&ShiftSwapRequest{},
+				&WeekPublication{},
+			)
+			if err != nil {
+				return err
+			}
+			if err := addColumns(tx, &Planning{}, "AbsenceRequestID", "LeaveType"); err != nil {
+				return err
+			}
+			return createIndex(tx, &Planning{}, "AbsenceRequestID")
+		},
+		Down: func(tx *gorm.DB) error {
+			type Planning struct {
+				ID               uint  `gorm:"primaryKey"`
+				AbsenceRequestID *uint `gorm:"index"`
+				LeaveType        string
+			}
+			err := dropTables(
+				"week_publications",
+				"shift_swap_requests",
+				"leave_movements",
+				"leave_accrual_rules",
+				"absence_requests",
+			)(tx)
+			if err != nil {
+				return err
+			}
+			if err := dropIndex(tx, &Planning{}, "AbsenceRequestID"); err != nil {
+				return err
+			}
+			return dropColumns(tx, &Planning{}, "LeaveType", "AbsenceRequestID")
+		},
+	},
+	{
+		Version: 4,
+		Name:    "audit_log_and_change_sets",
+		Up: func(tx *gorm.DB) error {
+			type AuditLog struct {
+				ID        uint   `gorm:"primaryKey"`
+				Username  string `gorm:"size:50;index"`
+				Action    string `gorm:"size:30;not null"`
+				Entity    string `gorm:"size:50;not null;index:idx_audit_entity"`
+				EntityID  uint   `gorm:"index:idx_audit_entity"`
+				Before    string
+				After     string
+				Reason    string    `gorm:"size:255"`
+				CreatedAt time.Time `gorm:"index"`
+			}
+			type ChangeSetItem struct {
+				ID          uint   `gorm:"primaryKey"`
+				ChangeSetID uint   `gorm:"not null;index"`
+				Entity      string `gorm:"size:50;not null"`
+				EntityID    uint
+				Action      string `gorm:"size:30;not null"`
+				Before      string
+				After       string
+			}
+			type ChangeSet struct {
+				ID                uint   `gorm:"primaryKey"`
+				Operation         string `gorm:"size:50;not null;index"`
+				Description       string `gorm:"size:255"`
+				CreatedBy         string `gorm:"size:50"`
+				RevertedBy        string `gorm:"size:50"`
+				RevertedAt        *time.Time
+				RevertChangeSetID *uint
+				Items             []ChangeSetItem
+				CreatedAt         time.Time `gorm:"index"`
+			}
+			return createTables(tx,
+				&AuditLog{},
+				&ChangeSet{},
+				&ChangeSetItem{},
+			)
+		},
+		Down: dropTables(
+			"change_set_items",
+			"change_sets",
+			"audit_logs",
+		),
+	},
+	{
+		Version: 5,
+		Name:    "calendar_tokens",
+		Up: func(tx *gorm.DB) error {
+			type CalendarToken struct {
+				ID         uint   `gorm:"primaryKey"`
+				Token      string `gorm:"size:64;not null;uniqueIndex"`
+				Scope      string `gorm:"size:20;not null"`
+				ScopeID    uint   `gorm:"not null"`
+				CreatedBy  string `gorm:"size:50;index"`
+				LastUsedAt *time.Time
+				CreatedAt  time.Time
+			}
+			return createTables(tx, &CalendarToken{})
+		},
+		Down: dropTables("calendar_tokens"),
+	},
+	{
+		Version: 6,
+		Name:    "user_active",
+		Up: func(tx *gorm.DB) error {
+			type User struct {
+				ID     uint `gorm:"primaryKey"`
+				Active bool `gorm:"not null;default:true"`
+			}
+			return addColumns(tx, &User{}, "Active")
+		},
+		Down: func(tx *gorm.DB) error {
+			type User struct {
+				ID     uint `gorm:"primaryKey"`
+				Active bool `gorm:"not null;default:true"`
+			}
+			return dropColumns(tx, &User{}, "Active")
+		},
+	},
+	{
+		Version: 7,
+		Name:    "user_employee_link",
+		Up: func(tx *gorm.DB) error {
+			type User struct {
+				ID         uint `gorm:"primaryKey"`
+				Username   string
+				EmployeeID *uint `gorm:"index"`
+				DeletedAt  gorm.DeletedAt
+			}
+			type Employee struct {
+				ID        uint `gorm:"primaryKey"`
+				Name      string
+				DeletedAt gorm.DeletedAt
+			}
+			if err := addColumns(tx, &User{}, "EmployeeID"); err != nil {
+				return err
+			}
+			if err := createIndex(tx, &User{}, "EmployeeID"); err != nil {
+				return err
+			}
+
+			// Link the accounts to the employee named after them, as they
+			// were matched before accounts had an employee, when exactly one
+			// employee has that name
+			var users []User
+			if err := tx.Where("employee_id IS NULL").Find(&users).Error; err != nil {
+				return err
+			}
+			for _, user := range users {
+				var employees []Employee
+				if err := tx.Where("name = ?", user.Username).Limit(2).Find(&employees).Error; err != nil {
+					return err
+				}
+				if len(employees) != 1 {
+					continue
+				}
+				if err := tx.Model(&user).UpdateColumn("employee_id", employees[0].ID).Error; err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+		Down: func(tx *gorm.DB) error {
+			type User struct {
+				ID         uint  `gorm:"primaryKey"`
+				EmployeeID *uint `gorm:"index"`
+			}
+			if err := dropIndex(tx, &User{}, "EmployeeID"); err != nil {
+				return err
+			}
+			return dropColumns(tx, &User{}, "EmployeeID")
+		},
+	},
+	{
+		Version: 8,
+		Name:    "user_employee_unique_link",
+		Up: func(tx *gorm.DB) error {
+			type Employee struct {
+				ID uint `gorm:"primaryKey"`
+			}
+			type User struct {
+				ID         uint      `gorm:"primaryKey"`
+				EmployeeID *uint     `gorm:"uniqueIndex:idx_users_employee_id,where:employee_id IS NOT NULL"`
+				Employee   *Employee `gorm:"constraint:OnDelete:SET NULL"`
+			}
+
+			// Deleted accounts and deleted employees keep no link, so that
+			// the employee can be linked again
+			if err := tx.Exec("UPDATE users SET employee_id = NULL WHERE deleted_at IS NOT NULL OR employee_id NOT IN (SELECT id FROM employees WHERE deleted_at IS NULL)").Error; err != nil {
+				return err
+			}
+
+			// The index keeps its name and becomes unique
+			if err := dropIndex(tx, &User{}, "EmployeeID"); err != nil {
+				return err
+			}
+			if err := createIndex(tx, &User{}, "EmployeeID"); err != nil {
+				return err
+			}
+			return createConstraint(tx, &User{}, "Employee")
+		},
+		Down: func(tx *gorm.DB) error {
+			type Employee struct {
+				ID uint `gorm:"primaryKey"`
+			}
+			type User struct {
+				ID         uint      `gorm:"primaryKey"`
+				EmployeeID *uint     `gorm:"index"`
+				Employee   *Employee `gorm:"constraint:OnDelete:SET NULL"`
+			}
+			if err := dropConstraint(tx, &User{}, "Employee"); err != nil {
+				return err
+			}
+			if err := dropIndex(tx, &User{}, "EmployeeID"); err != nil {
+				return err
+			}
+			return createIndex(tx, &User{}, "EmployeeID")
+		},
+	},
+}
+
+// appliedMigrations creates schema_migrations when needed and returns the
+// applied migrations by version.
+func appliedMigrations(db *gorm.DB) (map[uint]models.SchemaMigration, error) {
+	if err := db.AutoMigrate(&models.SchemaMigration{}); err != nil {
+		return nil, fmt.Errorf("creating schema_migrations: %w", err)
+	}
+
+	var rows []models.SchemaMigration
+	if err := db.Find(&rows).Error; err != nil {
+		return nil, fmt.Errorf("reading schema_migrations: %w", err)
+	}
+
+	applied := make(map[uint]models.SchemaMigration, len(rows))
+	for _, row := range rows {
+		applied[row.Version] = row
+	}
+	return applied, nil
+}
+
+// checkKnownMigrations refuses a database migrated by a newer binary, whose
+// schema this one does not know.
+func checkKnownMigrations(applied map[uint]models.SchemaMigration) error {
+	known := make(map[uint]bool, len(migrations))
+	for _, m := range migrations {
+		known[m.Version] = true
+	}
+	for version, row := range applied {
+		if !known[version] {
+			return fmt.Errorf("database has migration %d (%s) applied, which this binary does not know", version, row.Name)
+		}
+	}
+	return nil
+}
+
+// MigrateUp applies the pending migrations in order and returns those it
+// applied. It stops at the first failure, leaving that migration unapplied.
+func MigrateUp(db *gorm.DB) ([]Migration, error) {
+	applied, err := appliedMigrations(db)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkKnownMigrations(applied); err != nil {
+		return nil, err
+	}
+
+	var done []Migration
+	for _, m := range migrations {
+		if _, ok := applied[m.Version]; ok {
+			continue
+		}
+
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if err := m.Up(tx); err != nil {
+				return err
+			}
+			return tx.Create(&models.SchemaMigration{Version: m.Version, Name: m.Name, AppliedAt: time.Now()}).Error
+		})
+		if err != nil {
+			return done, fmt.Errorf("migration %d (%s): %w", m.Version, m.Name, err)
+		}
+		done = append(done, m)
+	}
+	return done, nil
+}
+
+// MigrateDown rolls back the last steps applied migrations, most recent
+// first, and returns those it rolled back.
+func MigrateDown(db *gorm.DB, steps int) ([]Migration, error) {
+	applied, err := appliedMigrations(db)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkKnownMigrations(applied); err != nil {
+		return nil, err
+	}
+
+	var done []Migration
+	for i := len(migrations) - 1; i >= 0 && len(done) < steps; i-- {
+		m := migrations[i]
+		if _, ok := applied[m.Version]; !ok {
+			continue
+		}
+
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if err := m.Down(tx); err != nil {
+				return err
+			}
+			return tx.Delete(&models.SchemaMigration{}, m.Version).Error
+		})
+		if err != nil {
+			return done, fmt.Errorf("rolling back migration %d (%s): %w", m.Version, m.Name, err)
+		}
+		done = append(done, m)
+	}
+	return done, nil
+}
+
+// MigrationStatus lists every known migration with the time it was applied,
+// nil when it is pending.
+func MigrationStatus(db *gorm.DB) ([]MigrationState, error) {
+	applied, err := appliedMigrations(db)
+	if err != nil {
+		return nil, err
+	}
+
+	states := make([]MigrationState, 0, len(migrations))
+	for _, m := range migrations {
+		state := MigrationState{Version: m.Version, Name: m.Name}
+		if row, ok := applied[m.Version]; ok {
+			appliedAt := row.AppliedAt
+			state.AppliedAt = &appliedAt
+		}
+		states = append(states, state)
+	}
+	return states, checkKnownMigrations(applied)
+}
@@ -0,0 +1,159 @@
+package config
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+	"planning_hager/models"
+)
+
+func openTestDB(t *testing.T, name string) *gorm.DB {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), name)), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		t.Fatalf("opening database: %v", err)
+	}
+	return db
+}
+
+// schemaDump describes the tables of a SQLite database, their columns, foreign
+// keys and indexes, one sorted line each, so two schemas compare as text
+// whatever order their DDL was written in.
+func schemaDump(t *testing.T, db *gorm.DB) string {
+	t.Helper()
+	var tables []string
+	if err := db.Raw("SELECT name FROM sqlite_master WHERE type = 'table' AND name NOT LIKE 'sqlite_%'").Scan(&tables).Error; err != nil {
+		t.Fatalf("listing tables: %v", err)
+	}
+
+	var lines []string
+	for _, table := range tables {
+		var columns []struct {
+			Name      string
+			Type      string
+			NotNull   int
+			DfltValue *string
+			Pk        int
+		}
+		if err := db.Raw(`SELECT name, type, "notnull" AS not_null, dflt_value, pk FROM pragma_table_info(?)`, table).Scan(&columns).Error; err != nil {
+			t.Fatalf("reading columns of %s: %v", table, err)
+		}
+		for _, c := range columns {
+			value := ""
+			if c.DfltValue != nil {
+				value = *c.DfltValue
+			}
+			lines = append(lines, fmt.Sprintf("%s column %s %s notnull=%d default=%s pk=%d", table, c.Name, c.Type, c.NotNull, value, c.Pk))
+		}
+
+		var keys []struct{ Table, From, To, OnDelete string }
+		if err := db.Raw(`SELECT "table" AS "table", "from" AS "from", "to" AS "to", on_delete FROM pragma_foreign_key_list(?)`, table).Scan(&keys).Error; err != nil {
+			t.Fatalf("reading foreign keys of %s: %v", table, err)
+		}
+		for _, k := range keys {
+			lines = append(lines, fmt.Sprintf("%s foreign key %s -> %s.%s on delete %s", table, k.From, k.Table, k.To, k.OnDelete))
+		}
+
+		var indexes []struct{ Name, SQL string }
+		if err := db.Raw("SELECT name, COALESCE(sql, '') AS sql FROM sqlite_master WHERE type = 'index' AND tbl_name = ?", table).Scan(&indexes).Error; err != nil {
+			t.Fatalf("reading indexes of %s: %v", table, err)
+		}
+		for _, i := range indexes {
+			lines = append(lines, fmt.Sprintf("%s index %s %s", table, i.Name, i.SQL))
+		}
+	}
+
+	sort.Strings(lines)
+	return strings.Join(lines, "\n")
+}
+
+func TestMigrateUpMatchesModels(t *testing.T) {
+	db := openTestDB(t, "up.db")
+	if _, err := MigrateUp(db); err != nil {
+		t.Fatalf("MigrateUp: %v", err)
+	}
+	migrated := schemaDump(t, db)
+
+	// The migrations must leave nothing for the current models to add
+	if err := db.AutoMigrate(
+		&models.Sector{}, &models.CE{}, &models.Skill{}, &models.Employee{}, &models.EmployeeSkill{},
+		&models.SectorRequiredSkill{}, &models.Planning{}, &models.User{}, &models.Reservist{},
+		&models.RotationPattern{}, &models.WeekRegime{}, &models.ShiftDefinition{},
+		&models.ReservistAvailability{}, &models.LabourRules{}, &models.AbsenceRequest{},
+		&models.LeaveAccrualRule{}, &models.LeaveMovement{}, &models.ShiftSwapRequest{},
+		&models.WeekPublication{}, &models.AuditLog{}, &models.ChangeSet{}, &models.ChangeSetItem{},
+		&models.CalendarToken{},
+	); err != nil {
+		t.Fatalf("AutoMigrate: %v", err)
+	}
+	if autoMigrated := schemaDump(t, db); autoMigrated != migrated {
+		t.Errorf("models differ from the migrated schema:\nmigrated:\n%s\nmodels:\n%s", migrated, autoMigrated)
+	}
+
+	if done, err := MigrateUp(db); err != nil || len(done) != 0 {
+		t.Errorf("second MigrateUp applied %d migrations, error %v", len(done), err)
+	}
+}
+
+func TestMigrateDownRestoresEachVersion(t *testing.T) {
+	all := migrations
+	defer func() { migrations = all }()
+
+	for n := 1; n < len(all); n++ {
+		t.Run(all[n-1].Name, func(t *testing.T) {
+			migrations = all[:n]
+			fresh := openTestDB(t, "fresh.db")
+			if _, err := MigrateUp(fresh); err != nil {
+				t.Fatalf("MigrateUp to version %d: %v", all[n-1].Version, err)
+			}
+
+			migrations = all
+			db := openTestDB(t, "down.db")
+			if _, err := MigrateUp(db); err != nil {
+				t.Fatalf("MigrateUp: %v", err)
+			}
+			done, err := MigrateDown(db, len(all)-n)
+			if err != nil {
+				t.Fatalf("MigrateDown: %v", err)
+			}
+			if len(done) != len(all)-n {
+				t.Fatalf("MigrateDown rolled back %d migrations, want %d", len(done), len(all)-n)
+			}
+
+			if want, got := schemaDump(t, fresh), schemaDump(t, db); want != got {
+				t.Errorf("schema after rolling back differs:\nmigrated up:\n%s\nrolled back:\n%s", want, got)
+			}
+		})
+	}
+}
+
+func TestMigrateDownAll(t *testing.T) {
+	db := openTestDB(t, "all.db")
+	if _, err := MigrateUp(db); err != nil {
+		t.Fatalf("MigrateUp: %v", err)
+	}
+	if _, err := MigrateDown(db, len(migrations)); err != nil {
+		t.Fatalf("MigrateDown: %v", err)
+	}
+
+	var tables []string
+	if err := db.Raw("SELECT name FROM sqlite_master WHERE type = 'table' AND name NOT LIKE 'sqlite_%' AND name <> 'schema_migrations'").Scan(&tables).Error; err != nil {
+		t.Fatalf("listing tables: %v", err)
+	}
+	if len(tables) != 0 {
+		t.Errorf("tables left after rolling everything back: %v", tables)
+	}
+
+	// Migrating up again must work on the emptied database
+	if done, err := MigrateUp(db); err != nil || len(done) != len(migrations) {
+		t.Errorf("MigrateUp after rolling back applied %d migrations, error %v", len(done), err)
+	}
+}
@@ -2,13 +2,12 @@ import React, { useCallback, useEffect, useState } from 'react';
 import { Button, Dropdown, Menu, message, Modal, Select, Table, Tag, Tooltip } from 'antd';
 import { PlusOutlined, QuestionCircleOutlined } from '@ant-design/icons';
 import dayjs from 'dayjs';
-import weekOfYear from 'dayjs/plugin/weekOfYear';
+import isoWeek from 'dayjs/plugin/isoWeek';
 import api from '../utils/Api';
-import { generateShiftSchedule } from '../utils/shift';
 import './Planning.css';
 import { TableColumnsType } from 'antd';
 
-dayjs.extend(weekOfYear);
+dayjs.extend(isoWeek);
 
 const { Option } = Select;
 
@@ -81,9 +80,16 @@ interface CE {
   name: string;
 }
 
+// ISO-8601 weeks start on Monday; week 1 is the week containing January 4th.
+const isoWeekStart = (year: number, week: number) =>
+  dayjs(`${year}-01-04`).isoWeek(week).startOf('isoWeek');
+
+const isoWeeksInYear = (year: number) => dayjs(`${year}-12-28`).isoWeek();
+
 const Planning: React.FC = () => {
     const [planningData, setPlanningData] = useState<PlanningEntry[]>([]);
-    const [currentWeek, setCurrentWeek] = useState(dayjs().week());
+    const [currentYear, setCurrentYear] = useState(dayjs().isoWeekYear());
+    const [currentWeek, setCurrentWeek] = useState(dayjs().isoWeek());
     const [loading, setLoading] = useState(false);
     const [sectors, setSectors] = useState<Sector[]>([]);
     const [employees, setEmployees] = useState<Employee[]>([]);
@@ -91,10 +97,16 @@ const Planning: React.FC = () => {
     const [ces, setCEs] = useState<CE[]>([]);
     const [shiftType, setShiftType] = useState<string>('4x8 L');
 
+    const moveWeek = (delta: number) => {
+        const target = isoWeekStart(currentYear, currentWeek).add(delta, 'week');
+        setCurrentYear(target.isoWeekYear());
+        setCurrentWeek(target.isoWeek());
+    };
+
     const fetchPlanningData = async () => {
         setLoading(true);
         try {
-            const response = await api.get(`/planning?week=${currentWeek}`);
+            const response = await api.get(`/planning?year=${currentYear}&week=${currentWeek}`);
             console.log('Fetched planning data:', response.data);
             setPlanningData(response.data);
         } catch (error) {
@@ -145,6 +157,16 @@ const Planning: React.FC = () => {
         }
     };
 
+    const fetchWeekRegime = async () => {
+        try {
+            const response = await api.get(`/week_regime?year=${currentYear}&week=${currentWeek}`);
+            setShiftType(response.data.regime || '');
+        } catch (error) {
+            console.error('Failed to fetch week regime:', error);
+            setShiftType('');
+        }
+    };
+
     const fetchCEs = async () => {
         try {
             const response = await api.get('/ces');
@@ -161,11 +183,11 @@ const Planning: React.FC = () => {
         fetchEmployees();
         fetchSectorRequiredSkills();
         fetchCEs();
-    }, [currentWeek]);
+    }, [currentYear, currentWeek]);
 
     useEffect(() => {
-        setShiftType('');
-    }, [currentWeek]);
+        fetchWeekRegime();
+    }, [currentYear, currentWeek]);
 
     const handleAddEmployee = async (day: string, shift: string, sectorId: number, employeeId: number) => {
         try {
@@ -189,22 +211,13 @@ const Planning: React.FC = () => {
         }
     };
 
-    const handleAddCE = async (day: string, shift: string, ceId: number) => {
+    const handleAddCE = async (ceId: number) => {
         try {
-            const schedule = generateShiftSchedule(ceId, currentWeek, shiftType);
-
-            for (const [scheduleDay, scheduleShift] of Object.entries(schedule)) {
-                const date = getDateFromDayAndWeek(scheduleDay, currentWeek);
-                const payload = {
-                    date,
-                    week: currentWeek,
-                    shift: scheduleShift,
-                    ce_id: ceId,
-                    status: 'Scheduled'
-                };
-
-                await api.post('/add_ce_planning', payload);
-            }
+            await api.post('/add_ce_week_planning', {
+                year: currentYear,
+                week: currentWeek,
+                ce_id: ceId
+            });
 
             message.success('CE and team added to planning');
             fetchPlanningData();
@@ -327,8 +340,7 @@ const Planning: React.FC = () => {
     };
 
     const getDateFromDayAndWeek = (day: string, week: number) => {
-    const year = dayjs().year();
-    const startOfWeek = dayjs().year(year).week(week).startOf('week');
+    const startOfWeek = isoWeekStart(currentYear, week);
     const dayIndex = DAYS.indexOf(day);
     return startOfWeek.add(dayIndex, 'day').format('YYYY-MM-DD');
   };
@@ -336,7 +348,7 @@ const Planning: React.FC = () => {
   const isCurrentDay = (day: string) => {
     const today = dayjs();
     const currentDay = today.format('dd');
-    return frenchToEnglishDay[day] === currentDay && currentWeek === today.week();
+    return frenchToEnglishDay[day] === currentDay && currentWeek === today.isoWeek() && currentYear === today.isoWeekYear();
   };
 
   const isEmployeeCompetent = (employee: Employee, sectorId: number) => {
@@ -357,9 +369,10 @@ const Planning: React.FC = () => {
   const handleShiftTypeChange = async (value: string) => {
     setShiftType(value);
     try {
-      await api.post('/update_planning_shift_type', {
+      await api.put('/week_regime', {
+        year: currentYear,
         week: currentWeek,
-        shiftType: value
+        regime: value
       });
       message.success('Shift type updated successfully');
       fetchPlanningData();
@@ -495,7 +508,7 @@ const Planning: React.FC = () => {
           const menu = (
             <Menu>
               {ces.map(ce => (
-                <Menu.Item key={ce.id} onClick={() => handleAddCE(record.day, record.shift, ce.id)}>
+                <Menu.Item key={ce.id} onClick={() => handleAddCE(ce.id)}>
                   {ce.name}
                 </Menu.Item>
               ))}
@@ -662,12 +675,24 @@ const Planning: React.FC = () => {
   return (
     <div className="planning-container">
       <div className="planning-controls">
+        <Select
+          value={currentYear}
+          onChange={(year: number) => {
+            setCurrentYear(year);
+            setCurrentWeek(prev => Math.min(prev, isoWeeksInYear(year)));
+          }}
+          style={{width: 100, marginRight: 16}}
+        >
+          {[currentYear - 1, currentYear, currentYear + 1].map(year => (
+            <Option key={year} value={year}>{year}</Option>
+          ))}
+        </Select>
         <Select
           value={currentWeek}
           onChange={setCurrentWeek}
           style={{width: 120}}
         >
-          {[...Array(52)].map((_, i) => (
+          {[...Array(isoWeeksInYear(currentYear))].map((_, i) => (
             <Option key={i + 1} value={i + 1}>Week {i + 1}</Option>
           ))}
         </Select>
@@ -681,10 +706,10 @@ const Planning: React.FC = () => {
           <Option value="4x8 N">4x8 Normal</Option>
           <Option value="4x8 C">4x8 Short</Option>
         </Select>
-        <Button onClick={() => setCurrentWeek(prev => Math.max(1, prev - 1))}>
+        <Button onClick={() => moveWeek(-1)}>
           Previous Week
         </Button>
-        <Button onClick={() => setCurrentWeek(prev => Math.min(52, prev + 1))}>
+        <Button onClick={() => moveWeek(1)}>
           Next Week
         </Button>
         <Tooltip title={rulesContent} placement="bottomRight" overlayClassName="rules-tooltip">
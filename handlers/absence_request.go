@@ -0,0 +1,433 @@
+package handlers
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"planning_hager/models"
+)
+
+const (
+	AbsenceStatePending   = "pending"
+	AbsenceStateApproved  = "approved"
+	AbsenceStateRejected  = "rejected"
+	AbsenceStateCancelled = "cancelled"
+)
+
+func (h *Handler) AddAbsenceRequest(c *gin.Context) {
+	var input struct {
+		EmployeeID uint   `json:"employee_id"`
+		StartDate  string `json:"start_date" binding:"required"`
+		EndDate    string `json:"end_date" binding:"required"`
+		Reason     string `json:"reason" binding:"required"`
+		LeaveType  string `json:"leave_type"`
+	}
+
+	if err := c.ShouldBindJSON(&input); err != nil {
+		h.respondWithError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	role := c.GetString("role")
+	if role == "readonly" {
+		h.respondWithError(c, http.StatusForbidden, "Read-only users cannot request absences")
+		return
+	}
+
+	startDate, err := time.Parse("2006-01-02", input.StartDate)
+	if err != nil {
+		h.respondWithError(c, http.StatusBadRequest, "Invalid start date format")
+		return
+	}
+	endDate, err := time.Parse("2006-01-02", input.EndDate)
+	if err != nil {
+		h.respondWithError(c, http.StatusBadRequest, "Invalid end date format")
+		return
+	}
+	if endDate.Before(startDate) {
+		h.respondWithError(c, http.StatusBadRequest, "End date must not be before start date")
+		return
+	}
+
+	if input.LeaveType == "" {
+		input.LeaveType = LeavePaid
+	}
+	valid, err := isValidLeaveType(h.DB, input.LeaveType)
+	if err != nil {
+		h.respondWithError(c, http.StatusInternalServerError, "Failed to fetch leave types")
+		return
+	}
+	if !valid {
+		h.respondWithError(c, http.StatusBadRequest, "Invalid leave type")
+		return
+	}
+
+	// Admins may file a request on behalf of an employee, users only for
+	// themselves
+	var employee models.Employee
+	if role == "admin" && input.EmployeeID != 0 {
+		if err := h.DB.First(&employee, input.EmployeeID).Error; err != nil {
+			h.respondWithError(c, http.StatusNotFound, "Employee not found")
+			return
+		}
+	} else {
+		if employee, err = h.currentEmployee(c); err != nil {
+			h.respondWithError(c, http.StatusNotFound, "No employee found for the current user")
+			return
+		}
+	}
+
+	var overlapping int64
+	if err := h.DB.Model(&models.AbsenceRequest{}).
+		Where("employee_id = ? AND state IN ? AND start_date <= ? AND end_date >= ?",
+			employee.ID, []string{AbsenceStatePending, AbsenceStateApproved}, endDate, startDate).
+		Count(&overlapping).Error; err != nil {
+		h.respondWithError(c, http.StatusInternalServerError, "Failed to check existing absence requests")
+		return
+	}
+	if overlapping > 0 {
+		h.respondWithError(c, http.StatusConflict, "An absence request already covers these dates")
+		return
+	}
+
+	request := models.AbsenceRequest{
+		EmployeeID:  employee.ID,
+		StartDate:   startDate,
+		EndDate:     endDate,
+		Reason:      input.Reason,
+		LeaveType:   input.LeaveType,
+		State:       AbsenceStatePending,
+		RequestedBy: c.GetString("username"),
+	}
+
+	if err := h.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&request).Error; err != nil {
+			return err
+		}
+		return h.audit(c, tx, AuditCreate, "absence_request", request.ID, nil, request)
+	}); err != nil {
+		h.respondWithError(c, http.StatusInternalServerError, "Failed to create absence request")
+		return
+	}
+
+	h.respondWithSuccess(c, http.StatusCreated, request)
+}
+
+// GetAbsenceRequests lists the requests, reviewed ones included, optionally
+// filtered by state, employee and overlapping date range.
+func (h *Handler) GetAbsenceRequests(c *gin.Context) {
+	query := h.DB.Preload("Employee").Order("start_date DESC")
+
+	if state := c.Query("state"); state != "" {
+		query = query.Where("state = ?", state)
+	}
+	if employeeID := c.Query("employee_id"); employeeID != "" {
+		id, err := strconv.Atoi(employeeID)
+		if err != nil {
+			h.respondWithError(c, http.StatusBadRequest, "Invalid employee_id parameter")
+			return
+		}
+		query = query.Where("employee_id = ?", id)
+	}
+	if from := c.Query("from"); from != "" {
+		date, err := time.Parse("2006-01-02", from)
+		if err != nil {
+			h.respondWithError(c, http.StatusBadRequest, "Invalid from parameter")
+			return
+		}
+		query = query.Where("end_date >= ?", date)
+	}
+	if to := c.Query("to"); to != "" {
+		date, err := time.Parse("2006-01-02", to)
+		if err != nil {
+			h.respondWithError(c, http.StatusBadRequest, "Invalid to parameter")
+			return
+		}
+		query = query.Where("start_date <= ?", date)
+	}
+
+	var requests []models.AbsenceRequest
+	if err := query.Find(&requests).Error; err != nil {
+		h.respondWithError(c, http.StatusInternalServerError, "Failed to fetch absence requests")
+		return
+	}
+
+	h.respondWithSuccess(c, http.StatusOK, requests)
+}
+
+func (h *Handler) GetCurrentEmployeeAbsenceRequests(c *gin.Context) {
+	employee, err := h.currentEmployee(c)
+	if err != nil {
+		h.respondWithError(c, http.StatusNotFound, "No employee found for the current user")
+		return
+	}
+
+	var requests []models.AbsenceRequest
+	if err := h.DB.Where("employee_id = ?", employee.ID).Order("start_date DESC").Find(&requests).Error; err != nil {
+		h.respondWithError(c, http.StatusInternalServerError, "Failed to fetch absence requests")
+		return
+	}
+
+	h.respondWithSuccess(c, http.StatusOK, requests)
+}
+
+type reviewInput struct {
+	Comment        string `json:"comment"`
+	OverrideReason string `json:"override_reason"`
+}
+
+// ApproveAbsenceRequest accepts a pending request and marks the employee as
+// absent on the scheduled planning entries it covers.
+func (h *Handler) ApproveAbsenceRequest(c *gin.Context) {
+	id := c.Param("id")
+	// The review comment is optional, so is the body
+	var input reviewInput
+	if err := c.ShouldBindJSON(&input); err != nil && !errors.Is(err, io.EOF) {
+		h.respondWithError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	tx := h.DB.Begin()
+
+	var request models.AbsenceRequest
+	if err := tx.First(&request, id).Error; err != nil {
+		tx.Rollback()
+		h.respondWithError(c, http.StatusNotFound, "Absence request not found")
+		return
+	}
+	if request.State != AbsenceStatePending {
+		tx.Rollback()
+		h.respondWithError(c, http.StatusConflict, "Only pending absence requests can be approved")
+		return
+	}
+
+	if !h.checkPlanningUnlocked(c, tx, request.StartDate, request.EndDate.AddDate(0, 0, 1), overrideReason(c, input.OverrideReason)) {
+		return
+	}
+
+	var scheduled []models.Planning
+	if err := tx.Where("employee_id = ? AND date >= ? AND date <= ? AND status = ?",
+		request.EmployeeID, request.StartDate, request.EndDate, StatusScheduled).Find(&scheduled).Error; err != nil {
+		tx.Rollback()
+		h.respondWithError(c, http.StatusInternalServerError, "Failed to fetch planning entries")
+		return
+	}
+
+	result := tx.Model(&models.Planning{}).
+		Where("employee_id = ? AND date >= ? AND date <= ? AND status = ?",
+			request.EmployeeID, request.StartDate, request.EndDate, StatusScheduled).
+		Updates(map[string]interface{}{
+			"status":             StatusAbsentP,
+			"absence_request_id": request.ID,
+			"leave_type":         request.LeaveType,
+		})
+	if result.Error != nil {
+		tx.Rollback()
+		h.respondWithError(c, http.StatusInternalServerError, "Failed to update planning entries")
+		return
+	}
+
+	var absent []models.Planning
+	if err := tx.Where("absence_request_id = ?", request.ID).Find(&absent).Error; err != nil {
+		tx.Rollback()
+		h.respondWithError(c, http.StatusInternalServerError, "Failed to fetch planning entries")
+		return
+	}
+	if err := syncLeaveDebits(tx, absent, c.GetString("username")); err != nil {
+		tx.Rollback()
+		h.respondWithError(c, http.StatusInternalServerError, "Failed to update leave balance")
+		return
+	}
+
+	before := make(map[uint]models.Planning, len(scheduled))
+	for _, p := range scheduled {
+		before[p.ID] = p
+	}
+	diff := planningDiff{}
+	for _, p := range absent {
+		diff.Updated = append(diff.Updated, planningChange{Before: before[p.ID], After: p})
+	}
+	if err := h.auditPlanningDiff(c, tx, diff); err != nil {
+		tx.Rollback()
+		h.respondWithError(c, http.StatusInternalServerError, "Failed to record audit log")
+		return
+	}
+
+	now := time.Now()
+	reviewed := request
+	reviewed.State = AbsenceStateApproved
+	reviewed.ReviewedBy = c.GetString("username")
+	reviewed.ReviewedAt = &now
+	reviewed.ReviewComment = input.Comment
+
+	if err := tx.Save(&reviewed).Error; err != nil {
+		tx.Rollback()
+		h.respondWithError(c, http.StatusInternalServerError, "Failed to update absence request")
+		return
+	}
+	if err := h.audit(c, tx, AuditUpdate, "absence_request", request.ID, request, reviewed); err != nil {
+		tx.Rollback()
+		h.respondWithError(c, http.StatusInternalServerError, "Failed to record audit log")
+		return
+	}
+	request = reviewed
+
+	if err := tx.Commit().Error; err != nil {
+		h.respondWithError(c, http.StatusInternalServerError, "Failed to commit transaction")
+		return
+	}
+
+	h.respondWithSuccess(c, http.StatusOK, gin.H{
+		"request":           request,
+		"updated_plannings": result.RowsAffected,
+	})
+}
+
+func (h *Handler) RejectAbsenceRequest(c *gin.Context) {
+	id := c.Param("id")
+	// The review comment is optional, so is the body
+	var input reviewInput
+	if err := c.ShouldBindJSON(&input); err != nil && !errors.Is(err, io.EOF) {
+		h.respondWithError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	var request models.AbsenceRequest
+	if err := h.DB.First(&request, id).Error; err != nil {
+		h.respondWithError(c, http.StatusNotFound, "Absence request not found")
+		return
+	}
+	if request.State != AbsenceStatePending {
+		h.respondWithError(c, http.StatusConflict, "Only pending absence requests can be rejected")
+		return
+	}
+
+	before := request
+	now := time.Now()
+	request.State = AbsenceStateRejected
+	request.ReviewedBy = c.GetString("username")
+	request.ReviewedAt = &now
+	request.ReviewComment = input.Comment
+
+	if err := h.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(&request).Error; err != nil {
+			return err
+		}
+		return h.audit(c, tx, AuditUpdate, "absence_request", request.ID, before, request)
+	}); err != nil {
+		h.respondWithError(c, http.StatusInternalServerError, "Failed to update absence request")
+		return
+	}
+
+	h.respondWithSuccess(c, http.StatusOK, request)
+}
+
+// CancelAbsenceRequest withdraws a request. Employees may withdraw their own
+// pending requests; admins may also cancel an approved request, which puts
+// the employee back on the planning entries the approval marked absent.
+func (h *Handler) CancelAbsenceRequest(c *gin.Context) {
+	id := c.Param("id")
+	isAdmin := c.GetString("role") == "admin"
+
+	tx := h.DB.Begin()
+
+	var request models.AbsenceRequest
+	if err := tx.First(&request, id).Error; err != nil {
+		tx.Rollback()
+		h.respondWithError(c, http.StatusNotFound, "Absence request not found")
+		return
+	}
+
+	if !isAdmin {
+		employee, err := h.currentEmployee(c)
+		if err != nil || employee.ID != request.EmployeeID {
+			tx.Rollback()
+			h.respondWithError(c, http.StatusForbidden, "Absence request belongs to another employee")
+			return
+		}
+	}
+
+	switch {
+	case request.State == AbsenceStatePending:
+	case request.State == AbsenceStateApproved && isAdmin:
+	default:
+		tx.Rollback()
+		h.respondWithError(c, http.StatusConflict, "Absence request can no longer be cancelled")
+		return
+	}
+
+	violations := []labourViolation{}
+	if request.State == AbsenceStateApproved {
+		var absent []models.Planning
+		if err := tx.Where("absence_request_id = ? AND status = ?", request.ID, StatusAbsentP).Find(&absent).Error; err != nil {
+			tx.Rollback()
+			h.respondWithError(c, http.StatusInternalServerError, "Failed to fetch planning entries")
+			return
+		}
+
+		from, to := request.StartDate, request.EndDate.AddDate(0, 0, 1)
+		baseline, ok := h.startLabourCheck(c, tx, from, to)
+		if !ok {
+			return
+		}
+
+		diff := planningDiff{}
+		for i := range absent {
+			before := absent[i]
+			absent[i].Status = StatusScheduled
+			absent[i].AbsenceRequestID = nil
+			absent[i].LeaveType = ""
+			if err := tx.Save(&absent[i]).Error; err != nil {
+				tx.Rollback()
+				h.respondWithError(c, http.StatusInternalServerError, "Failed to restore planning entries")
+				return
+			}
+			diff.Updated = append(diff.Updated, planningChange{Before: before, After: absent[i]})
+		}
+
+		if err := h.auditPlanningDiff(c, tx, diff); err != nil {
+			tx.Rollback()
+			h.respondWithError(c, http.StatusInternalServerError, "Failed to record audit log")
+			return
+		}
+
+		if err := syncLeaveDebits(tx, absent, c.GetString("username")); err != nil {
+			tx.Rollback()
+			h.respondWithError(c, http.StatusInternalServerError, "Failed to update leave balance")
+			return
+		}
+
+		violations, ok = h.checkLabourRules(c, tx, baseline, []uint{request.EmployeeID}, from, to)
+		if !ok {
+			return
+		}
+	}
+
+	before := request
+	request.State = AbsenceStateCancelled
+	if err := tx.Save(&request).Error; err != nil {
+		tx.Rollback()
+		h.respondWithError(c, http.StatusInternalServerError, "Failed to update absence request")
+		return
+	}
+	if err := h.audit(c, tx, AuditUpdate, "absence_request", request.ID, before, request); err != nil {
+		tx.Rollback()
+		h.respondWithError(c, http.StatusInternalServerError, "Failed to record audit log")
+		return
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		h.respondWithError(c, http.StatusInternalServerError, "Failed to commit transaction")
+		return
+	}
+
+	h.respondWithSuccess(c, http.StatusOK, gin.H{
+		"request":    request,
+		"violations": violations,
+	})
+}
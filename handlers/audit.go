@@ -0,0 +1,223 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"planning_hager/models"
+)
+
+const (
+	AuditCreate = "create"
+	AuditUpdate = "update"
+	AuditDelete = "delete"
+	// AuditOverride records a change to a past or published week made with
+	// an override reason.
+	AuditOverride = "override"
+)
+
+// ChangeReasonHeader lets clients explain any change they make.
+const ChangeReasonHeader = "X-Change-Reason"
+
+// changeReason returns the reason given for the current change: the override
+// reason of a past or published period, or the X-Change-Reason header.
+func changeReason(c *gin.Context) string {
+	if reason := c.GetString("change_reason"); reason != "" {
+		return reason
+	}
+	return c.GetHeader(ChangeReasonHeader)
+}
+
+func auditJSON(value interface{}) (string, error) {
+	if value == nil {
+		return "", nil
+	}
+	data, err := json.Marshal(value)
+	return string(data), err
+}
+
+func newAuditLog(c *gin.Context, action, entity string, entityID uint, before, after interface{}) (models.AuditLog, error) {
+	return newAuditLogBy(c.GetString("username"), changeReason(c), action, entity, entityID, before, after)
+}
+
+// newAuditLogBy builds the audit log of a change made by username outside of
+// a request, such as from the command line.
+func newAuditLogBy(username, reason, action, entity string, entityID uint, before, after interface{}) (models.AuditLog, error) {
+	beforeJSON, err := auditJSON(before)
+	if err != nil {
+		return models.AuditLog{}, err
+	}
+	afterJSON, err := auditJSON(after)
+	if err != nil {
+		return models.AuditLog{}, err
+	}
+
+	return models.AuditLog{
+		Username: username,
+		Action:   action,
+		Entity:   entity,
+		EntityID: entityID,
+		Before:   beforeJSON,
+		After:    afterJSON,
+		Reason:   reason,
+	}, nil
+}
+
+// audit records a change made by the current user within db, which should be
+// the transaction of the change. before or after is nil when the entity did
+// not exist before or after the change.
+func (h *Handler) audit(c *gin.Context, db *gorm.DB, action, entity string, entityID uint, before, after interface{}) error {
+	log, err := newAuditLog(c, action, entity, entityID, before, after)
+	if err != nil {
+		return err
+	}
+	return db.Create(&log).Error
+}
+
+type auditEntry struct {
+	ID        uint            `json:"id"`
+	Username  string          `json:"username"`
+	Action    string          `json:"action"`
+	Entity    string          `json:"entity"`
+	EntityID  uint            `json:"entity_id"`
+	Before    json.RawMessage `json:"before"`
+	After     json.RawMessage `json:"after"`
+	Reason    string          `json:"reason"`
+	CreatedAt time.Time       `json:"created_at"`
+}
+
+func rawJSON(value string) json.RawMessage {
+	if value == "" {
+		return json.RawMessage("null")
+	}
+	return json.RawMessage(value)
+}
+
+// GetAuditLogs lists the most recent changes, filtered by username, entity,
+// entity_id, action and a from/to date range.
+func (h *Handler) GetAuditLogs(c *gin.Context) {
+	query := h.DB.Model(&models.AuditLog{})
+
+	for _, filter := range []string{"username", "entity", "action"} {
+		if value := c.Query(filter); value != "" {
+			query = query.Where(filter+" = ?", value)
+		}
+	}
+	if entityID := c.Query("entity_id"); entityID != "" {
+		id, err := strconv.Atoi(entityID)
+		if err != nil {
+			h.respondWithError(c, http.StatusBadRequest, "Invalid entity_id parameter")
+			return
+		}
+		query = query.Where("entity_id = ?", id)
+	}
+	if from := c.Query("from"); from != "" {
+		date, err := time.Parse("2006-01-02", from)
+		if err != nil {
+			h.respondWithError(c, http.StatusBadRequest, "Invalid from parameter")
+			return
+		}
+		query = query.Where("created_at >= ?", date)
+	}
+	if to := c.Query("to"); to != "" {
+		date, err := time.Parse("2006-01-02", to)
+		if err != nil {
+			h.respondWithError(c, http.StatusBadRequest, "Invalid to parameter")
+			return
+		}
+		query = query.Where("created_at < ?", date.AddDate(0, 0, 1))
+	}
+
+	limit, offset := 100, 0
+	if value := c.Query("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n <= 0 || n > 1000 {
+			h.respondWithError(c, http.StatusBadRequest, "Invalid limit parameter")
+			return
+		}
+		limit = n
+	}
+	if value := c.Query("offset"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			h.respondWithError(c, http.StatusBadRequest, "Invalid offset parameter")
+			return
+		}
+		offset = n
+	}
+
+	// The filtered query is used twice, to count and to fetch a page
+	query = query.Session(&gorm.Session{})
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		h.respondWithError(c, http.StatusInternalServerError, "Failed to fetch audit log")
+		return
+	}
+
+	var logs []models.AuditLog
+	if err := query.Order("created_at DESC, id DESC").Limit(limit).Offset(offset).Find(&logs).Error; err != nil {
+		h.respondWithError(c, http.StatusInternalServerError, "Failed to fetch audit log")
+		return
+	}
+
+	entries := make([]auditEntry, len(logs))
+	for i, l := range logs {
+		entries[i] = auditEntry{
+			ID:        l.ID,
+			Username:  l.Username,
+			Action:    l.Action,
+			Entity:    l.Entity,
+			EntityID:  l.EntityID,
+			Before:    rawJSON(l.Before),
+			After:     rawJSON(l.After),
+			Reason:    l.Reason,
+			CreatedAt: l.CreatedAt,
+		}
+	}
+
+	h.respondWithSuccess(c, http.StatusOK, gin.H{
+		"total":   total,
+		"entries": entries,
+	})
+}
+
+// auditPlanningDiff records every row created, updated or deleted by a planning
+// operation, once the diff has been applied.
+func (h *Handler) auditPlanningDiff(c *gin.Context, db *gorm.DB, diff planningDiff) error {
+	return auditPlanningDiffBy(db, c.GetString("username"), changeReason(c), diff)
+}
+
+func auditPlanningDiffBy(db *gorm.DB, username, reason string, diff planningDiff) error {
+	var logs []models.AuditLog
+	add := func(action string, id uint, before, after interface{}) error {
+		log, err := newAuditLogBy(username, reason, action, "planning", id, before, after)
+		logs = append(logs, log)
+		return err
+	}
+
+	for _, p := range diff.Created {
+		if err := add(AuditCreate, p.ID, nil, p); err != nil {
+			return err
+		}
+	}
+	for _, change := range diff.Updated {
+		if err := add(AuditUpdate, change.After.ID, change.Before, change.After); err != nil {
+			return err
+		}
+	}
+	for _, p := range diff.Deleted {
+		if err := add(AuditDelete, p.ID, p, nil); err != nil {
+			return err
+		}
+	}
+
+	if len(logs) == 0 {
+		return nil
+	}
+	return db.CreateInBatches(&logs, 100).Error
+}
@@ -10,6 +10,7 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt/v5"
 	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
 	"planning_hager/models"
 )
 
@@ -40,6 +41,12 @@ func (h *Handler) Login(c *gin.Context) {
 		return
 	}
 
+	if !user.Active {
+		log.Printf("Login refused for disabled user: %s", loginInput.Username)
+		h.respondWithError(c, http.StatusForbidden, "Account disabled")
+		return
+	}
+
 	expirationTime := time.Now().Add(24 * time.Hour)
 	claims := &models.Claims{
 		Username: user.Username,
@@ -166,8 +173,8 @@ func (h *Handler) GetCurrentEmployee(c *gin.Context) {
 		return
 	}
 
-	if user.Role == "admin" {
-		// For admin, we don't need to fetch employee data
+	if user.Role == "admin" && user.EmployeeID == nil {
+		// Admins without an employee have no employee data
 		h.respondWithSuccess(c, http.StatusOK, gin.H{
 			"id":   user.ID,
 			"name": user.Username,
@@ -176,8 +183,12 @@ func (h *Handler) GetCurrentEmployee(c *gin.Context) {
 		return
 	}
 
-	var employee models.Employee
-	if err := h.DB.Where("name = ?", user.Username).Preload("CE").Preload("Sector").Preload("Skills").First(&employee).Error; err != nil {
+	employee, err := h.currentEmployee(c)
+	if errors.Is(err, ErrNoEmployeeLinked) {
+		h.respondWithError(c, http.StatusNotFound, "No employee found for the current user")
+		return
+	}
+	if err != nil {
 		h.respondWithError(c, http.StatusInternalServerError, "Failed to fetch employee data")
 		return
 	}
@@ -191,3 +202,27 @@ func (h *Handler) GetCurrentEmployee(c *gin.Context) {
 		"role":   user.Role,
 	})
 }
+
+// currentEmployee returns the employee record linked to the account of the
+// logged in user.
+func (h *Handler) currentEmployee(c *gin.Context) (models.Employee, error) {
+	var employee models.Employee
+	username, exists := c.Get("username")
+	if !exists {
+		return employee, errors.New("User not authenticated")
+	}
+
+	user, err := findUser(h.DB, username.(string))
+	if err != nil {
+		return employee, err
+	}
+	if user.EmployeeID == nil {
+		return employee, ErrNoEmployeeLinked
+	}
+
+	err = h.DB.Preload("CE").Preload("Sector").Preload("Skills").First(&employee, *user.EmployeeID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return employee, ErrNoEmployeeLinked
+	}
+	return employee, err
+}
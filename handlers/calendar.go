@@ -0,0 +1,64 @@
+package handlers
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// Day codes used by the planning grid, Monday first.
+var weekdayCodes = [7]string{"Lu", "Ma", "Me", "Je", "Ve", "Sa", "Di"}
+
+// isoWeekStart returns the Monday of the given ISO-8601 week. Week 1 is the
+// week containing January 4th, so it may start in the previous calendar year.
+func isoWeekStart(year, week int) time.Time {
+	jan4 := time.Date(year, 1, 4, 0, 0, 0, 0, time.UTC)
+	sinceMonday := (int(jan4.Weekday()) + 6) % 7
+	return jan4.AddDate(0, 0, (week-1)*7-sinceMonday)
+}
+
+// isoWeeksInYear returns 52 or 53, the number of ISO weeks in the year.
+func isoWeeksInYear(year int) int {
+	// December 28th always falls in the last ISO week of its year
+	_, week := time.Date(year, 12, 28, 0, 0, 0, 0, time.UTC).ISOWeek()
+	return week
+}
+
+func isValidISOWeek(year, week int) bool {
+	return week >= 1 && week <= isoWeeksInYear(year)
+}
+
+// isoWeekRange returns the first day of the ISO week and the first day of the
+// following week, suitable for "date >= from AND date < to" filters.
+func isoWeekRange(year, week int) (time.Time, time.Time) {
+	start := isoWeekStart(year, week)
+	return start, start.AddDate(0, 0, 7)
+}
+
+// weekdayIndex returns the position of the date in an ISO week, 0 = Monday.
+func weekdayIndex(date time.Time) int {
+	return (int(date.Weekday()) + 6) % 7
+}
+
+func weekdayCode(date time.Time) string {
+	return weekdayCodes[weekdayIndex(date)]
+}
+
+// parseISOWeek reads the year and week query values. The year defaults to the
+// current ISO year.
+func parseISOWeek(yearStr, weekStr string) (int, int, error) {
+	year, _ := time.Now().ISOWeek()
+	if yearStr != "" {
+		var err error
+		if year, err = strconv.Atoi(yearStr); err != nil {
+			return 0, 0, fmt.Errorf("invalid year parameter")
+		}
+	}
+
+	week, err := strconv.Atoi(weekStr)
+	if err != nil || !isValidISOWeek(year, week) {
+		return 0, 0, fmt.Errorf("invalid week parameter")
+	}
+
+	return year, week, nil
+}
@@ -29,7 +29,13 @@ func (h *Handler) AddCE(c *gin.Context) {
 
 	ce := models.CE{Name: input.Name}
 
-	if err := h.DB.Create(&ce).Error; err != nil {
+	err := h.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&ce).Error; err != nil {
+			return err
+		}
+		return h.audit(c, tx, AuditCreate, "ce", ce.ID, nil, ce)
+	})
+	if err != nil {
 		h.respondWithError(c, http.StatusInternalServerError, "Failed to create CE")
 		return
 	}
@@ -54,9 +60,16 @@ func (h *Handler) UpdateCE(c *gin.Context) {
 		return
 	}
 
+	before := ce
 	ce.Name = input.Name
 
-	if err := h.DB.Save(&ce).Error; err != nil {
+	err := h.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(&ce).Error; err != nil {
+			return err
+		}
+		return h.audit(c, tx, AuditUpdate, "ce", ce.ID, before, ce)
+	})
+	if err != nil {
 		h.respondWithError(c, http.StatusInternalServerError, "Failed to update CE")
 		return
 	}
@@ -67,7 +80,19 @@ func (h *Handler) UpdateCE(c *gin.Context) {
 func (h *Handler) DeleteCE(c *gin.Context) {
 	id := c.Param("id")
 
-	if err := h.DB.Delete(&models.CE{}, id).Error; err != nil {
+	var ce models.CE
+	if err := h.DB.First(&ce, id).Error; err != nil {
+		h.respondWithError(c, http.StatusNotFound, "CE not found")
+		return
+	}
+
+	err := h.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Delete(&ce).Error; err != nil {
+			return err
+		}
+		return h.audit(c, tx, AuditDelete, "ce", ce.ID, ce, nil)
+	})
+	if err != nil {
 		h.respondWithError(c, http.StatusInternalServerError, "Failed to delete CE")
 		return
 	}
@@ -0,0 +1,548 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"planning_hager/models"
+)
+
+// Operations recorded as change sets.
+const (
+	ChangeSetPopulateYear = "populate_yearly_planning"
+	ChangeSetWeekRegime   = "set_week_regime"
+	ChangeSetBulkUpdate   = "bulk_update_planning"
+	ChangeSetCEPlanning   = "add_ce_planning"
+	ChangeSetShiftSwap    = "approve_shift_swap"
+	ChangeSetRevert       = "revert"
+)
+
+func changeSetItem(entity, action string, entityID uint, before, after interface{}) (models.ChangeSetItem, error) {
+	beforeJSON, err := auditJSON(before)
+	if err != nil {
+		return models.ChangeSetItem{}, err
+	}
+	afterJSON, err := auditJSON(after)
+	if err != nil {
+		return models.ChangeSetItem{}, err
+	}
+
+	return models.ChangeSetItem{
+		Entity:   entity,
+		EntityID: entityID,
+		Action:   action,
+		Before:   beforeJSON,
+		After:    afterJSON,
+	}, nil
+}
+
+// planningChangeSetItems lists the rows of an applied planning diff.
+func planningChangeSetItems(diff planningDiff) ([]models.ChangeSetItem, error) {
+	var items []models.ChangeSetItem
+	add := func(action string, id uint, before, after interface{}) error {
+		item, err := changeSetItem("planning", action, id, before, after)
+		items = append(items, item)
+		return err
+	}
+
+	for _, p := range diff.Created {
+		if err := add(AuditCreate, p.ID, nil, p); err != nil {
+			return nil, err
+		}
+	}
+	for _, change := range diff.Updated {
+		if err := add(AuditUpdate, change.After.ID, change.Before, change.After); err != nil {
+			return nil, err
+		}
+	}
+	for _, p := range diff.Deleted {
+		if err := add(AuditDelete, p.ID, p, nil); err != nil {
+			return nil, err
+		}
+	}
+
+	return items, nil
+}
+
+// recordChangeSet stores the items changed by an operation within tx, the
+// transaction of the operation.
+func (h *Handler) recordChangeSet(c *gin.Context, tx *gorm.DB, operation, description string, items []models.ChangeSetItem) (models.ChangeSet, error) {
+	return createChangeSet(tx, c.GetString("username"), operation, description, items)
+}
+
+func createChangeSet(tx *gorm.DB, username, operation, description string, items []models.ChangeSetItem) (models.ChangeSet, error) {
+	changeSet := models.ChangeSet{
+		Operation:   operation,
+		Description: description,
+		CreatedBy:   username,
+	}
+	if err := tx.Create(&changeSet).Error; err != nil {
+		return changeSet, err
+	}
+
+	if len(items) == 0 {
+		return changeSet, nil
+	}
+	for i := range items {
+		items[i].ChangeSetID = changeSet.ID
+	}
+	return changeSet, tx.CreateInBatches(&items, 100).Error
+}
+
+// recordPlanningChangeSet stores the rows of an applied planning diff as a
+// change set.
+func (h *Handler) recordPlanningChangeSet(c *gin.Context, tx *gorm.DB, operation, description string, diff planningDiff) (models.ChangeSet, error) {
+	return createPlanningChangeSet(tx, c.GetString("username"), operation, description, diff)
+}
+
+func createPlanningChangeSet(tx *gorm.DB, username, operation, description string, diff planningDiff) (models.ChangeSet, error) {
+	items, err := planningChangeSetItems(diff)
+	if err != nil {
+		return models.ChangeSet{}, err
+	}
+	return createChangeSet(tx, username, operation, description, items)
+}
+
+type changeSetItemEntry struct {
+	ID       uint            `json:"id"`
+	Entity   string          `json:"entity"`
+	EntityID uint            `json:"entity_id"`
+	Action   string          `json:"action"`
+	Before   json.RawMessage `json:"before"`
+	After    json.RawMessage `json:"after"`
+}
+
+type changeSetEntry struct {
+	models.ChangeSet
+	Items []changeSetItemEntry `json:"items"`
+}
+
+func (h *Handler) GetChangeSets(c *gin.Context) {
+	query := h.DB.Model(&models.ChangeSet{})
+	if operation := c.Query("operation"); operation != "" {
+		query = query.Where("operation = ?", operation)
+	}
+
+	limit := 50
+	if value := c.Query("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n <= 0 || n > 1000 {
+			h.respondWithError(c, http.StatusBadRequest, "Invalid limit parameter")
+			return
+		}
+		limit = n
+	}
+
+	var changeSets []models.ChangeSet
+	if err := query.Order("created_at DESC, id DESC").Limit(limit).Find(&changeSets).Error; err != nil {
+		h.respondWithError(c, http.StatusInternalServerError, "Failed to fetch change sets")
+		return
+	}
+
+	h.respondWithSuccess(c, http.StatusOK, changeSets)
+}
+
+func (h *Handler) GetChangeSet(c *gin.Context) {
+	id := c.Param("id")
+
+	var changeSet models.ChangeSet
+	if err := h.DB.Preload("Items").First(&changeSet, id).Error; err != nil {
+		h.respondWithError(c, http.StatusNotFound, "Change set not found")
+		return
+	}
+
+	items := make([]changeSetItemEntry, len(changeSet.Items))
+	for i, item := range changeSet.Items {
+		items[i] = changeSetItemEntry{
+			ID:       item.ID,
+			Entity:   item.Entity,
+			EntityID: item.EntityID,
+			Action:   item.Action,
+			Before:   rawJSON(item.Before),
+			After:    rawJSON(item.After),
+		}
+	}
+	changeSet.Items = nil
+
+	h.respondWithSuccess(c, http.StatusOK, changeSetEntry{ChangeSet: changeSet, Items: items})
+}
+
+type changeSetConflict struct {
+	Entity   string `json:"entity"`
+	EntityID uint   `json:"entity_id"`
+	Message  string `json:"message"`
+}
+
+// samePlanningValues reports whether two rows hold the same assignment,
+// ignoring their bookkeeping fields.
+func samePlanningValues(a, b models.Planning) bool {
+	return a.Date.Equal(b.Date) &&
+		a.Shift == b.Shift &&
+		sameUint(a.CEID, b.CEID) &&
+		sameUint(a.SectorID, b.SectorID) &&
+		sameUint(a.EmployeeID, b.EmployeeID) &&
+		a.Status == b.Status &&
+		sameUint(a.SubstituteID, b.SubstituteID) &&
+		sameUint(a.SubstituteReservistID, b.SubstituteReservistID) &&
+		a.Source == b.Source &&
+		sameUint(a.AbsenceRequestID, b.AbsenceRequestID) &&
+		a.LeaveType == b.LeaveType
+}
+
+// restoredPlanning returns the row as it was before, keeping the identity of
+// the current row.
+func restoredPlanning(current, before models.Planning) models.Planning {
+	restored := before
+	restored.Model = current.Model
+	restored.DeletedAt = gorm.DeletedAt{}
+	restored.CE, restored.Sector, restored.Employee = nil, nil, nil
+	restored.Substitute, restored.SubstituteReservist = nil, nil
+	return restored
+}
+
+func decodeChangeSetValue(data string, value interface{}) error {
+	if data == "" {
+		return nil
+	}
+	return json.Unmarshal([]byte(data), value)
+}
+
+// planningRevert computes the diff undoing the planning items of a change
+// set, or the conflicts with rows changed since.
+func planningRevert(tx *gorm.DB, items []models.ChangeSetItem) (planningDiff, []changeSetConflict, error) {
+	diff := planningDiff{
+		Created: []models.Planning{},
+		Updated: []planningChange{},
+		Deleted: []models.Planning{},
+	}
+	var conflicts []changeSetConflict
+	conflict := func(id uint, message string) {
+		conflicts = append(conflicts, changeSetConflict{Entity: "planning", EntityID: id, Message: message})
+	}
+
+	for i := len(items) - 1; i >= 0; i-- {
+		item := items[i]
+		if item.Entity != "planning" {
+			continue
+		}
+
+		var before, after models.Planning
+		if err := decodeChangeSetValue(item.Before, &before); err != nil {
+			return diff, nil, err
+		}
+		if err := decodeChangeSetValue(item.After, &after); err != nil {
+			return diff, nil, err
+		}
+
+		var current models.Planning
+		err := tx.Unscoped().First(&current, item.EntityID).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			conflict(item.EntityID, "Planning entry no longer exists")
+			continue
+		}
+		if err != nil {
+			return diff, nil, err
+		}
+
+		switch item.Action {
+		case AuditCreate, AuditUpdate:
+			if current.DeletedAt.Valid {
+				conflict(item.EntityID, "Planning entry has been deleted since")
+				continue
+			}
+			if !samePlanningValues(current, after) {
+				conflict(item.EntityID, "Planning entry has been changed since")
+				continue
+			}
+			if item.Action == AuditCreate {
+				diff.Deleted = append(diff.Deleted, current)
+			} else {
+				diff.Updated = append(diff.Updated, planningChange{Before: current, After: restoredPlanning(current, before)})
+			}
+
+		case AuditDelete:
+			if !current.DeletedAt.Valid {
+				conflict(item.EntityID, "Planning entry has been restored since")
+				continue
+			}
+
+			// The slot may have been filled by another row since
+			var occupants []models.Planning
+			if err := tx.Where("date = ? AND shift = ?", before.Date, before.Shift).Find(&occupants).Error; err != nil {
+				return diff, nil, err
+			}
+			occupied := false
+			for _, p := range occupants {
+				if planningKey(p) == planningKey(before) {
+					occupied = true
+				}
+			}
+			if occupied {
+				conflict(item.EntityID, "Planning slot has been filled since")
+				continue
+			}
+			diff.Created = append(diff.Created, restoredPlanning(current, before))
+		}
+	}
+
+	return diff, conflicts, nil
+}
+
+// applyPlanningRevert applies a diff computed by planningRevert. Rows it
+// creates are deleted rows brought back, so they keep their IDs.
+func applyPlanningRevert(tx *gorm.DB, diff planningDiff) error {
+	for i := range diff.Created {
+		if err := tx.Unscoped().Omit(clause.Associations).Save(&diff.Created[i]).Error; err != nil {
+			return err
+		}
+	}
+	for i := range diff.Updated {
+		if err := tx.Omit(clause.Associations).Save(&diff.Updated[i].After).Error; err != nil {
+			return err
+		}
+	}
+	for i := range diff.Deleted {
+		if err := tx.Delete(&diff.Deleted[i]).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type weekRegimeRevert struct {
+	action string
+	before *models.WeekRegime
+	after  *models.WeekRegime
+}
+
+// weekRegimeReverts computes how to undo the week regime items of a change
+// set, or the conflicts with regimes changed since.
+func weekRegimeReverts(tx *gorm.DB, items []models.ChangeSetItem) ([]weekRegimeRevert, []changeSetConflict, error) {
+	var reverts []weekRegimeRevert
+	var conflicts []changeSetConflict
+
+	for i := len(items) - 1; i >= 0; i-- {
+		item := items[i]
+		if item.Entity != "week_regime" {
+			continue
+		}
+
+		var before, after models.WeekRegime
+		if err := decodeChangeSetValue(item.Before, &before); err != nil {
+			return nil, nil, err
+		}
+		if err := decodeChangeSetValue(item.After, &after); err != nil {
+			return nil, nil, err
+		}
+
+		key := after
+		if item.Action == AuditDelete {
+			key = before
+		}
+
+		var current models.WeekRegime
+		err := tx.Where("year = ? AND week = ?", key.Year, key.Week).First(&current).Error
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil, err
+		}
+		exists := err == nil
+
+		switch {
+		case item.Action == AuditDelete && exists:
+			conflicts = append(conflicts, changeSetConflict{Entity: "week_regime", EntityID: item.EntityID, Message: "Week regime has been set since"})
+		case item.Action == AuditDelete:
+			restored := before
+			restored.ID = 0
+			reverts = append(reverts, weekRegimeRevert{action: AuditCreate, after: &restored})
+		case !exists || current.Regime != after.Regime:
+			conflicts = append(conflicts, changeSetConflict{Entity: "week_regime", EntityID: item.EntityID, Message: "Week regime has been changed since"})
+		case item.Action == AuditCreate:
+			reverts = append(reverts, weekRegimeRevert{action: AuditDelete, before: &current})
+		default:
+			restored := current
+			restored.Regime = before.Regime
+			reverts = append(reverts, weekRegimeRevert{action: AuditUpdate, before: &current, after: &restored})
+		}
+	}
+
+	return reverts, conflicts, nil
+}
+
+func applyWeekRegimeRevert(tx *gorm.DB, revert weekRegimeRevert) (models.ChangeSetItem, error) {
+	switch revert.action {
+	case AuditCreate:
+		if err := tx.Create(revert.after).Error; err != nil {
+			return models.ChangeSetItem{}, err
+		}
+		return changeSetItem("week_regime", AuditCreate, revert.after.ID, nil, revert.after)
+	case AuditDelete:
+		if err := tx.Delete(revert.before).Error; err != nil {
+			return models.ChangeSetItem{}, err
+		}
+		return changeSetItem("week_regime", AuditDelete, revert.before.ID, revert.before, nil)
+	default:
+		if err := tx.Save(revert.after).Error; err != nil {
+			return models.ChangeSetItem{}, err
+		}
+		return changeSetItem("week_regime", AuditUpdate, revert.after.ID, revert.before, revert.after)
+	}
+}
+
+// RevertChangeSet undoes a planning operation. It is refused when any row the
+// operation changed has been changed again since; the revert is itself
+// recorded as a change set.
+func (h *Handler) RevertChangeSet(c *gin.Context) {
+	id := c.Param("id")
+	// The override reason is only needed for past and published periods, so
+	// the body is optional
+	var input struct {
+		OverrideReason string `json:"override_reason"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil && !errors.Is(err, io.EOF) {
+		h.respondWithError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	tx := h.DB.Begin()
+
+	var changeSet models.ChangeSet
+	if err := tx.Preload("Items", func(db *gorm.DB) *gorm.DB {
+		return db.Order("id")
+	}).First(&changeSet, id).Error; err != nil {
+		tx.Rollback()
+		h.respondWithError(c, http.StatusNotFound, "Change set not found")
+		return
+	}
+	if changeSet.RevertedAt != nil {
+		tx.Rollback()
+		h.respondWithError(c, http.StatusConflict, "Change set has already been reverted")
+		return
+	}
+
+	regimeReverts, regimeConflicts, err := weekRegimeReverts(tx, changeSet.Items)
+	if err != nil {
+		tx.Rollback()
+		h.respondWithError(c, http.StatusInternalServerError, "Failed to compute revert")
+		return
+	}
+	diff, conflicts, err := planningRevert(tx, changeSet.Items)
+	if err != nil {
+		tx.Rollback()
+		h.respondWithError(c, http.StatusInternalServerError, "Failed to compute revert")
+		return
+	}
+	conflicts = append(regimeConflicts, conflicts...)
+	if len(conflicts) > 0 {
+		tx.Rollback()
+		c.JSON(http.StatusConflict, gin.H{
+			"error":     "Change set conflicts with later changes",
+			"conflicts": conflicts,
+		})
+		return
+	}
+
+	// A change set of week regimes alone touches no planning row, and so no
+	// period to check
+	rows := append(diff.touched(), diff.Deleted...)
+	from, to := planningDateRange(rows)
+	var baseline labourBaseline
+	if len(rows) > 0 {
+		if !h.checkPlanningUnlocked(c, tx, from, to, overrideReason(c, input.OverrideReason)) {
+			return
+		}
+		var ok bool
+		if baseline, ok = h.startLabourCheck(c, tx, from, to); !ok {
+			return
+		}
+	}
+
+	var items []models.ChangeSetItem
+	for _, revert := range regimeReverts {
+		item, err := applyWeekRegimeRevert(tx, revert)
+		if err != nil {
+			tx.Rollback()
+			h.respondWithError(c, http.StatusInternalServerError, "Failed to revert week regime")
+			return
+		}
+		if err := h.audit(c, tx, item.Action, "week_regime", item.EntityID, revert.before, revert.after); err != nil {
+			tx.Rollback()
+			h.respondWithError(c, http.StatusInternalServerError, "Failed to record audit log")
+			return
+		}
+		items = append(items, item)
+	}
+
+	username := c.GetString("username")
+	if err := refundLeaveDebits(tx, diff.Deleted, username); err != nil {
+		tx.Rollback()
+		h.respondWithError(c, http.StatusInternalServerError, "Failed to update leave balance")
+		return
+	}
+	if err := applyPlanningRevert(tx, diff); err != nil {
+		tx.Rollback()
+		h.respondWithError(c, http.StatusInternalServerError, "Failed to revert planning entries")
+		return
+	}
+	if err := syncLeaveDebits(tx, diff.touched(), username); err != nil {
+		tx.Rollback()
+		h.respondWithError(c, http.StatusInternalServerError, "Failed to update leave balance")
+		return
+	}
+
+	if err := h.auditPlanningDiff(c, tx, diff); err != nil {
+		tx.Rollback()
+		h.respondWithError(c, http.StatusInternalServerError, "Failed to record audit log")
+		return
+	}
+
+	planningItems, err := planningChangeSetItems(diff)
+	if err != nil {
+		tx.Rollback()
+		h.respondWithError(c, http.StatusInternalServerError, "Failed to record change set")
+		return
+	}
+	revertSet, err := h.recordChangeSet(c, tx, ChangeSetRevert, fmt.Sprintf("Revert of change set %d", changeSet.ID), append(items, planningItems...))
+	if err != nil {
+		tx.Rollback()
+		h.respondWithError(c, http.StatusInternalServerError, "Failed to record change set")
+		return
+	}
+
+	now := time.Now()
+	changeSet.RevertedBy = username
+	changeSet.RevertedAt = &now
+	changeSet.RevertChangeSetID = &revertSet.ID
+	if err := tx.Omit("Items").Save(&changeSet).Error; err != nil {
+		tx.Rollback()
+		h.respondWithError(c, http.StatusInternalServerError, "Failed to update change set")
+		return
+	}
+
+	violations := []labourViolation{}
+	if len(rows) > 0 {
+		var ok bool
+		violations, ok = h.checkLabourRules(c, tx, baseline, planningEmployeeIDs(diff.touched()), from, to)
+		if !ok {
+			return
+		}
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		h.respondWithError(c, http.StatusInternalServerError, "Failed to commit transaction")
+		return
+	}
+
+	h.respondWithSuccess(c, http.StatusOK, gin.H{
+		"change_set": revertSet,
+		"summary":    diff.summary(),
+		"violations": violations,
+	})
+}
@@ -0,0 +1,149 @@
+package handlers
+
+import (
+	"testing"
+
+	"gorm.io/gorm"
+	"planning_hager/models"
+)
+
+// revertFixture applies a change set creating, updating and deleting one row
+// each, and returns its items with the rows as the change set left them.
+func revertFixture(t *testing.T) (db *gorm.DB, items []models.ChangeSetItem, created, updated, deleted models.Planning) {
+	t.Helper()
+	db = newTestDB(t)
+	employees := seedTeams(t, db)
+
+	row := func(employee models.Employee) models.Planning {
+		p := models.Planning{
+			Date: utcDate(2030, 3, 4), Year: testYear, Week: testWeek, Shift: "M",
+			EmployeeID: &employee.ID, SectorID: &employee.SectorID, Status: StatusScheduled,
+		}
+		if err := db.Create(&p).Error; err != nil {
+			t.Fatalf("creating row: %v", err)
+		}
+		return p
+	}
+	created, updated, deleted = row(employees[0]), row(employees[1]), row(employees[2])
+
+	before := updated
+	updated.Status = StatusTraining
+	if err := db.Save(&updated).Error; err != nil {
+		t.Fatalf("updating row: %v", err)
+	}
+	if err := db.Delete(&deleted).Error; err != nil {
+		t.Fatalf("deleting row: %v", err)
+	}
+
+	items, err := planningChangeSetItems(planningDiff{
+		Created: []models.Planning{created},
+		Updated: []planningChange{{Before: before, After: updated}},
+		Deleted: []models.Planning{deleted},
+	})
+	if err != nil {
+		t.Fatalf("planningChangeSetItems: %v", err)
+	}
+	return db, items, created, updated, deleted
+}
+
+func TestPlanningRevert(t *testing.T) {
+	db, items, created, updated, deleted := revertFixture(t)
+
+	diff, conflicts, err := planningRevert(db, items)
+	if err != nil {
+		t.Fatalf("planningRevert: %v", err)
+	}
+	if len(conflicts) != 0 {
+		t.Fatalf("planningRevert found conflicts %v on an unchanged planning", conflicts)
+	}
+	if len(diff.Deleted) != 1 || diff.Deleted[0].ID != created.ID {
+		t.Errorf("revert deletes %v, want the created row %d", diff.Deleted, created.ID)
+	}
+	if len(diff.Updated) != 1 || diff.Updated[0].After.ID != updated.ID || diff.Updated[0].After.Status != StatusScheduled {
+		t.Errorf("revert updates %v, want row %d back to %s", diff.Updated, updated.ID, StatusScheduled)
+	}
+	if len(diff.Created) != 1 || diff.Created[0].ID != deleted.ID || diff.Created[0].DeletedAt.Valid {
+		t.Errorf("revert creates %v, want the deleted row %d restored", diff.Created, deleted.ID)
+	}
+}
+
+func TestPlanningRevertConflicts(t *testing.T) {
+	tests := []struct {
+		name    string
+		change  func(t *testing.T, db *gorm.DB, created, updated, deleted models.Planning) uint
+		message string
+	}{
+		{
+			name: "created row changed",
+			change: func(t *testing.T, db *gorm.DB, created, _, _ models.Planning) uint {
+				if err := db.Model(&created).Update("status", StatusDay).Error; err != nil {
+					t.Fatal(err)
+				}
+				return created.ID
+			},
+			message: "Planning entry has been changed since",
+		},
+		{
+			name: "updated row deleted",
+			change: func(t *testing.T, db *gorm.DB, _, updated, _ models.Planning) uint {
+				if err := db.Delete(&updated).Error; err != nil {
+					t.Fatal(err)
+				}
+				return updated.ID
+			},
+			message: "Planning entry has been deleted since",
+		},
+		{
+			name: "created row purged",
+			change: func(t *testing.T, db *gorm.DB, created, _, _ models.Planning) uint {
+				if err := db.Unscoped().Delete(&created).Error; err != nil {
+					t.Fatal(err)
+				}
+				return created.ID
+			},
+			message: "Planning entry no longer exists",
+		},
+		{
+			name: "deleted row restored",
+			change: func(t *testing.T, db *gorm.DB, _, _, deleted models.Planning) uint {
+				if err := db.Unscoped().Model(&deleted).Update("deleted_at", nil).Error; err != nil {
+					t.Fatal(err)
+				}
+				return deleted.ID
+			},
+			message: "Planning entry has been restored since",
+		},
+		{
+			name: "slot of the deleted row filled",
+			change: func(t *testing.T, db *gorm.DB, _, _, deleted models.Planning) uint {
+				replacement := deleted
+				replacement.ID = 0
+				replacement.DeletedAt = gorm.DeletedAt{}
+				if err := db.Create(&replacement).Error; err != nil {
+					t.Fatal(err)
+				}
+				return deleted.ID
+			},
+			message: "Planning slot has been filled since",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, items, created, updated, deleted := revertFixture(t)
+			id := tt.change(t, db, created, updated, deleted)
+
+			diff, conflicts, err := planningRevert(db, items)
+			if err != nil {
+				t.Fatalf("planningRevert: %v", err)
+			}
+			if len(conflicts) != 1 || conflicts[0].EntityID != id || conflicts[0].Message != tt.message {
+				t.Fatalf("conflicts = %v, want %q on row %d", conflicts, tt.message, id)
+			}
+			// The other rows can still be reverted
+			if got := len(diff.Created) + len(diff.Updated) + len(diff.Deleted); got != 2 {
+				t.Errorf("revert changes %d rows beside the conflict, want 2", got)
+			}
+		})
+	}
+}
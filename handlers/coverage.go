@@ -0,0 +1,216 @@
+package handlers
+
+import (
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"planning_hager/models"
+)
+
+type coveragePerson struct {
+	PlanningID uint   `json:"planning_id"`
+	ID         uint   `json:"id"`
+	Name       string `json:"name"`
+	Type       string `json:"type,omitempty"`
+	Status     string `json:"status,omitempty"`
+	Substitute string `json:"substitute,omitempty"`
+}
+
+type coverageSkill struct {
+	ID   uint   `json:"id"`
+	Name string `json:"name"`
+}
+
+type sectorCoverage struct {
+	Date              time.Time        `json:"date"`
+	Day               string           `json:"day"`
+	Shift             string           `json:"shift"`
+	SectorID          uint             `json:"sector_id"`
+	SectorName        string           `json:"sector_name"`
+	Present           []coveragePerson `json:"present"`
+	Absent            []coveragePerson `json:"absent"`
+	MissingSkills     []coverageSkill  `json:"missing_skills"`
+	UnfilledPositions int              `json:"unfilled_positions"`
+	Covered           bool             `json:"covered"`
+}
+
+// leavesPositionVacant reports whether the planned employee does not hold
+// their position for the shift, so a substitute is needed.
+func leavesPositionVacant(status string) bool {
+	switch status {
+	case StatusAbsentP, StatusAbsentU, StatusTraining, StatusDay:
+		return true
+	}
+	return false
+}
+
+// computeCoverage checks, for every date/shift worked and every sector, that
+// the people actually present hold the skills the sector requires. A date and
+// shift is considered worked as soon as it has a planning entry.
+func computeCoverage(plannings []models.Planning, sectors []models.Sector, shifts map[string]models.ShiftDefinition) []sectorCoverage {
+	type slotKey struct {
+		date  string
+		shift string
+	}
+
+	slotDates := make(map[slotKey]time.Time)
+	bySlotSector := make(map[slotKey]map[uint][]models.Planning)
+	for _, p := range plannings {
+		key := slotKey{p.Date.Format("2006-01-02"), p.Shift}
+		slotDates[key] = p.Date
+		if p.SectorID == nil {
+			continue
+		}
+		if bySlotSector[key] == nil {
+			bySlotSector[key] = make(map[uint][]models.Planning)
+		}
+		bySlotSector[key][*p.SectorID] = append(bySlotSector[key][*p.SectorID], p)
+	}
+
+	slots := make([]slotKey, 0, len(slotDates))
+	for key := range slotDates {
+		slots = append(slots, key)
+	}
+	sort.Slice(slots, func(i, j int) bool {
+		if slots[i].date != slots[j].date {
+			return slots[i].date < slots[j].date
+		}
+		// Order the shifts of a day by start time
+		return shifts[slots[i].shift].StartTime+slots[i].shift < shifts[slots[j].shift].StartTime+slots[j].shift
+	})
+
+	report := []sectorCoverage{}
+	for _, key := range slots {
+		for _, sector := range sectors {
+			coverage := sectorCoverage{
+				Date:          slotDates[key],
+				Day:           weekdayCode(slotDates[key]),
+				Shift:         key.shift,
+				SectorID:      sector.ID,
+				SectorName:    sector.Name,
+				Present:       []coveragePerson{},
+				Absent:        []coveragePerson{},
+				MissingSkills: []coverageSkill{},
+			}
+
+			held := make(map[uint]bool)
+			entries := bySlotSector[key][sector.ID]
+			for _, p := range entries {
+				if p.Employee != nil && !leavesPositionVacant(p.Status) {
+					coverage.Present = append(coverage.Present, coveragePerson{PlanningID: p.ID, ID: p.Employee.ID, Name: p.Employee.Name})
+					for _, skill := range p.Employee.Skills {
+						held[skill.ID] = true
+					}
+					continue
+				}
+
+				var substitute *coveragePerson
+				var substituteSkills []models.Skill
+				if p.Substitute != nil {
+					substitute = &coveragePerson{PlanningID: p.ID, ID: p.Substitute.ID, Name: p.Substitute.Name}
+					substituteSkills = p.Substitute.Skills
+				} else if p.SubstituteReservist != nil {
+					substitute = &coveragePerson{PlanningID: p.ID, ID: p.SubstituteReservist.ID, Name: p.SubstituteReservist.Name, Type: CandidateReservist}
+					substituteSkills = p.SubstituteReservist.Skills
+				}
+
+				if p.Employee != nil {
+					absent := coveragePerson{PlanningID: p.ID, ID: p.Employee.ID, Name: p.Employee.Name, Status: p.Status}
+					if substitute != nil {
+						absent.Substitute = substitute.Name
+					}
+					coverage.Absent = append(coverage.Absent, absent)
+				}
+
+				if substitute == nil {
+					coverage.UnfilledPositions++
+					continue
+				}
+				coverage.Present = append(coverage.Present, *substitute)
+				for _, skill := range substituteSkills {
+					held[skill.ID] = true
+				}
+			}
+
+			if len(entries) == 0 {
+				coverage.UnfilledPositions = 1
+			}
+
+			for _, skill := range sector.RequiredSkills {
+				if !held[skill.ID] {
+					coverage.MissingSkills = append(coverage.MissingSkills, coverageSkill{ID: skill.ID, Name: skill.Name})
+				}
+			}
+
+			coverage.Covered = coverage.UnfilledPositions == 0 && len(coverage.MissingSkills) == 0
+			report = append(report, coverage)
+		}
+	}
+
+	return report
+}
+
+// GetPlanningCoverage reports the coverage of a week. Draft weeks report
+// nothing to users other than admins.
+func (h *Handler) GetPlanningCoverage(c *gin.Context) {
+	year, week, err := parseISOWeek(c.Query("year"), c.Query("week"))
+	if err != nil {
+		h.respondWithError(c, http.StatusBadRequest, "Invalid year or week parameter")
+		return
+	}
+
+	hidden, err := h.weekHidden(c, year, week)
+	if err != nil {
+		h.respondWithError(c, http.StatusInternalServerError, "Failed to fetch week publication")
+		return
+	}
+	if hidden {
+		h.respondWithSuccess(c, http.StatusOK, gin.H{
+			"year":      year,
+			"week":      week,
+			"uncovered": 0,
+			"coverage":  []sectorCoverage{},
+		})
+		return
+	}
+
+	from, to := isoWeekRange(year, week)
+
+	var plannings []models.Planning
+	if err := h.DB.Preload("Employee.Skills").Preload("Substitute.Skills").Preload("SubstituteReservist.Skills").
+		Where("date >= ? AND date < ?", from, to).
+		Find(&plannings).Error; err != nil {
+		h.respondWithError(c, http.StatusInternalServerError, "Failed to fetch planning data")
+		return
+	}
+
+	var sectors []models.Sector
+	if err := h.DB.Preload("RequiredSkills").Order("id ASC").Find(&sectors).Error; err != nil {
+		h.respondWithError(c, http.StatusInternalServerError, "Failed to fetch sectors")
+		return
+	}
+
+	shifts, err := loadShiftDefinitions(h.DB)
+	if err != nil {
+		h.respondWithError(c, http.StatusInternalServerError, "Failed to fetch shift definitions")
+		return
+	}
+
+	report := computeCoverage(plannings, sectors, shifts)
+
+	uncovered := 0
+	for _, r := range report {
+		if !r.Covered {
+			uncovered++
+		}
+	}
+
+	h.respondWithSuccess(c, http.StatusOK, gin.H{
+		"year":      year,
+		"week":      week,
+		"uncovered": uncovered,
+		"coverage":  report,
+	})
+}
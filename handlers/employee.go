@@ -70,7 +70,7 @@ func (h *Handler) AddEmployee(c *gin.Context) {
 			return err
 		}
 
-		return nil
+		return h.audit(c, tx, AuditCreate, "employee", employee.ID, nil, employee)
 	})
 
 	if err != nil {
@@ -107,6 +107,14 @@ func (h *Handler) UpdateEmployee(c *gin.Context) {
 		h.respondWithError(c, http.StatusNotFound, "Employee not found")
 		return
 	}
+	before := employee
+
+	// Moving to another CE changes the shifts worked from now on
+	now := time.Now()
+	baseline, ok := h.startLabourCheck(c, tx, now, now.AddDate(1, 0, 0))
+	if !ok {
+		return
+	}
 
 	// Check if there's already an employee in the new position
 	var existingEmployee models.Employee
@@ -122,6 +130,7 @@ func (h *Handler) UpdateEmployee(c *gin.Context) {
 				return
 			}
 			// Swap logic
+			existingBefore := existingEmployee
 			tempCEID := existingEmployee.CEID
 			tempSectorID := existingEmployee.SectorID
 
@@ -134,6 +143,12 @@ func (h *Handler) UpdateEmployee(c *gin.Context) {
 				return
 			}
 
+			if err := h.audit(c, tx, AuditUpdate, "employee", existingEmployee.ID, existingBefore, existingEmployee); err != nil {
+				tx.Rollback()
+				h.respondWithError(c, http.StatusInternalServerError, "Failed to record audit log")
+				return
+			}
+
 			// Update planning for existing employee
 			if err := updateEmployeePlanning(tx, existingEmployee.ID, existingEmployee.CEID, existingEmployee.SectorID); err != nil {
 				tx.Rollback()
@@ -189,6 +204,12 @@ func (h *Handler) UpdateEmployee(c *gin.Context) {
 		}
 	}
 
+	if err := h.audit(c, tx, AuditUpdate, "employee", employee.ID, before, employee); err != nil {
+		tx.Rollback()
+		h.respondWithError(c, http.StatusInternalServerError, "Failed to record audit log")
+		return
+	}
+
 	// Update planning for the employee being edited
 	if err := updateEmployeePlanning(tx, employee.ID, employee.CEID, employee.SectorID); err != nil {
 		tx.Rollback()
@@ -196,6 +217,15 @@ func (h *Handler) UpdateEmployee(c *gin.Context) {
 		return
 	}
 
+	affected := []uint{employee.ID}
+	if existingEmployee.ID != 0 {
+		affected = append(affected, existingEmployee.ID)
+	}
+	violations, ok := h.checkLabourRules(c, tx, baseline, affected, now, now.AddDate(1, 0, 0))
+	if !ok {
+		return
+	}
+
 	if err := tx.Commit().Error; err != nil {
 		h.respondWithError(c, http.StatusInternalServerError, "Failed to commit transaction")
 		return
@@ -207,7 +237,10 @@ func (h *Handler) UpdateEmployee(c *gin.Context) {
 		return
 	}
 
-	h.respondWithSuccess(c, http.StatusOK, employee)
+	h.respondWithSuccess(c, http.StatusOK, struct {
+		models.Employee
+		Violations []labourViolation `json:"violations"`
+	}{employee, violations})
 }
 
 func updateEmployeePlanning(tx *gorm.DB, employeeID, newCEID, newSectorID uint) error {
@@ -290,7 +323,26 @@ func getValue(ptr interface{}) interface{} {
 
 func (h *Handler) DeleteEmployee(c *gin.Context) {
 	id := c.Param("id")
-	if err := h.DB.Delete(&models.Employee{}, id).Error; err != nil {
+
+	var employee models.Employee
+	if err := h.DB.Preload("Skills").First(&employee, id).Error; err != nil {
+		h.respondWithError(c, http.StatusNotFound, "Employee not found")
+		return
+	}
+
+	err := h.DB.Transaction(func(tx *gorm.DB) error {
+		// Employees are only soft deleted, so the foreign key does not unlink
+		// their accounts
+		if err := tx.Model(&models.User{}).Where("employee_id = ?", employee.ID).
+			Update("employee_id", nil).Error; err != nil {
+			return err
+		}
+		if err := tx.Delete(&models.Employee{}, employee.ID).Error; err != nil {
+			return err
+		}
+		return h.audit(c, tx, AuditDelete, "employee", employee.ID, employee, nil)
+	})
+	if err != nil {
 		h.respondWithError(c, http.StatusInternalServerError, "Failed to delete employee")
 		return
 	}
@@ -0,0 +1,297 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/xuri/excelize/v2"
+	"planning_hager/models"
+)
+
+// Rosters are exported a month or a quarter at a time at most.
+const maxRosterDays = 93
+
+// rosterRow is the line of an employee in the roster, within a CE and sector.
+// Cells and Colors are indexed by day from the first day of the roster.
+type rosterRow struct {
+	CE       string
+	Sector   string
+	Employee string
+	Cells    []string
+	Colors   []string
+}
+
+func isHexColor(color string) bool {
+	color = strings.TrimPrefix(color, "#")
+	if len(color) != 6 {
+		return false
+	}
+	_, err := strconv.ParseUint(color, 16, 32)
+	return err == nil
+}
+
+type roster struct {
+	Days []time.Time
+	Rows []rosterRow
+}
+
+// rosterCell describes the row of an employee on a day: the shift code,
+// followed by the status unless the employee works as scheduled.
+func rosterCell(p models.Planning, substituting bool) string {
+	switch {
+	case substituting && p.Employee != nil:
+		return fmt.Sprintf("%s (replacing %s)", p.Shift, p.Employee.Name)
+	case substituting:
+		return p.Shift + " (substitute)"
+	case p.Status == "" || p.Status == StatusScheduled:
+		return p.Shift
+	default:
+		return fmt.Sprintf("%s (%s)", p.Shift, p.Status)
+	}
+}
+
+// buildRoster lays the planning rows out as employees by days, grouped by CE
+// and sector. Rows without an employee, such as CE rows, are left out.
+func buildRoster(plannings []models.Planning, shifts map[string]models.ShiftDefinition, from, to time.Time) roster {
+	var r roster
+	for day := from; day.Before(to); day = day.AddDate(0, 0, 1) {
+		r.Days = append(r.Days, day)
+	}
+
+	rows := make(map[string]*rosterRow)
+	place := func(p models.Planning, employee models.Employee, substituting bool) {
+		ce, sector := "", ""
+		if p.CE != nil {
+			ce = p.CE.Name
+		}
+		if p.Sector != nil {
+			sector = p.Sector.Name
+		}
+
+		key := fmt.Sprintf("%s|%s|%d", ce, sector, employee.ID)
+		row, ok := rows[key]
+		if !ok {
+			row = &rosterRow{
+				CE:       ce,
+				Sector:   sector,
+				Employee: employee.Name,
+				Cells:    make([]string, len(r.Days)),
+				Colors:   make([]string, len(r.Days)),
+			}
+			rows[key] = row
+		}
+
+		date := time.Date(p.Date.Year(), p.Date.Month(), p.Date.Day(), 0, 0, 0, 0, time.UTC)
+		day := int(date.Sub(from).Hours() / 24)
+		if day < 0 || day >= len(r.Days) {
+			return
+		}
+		cell := rosterCell(p, substituting)
+		if row.Cells[day] != "" {
+			cell = row.Cells[day] + " / " + cell
+		}
+		row.Cells[day] = cell
+		if color := shifts[p.Shift].Color; isHexColor(color) && (!isAbsence(p.Status) || substituting) {
+			row.Colors[day] = color
+		}
+	}
+
+	for _, p := range plannings {
+		if p.Employee != nil {
+			place(p, *p.Employee, false)
+		}
+		if p.Substitute != nil {
+			place(p, *p.Substitute, true)
+		}
+	}
+
+	for _, row := range rows {
+		r.Rows = append(r.Rows, *row)
+	}
+	sort.Slice(r.Rows, func(i, j int) bool {
+		a, b := r.Rows[i], r.Rows[j]
+		if a.CE != b.CE {
+			return a.CE < b.CE
+		}
+		if a.Sector != b.Sector {
+			return a.Sector < b.Sector
+		}
+		return a.Employee < b.Employee
+	})
+
+	return r
+}
+
+func (r roster) header() []string {
+	header := []string{"CE", "Sector", "Employee"}
+	for _, day := range r.Days {
+		header = append(header, fmt.Sprintf("%s %s", weekdayCode(day), day.Format("2006-01-02")))
+	}
+	return header
+}
+
+func (r roster) csv() ([]byte, error) {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	if err := w.Write(r.header()); err != nil {
+		return nil, err
+	}
+	for _, row := range r.Rows {
+		record := append([]string{row.CE, row.Sector, row.Employee}, row.Cells...)
+		if err := w.Write(record); err != nil {
+			return nil, err
+		}
+	}
+	w.Flush()
+	return buf.Bytes(), w.Error()
+}
+
+// xlsx renders the roster as a workbook, each shift filled with the colour of
+// its definition.
+func (r roster) xlsx() ([]byte, error) {
+	f := excelize.NewFile()
+	defer f.Close()
+
+	const sheet = "Roster"
+	if err := f.SetSheetName("Sheet1", sheet); err != nil {
+		return nil, err
+	}
+
+	headerStyle, err := f.NewStyle(&excelize.Style{Font: &excelize.Font{Bold: true}})
+	if err != nil {
+		return nil, err
+	}
+	fills := make(map[string]int)
+	fillStyle := func(color string) (int, error) {
+		if style, ok := fills[color]; ok {
+			return style, nil
+		}
+		style, err := f.NewStyle(&excelize.Style{
+			Fill: excelize.Fill{Type: "pattern", Pattern: 1, Color: []string{strings.TrimPrefix(color, "#")}},
+		})
+		fills[color] = style
+		return style, err
+	}
+
+	header := r.header()
+	for i, title := range header {
+		cell, _ := excelize.CoordinatesToCellName(i+1, 1)
+		if err := f.SetCellValue(sheet, cell, title); err != nil {
+			return nil, err
+		}
+	}
+	last, _ := excelize.CoordinatesToCellName(len(header), 1)
+	if err := f.SetCellStyle(sheet, "A1", last, headerStyle); err != nil {
+		return nil, err
+	}
+
+	for i, row := range r.Rows {
+		values := append([]string{row.CE, row.Sector, row.Employee}, row.Cells...)
+		for j, value := range values {
+			cell, _ := excelize.CoordinatesToCellName(j+1, i+2)
+			if err := f.SetCellValue(sheet, cell, value); err != nil {
+				return nil, err
+			}
+		}
+		for day, color := range row.Colors {
+			if color == "" {
+				continue
+			}
+			style, err := fillStyle(color)
+			if err != nil {
+				return nil, err
+			}
+			cell, _ := excelize.CoordinatesToCellName(day+4, i+2)
+			if err := f.SetCellStyle(sheet, cell, cell, style); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	lastColumn, _ := excelize.ColumnNumberToName(len(header))
+	if err := f.SetColWidth(sheet, "A", "C", 20); err != nil {
+		return nil, err
+	}
+	if err := f.SetColWidth(sheet, "D", lastColumn, 16); err != nil {
+		return nil, err
+	}
+	if err := f.SetPanes(sheet, &excelize.Panes{
+		Freeze:      true,
+		XSplit:      3,
+		YSplit:      1,
+		TopLeftCell: "D2",
+		ActivePane:  "bottomRight",
+	}); err != nil {
+		return nil, err
+	}
+
+	buf, err := f.WriteToBuffer()
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// ExportRoster exports the planning between the from and to dates, both
+// included, as CSV or XLSX depending on the format parameter.
+func (h *Handler) ExportRoster(c *gin.Context) {
+	from, err := time.Parse("2006-01-02", c.Query("from"))
+	if err != nil {
+		h.respondWithError(c, http.StatusBadRequest, "Invalid from parameter")
+		return
+	}
+	to, err := time.Parse("2006-01-02", c.Query("to"))
+	if err != nil {
+		h.respondWithError(c, http.StatusBadRequest, "Invalid to parameter")
+		return
+	}
+	to = to.AddDate(0, 0, 1)
+	if !from.Before(to) || to.Sub(from).Hours()/24 > maxRosterDays {
+		h.respondWithError(c, http.StatusBadRequest, fmt.Sprintf("The range must cover 1 to %d days", maxRosterDays))
+		return
+	}
+
+	format := c.DefaultQuery("format", "xlsx")
+	if format != "csv" && format != "xlsx" {
+		h.respondWithError(c, http.StatusBadRequest, "Invalid format parameter")
+		return
+	}
+
+	plannings, err := h.rosterPlannings(c, from, to)
+	if err != nil {
+		h.respondWithError(c, http.StatusInternalServerError, "Failed to fetch planning data")
+		return
+	}
+
+	shifts, err := loadShiftDefinitions(h.DB)
+	if err != nil {
+		h.respondWithError(c, http.StatusInternalServerError, "Failed to fetch shift definitions")
+		return
+	}
+
+	r := buildRoster(plannings, shifts, from, to)
+	filename := fmt.Sprintf("roster_%s_%s.%s", from.Format("2006-01-02"), to.AddDate(0, 0, -1).Format("2006-01-02"), format)
+
+	var data []byte
+	contentType := "text/csv; charset=utf-8"
+	if format == "csv" {
+		data, err = r.csv()
+	} else {
+		data, err = r.xlsx()
+		contentType = "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+	}
+	if err != nil {
+		h.respondWithError(c, http.StatusInternalServerError, "Failed to export roster")
+		return
+	}
+
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filename))
+	c.Data(http.StatusOK, contentType, data)
+}
@@ -0,0 +1,67 @@
+package handlers
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+	"planning_hager/config"
+	"planning_hager/models"
+)
+
+// newTestDB returns a migrated and seeded SQLite database of its own for the
+// test.
+func newTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "planning.db")), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		t.Fatalf("opening database: %v", err)
+	}
+	if err := config.MigrateDB(db); err != nil {
+		t.Fatalf("migrating database: %v", err)
+	}
+	return db
+}
+
+// seedTeams creates the four CEs of the default rotation pattern with one
+// employee each, all in the same sector, and returns the employees.
+func seedTeams(t *testing.T, db *gorm.DB) []models.Employee {
+	t.Helper()
+	sector := models.Sector{Name: "Assemblage"}
+	if err := db.Create(&sector).Error; err != nil {
+		t.Fatalf("creating sector: %v", err)
+	}
+
+	var employees []models.Employee
+	for i, name := range []string{"Jean", "Paul", "Marie", "Lucie"} {
+		ce := models.CE{Name: fmt.Sprintf("CE%d", i+1)}
+		if err := db.Create(&ce).Error; err != nil {
+			t.Fatalf("creating CE: %v", err)
+		}
+		employee := models.Employee{Name: name, CEID: ce.ID, SectorID: sector.ID}
+		if err := db.Create(&employee).Error; err != nil {
+			t.Fatalf("creating employee: %v", err)
+		}
+		employees = append(employees, employee)
+	}
+	return employees
+}
+
+func utcDate(year int, month time.Month, day int) time.Time {
+	return time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+}
+
+func countRows(t *testing.T, db *gorm.DB, model interface{}) int64 {
+	t.Helper()
+	var count int64
+	if err := db.Model(model).Count(&count).Error; err != nil {
+		t.Fatalf("counting rows: %v", err)
+	}
+	return count
+}
@@ -0,0 +1,432 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"planning_hager/models"
+)
+
+// Calendar feeds cover an employee, a CE or a sector.
+const (
+	CalendarScopeEmployee = "employee"
+	CalendarScopeCE       = "ce"
+	CalendarScopeSector   = "sector"
+)
+
+// Feeds cover the last weeks and the year ahead unless a range is asked for.
+const (
+	calendarDaysBefore = 28
+	calendarDaysAfter  = 365
+)
+
+func isValidCalendarScope(scope string) bool {
+	return scope == CalendarScopeEmployee || scope == CalendarScopeCE || scope == CalendarScopeSector
+}
+
+// calendarScopeName returns the name of the employee, CE or sector a feed
+// covers.
+func calendarScopeName(db *gorm.DB, scope string, id uint) (string, error) {
+	switch scope {
+	case CalendarScopeEmployee:
+		var employee models.Employee
+		err := db.First(&employee, id).Error
+		return employee.Name, err
+	case CalendarScopeCE:
+		var ce models.CE
+		err := db.First(&ce, id).Error
+		return ce.Name, err
+	default:
+		var sector models.Sector
+		err := db.First(&sector, id).Error
+		return sector.Name, err
+	}
+}
+
+// calendarPlannings returns the rows of the feed between from and to. Unless
+// drafts are included, only weeks published to everyone are returned.
+func calendarPlannings(db *gorm.DB, scope string, id uint, from, to time.Time, drafts bool) ([]models.Planning, error) {
+	query := db.Preload("Employee").Preload("Sector").Preload("CE").Preload("Substitute").Preload("SubstituteReservist").
+		Where("date >= ? AND date < ?", from, to)
+	switch scope {
+	case CalendarScopeEmployee:
+		query = query.Where("employee_id = ? OR substitute_id = ?", id, id)
+	case CalendarScopeCE:
+		query = query.Where("ce_id = ?", id)
+	default:
+		query = query.Where("sector_id = ?", id)
+	}
+
+	var plannings []models.Planning
+	if err := query.Order("date, shift").Find(&plannings).Error; err != nil {
+		return nil, err
+	}
+	if drafts {
+		return plannings, nil
+	}
+
+	return visiblePlannings(db, plannings)
+}
+
+// icalEscape escapes a TEXT value as RFC 5545 requires.
+func icalEscape(value string) string {
+	return strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`).Replace(value)
+}
+
+// icalTime formats a time in UTC, so clients need no time zone definition.
+func icalTime(t time.Time) string {
+	return t.UTC().Format("20060102T150405Z")
+}
+
+type icalWriter struct {
+	strings.Builder
+}
+
+// line writes a content line, folded at 75 octets as RFC 5545 requires.
+func (w *icalWriter) line(name, value string) {
+	content := name + ":" + value
+	// Continuation lines start with a space, which counts in their length
+	limit := 75
+	for len(content) > limit {
+		cut := limit
+		// Do not split a UTF-8 sequence
+		for cut > 0 && content[cut]&0xC0 == 0x80 {
+			cut--
+		}
+		w.WriteString(content[:cut] + "\r\n ")
+		content = content[cut:]
+		limit = 74
+	}
+	w.WriteString(content + "\r\n")
+}
+
+// calendarEventSummary describes a row from the point of view of the feed.
+func calendarEventSummary(p models.Planning, definition models.ShiftDefinition, scope string, scopeID uint) string {
+	parts := []string{definition.Label}
+	if definition.Label == "" {
+		parts[0] = p.Shift
+	}
+
+	if scope != CalendarScopeEmployee {
+		switch {
+		case p.Employee != nil:
+			parts = append(parts, p.Employee.Name)
+		case p.CE != nil:
+			parts = append(parts, p.CE.Name)
+		}
+	} else if p.SubstituteID != nil && *p.SubstituteID == scopeID && p.Employee != nil {
+		parts = append(parts, "replacing "+p.Employee.Name)
+	}
+
+	if p.Sector != nil && scope != CalendarScopeSector {
+		parts = append(parts, p.Sector.Name)
+	}
+	return strings.Join(parts, " - ")
+}
+
+// buildCalendar renders planning rows as an iCalendar document. Event UIDs
+// derive from the planning ID, so clients replace events when a row changes.
+func buildCalendar(name string, plannings []models.Planning, shifts map[string]models.ShiftDefinition, scope string, scopeID uint) string {
+	now := time.Now()
+
+	var w icalWriter
+	w.line("BEGIN", "VCALENDAR")
+	w.line("VERSION", "2.0")
+	w.line("PRODID", "-//Hager//planning_hager//FR")
+	w.line("CALSCALE", "GREGORIAN")
+	w.line("METHOD", "PUBLISH")
+	w.line("X-WR-CALNAME", icalEscape(name))
+
+	for _, p := range plannings {
+		definition, ok := shifts[p.Shift]
+		if !ok {
+			continue
+		}
+		start, end := shiftBounds(definition, p.Date)
+
+		stamp := p.UpdatedAt
+		if stamp.IsZero() {
+			stamp = now
+		}
+
+		// In an employee feed, the shift stands for the substitute and no
+		// longer for the employee they replace
+		cancelled := isAbsence(p.Status)
+		if scope == CalendarScopeEmployee {
+			if p.SubstituteID != nil && *p.SubstituteID == scopeID {
+				cancelled = false
+			} else if p.SubstituteID != nil || p.SubstituteReservistID != nil {
+				cancelled = true
+			}
+		}
+
+		w.line("BEGIN", "VEVENT")
+		w.line("UID", fmt.Sprintf("planning-%d@planning_hager", p.ID))
+		w.line("DTSTAMP", icalTime(stamp))
+		w.line("LAST-MODIFIED", icalTime(stamp))
+		w.line("DTSTART", icalTime(start))
+		w.line("DTEND", icalTime(end))
+		w.line("SUMMARY", icalEscape(calendarEventSummary(p, definition, scope, scopeID)))
+		if p.Status != "" {
+			w.line("DESCRIPTION", icalEscape(p.Status))
+		}
+		if cancelled {
+			w.line("STATUS", "CANCELLED")
+		} else {
+			w.line("STATUS", "CONFIRMED")
+		}
+		w.line("END", "VEVENT")
+	}
+
+	w.line("END", "VCALENDAR")
+	return w.String()
+}
+
+// calendarRange reads the optional from and to query dates.
+func calendarRange(c *gin.Context) (time.Time, time.Time, error) {
+	now := time.Now()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	from, to := today.AddDate(0, 0, -calendarDaysBefore), today.AddDate(0, 0, calendarDaysAfter)
+
+	if value := c.Query("from"); value != "" {
+		date, err := time.Parse("2006-01-02", value)
+		if err != nil {
+			return from, to, fmt.Errorf("invalid from parameter")
+		}
+		from = date
+	}
+	if value := c.Query("to"); value != "" {
+		date, err := time.Parse("2006-01-02", value)
+		if err != nil {
+			return from, to, fmt.Errorf("invalid to parameter")
+		}
+		to = date.AddDate(0, 0, 1)
+	}
+	if !from.Before(to) {
+		return from, to, fmt.Errorf("from must be before to")
+	}
+	return from, to, nil
+}
+
+func (h *Handler) respondWithCalendar(c *gin.Context, scope string, id uint, drafts bool) {
+	from, to, err := calendarRange(c)
+	if err != nil {
+		h.respondWithError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	name, err := calendarScopeName(h.DB, scope, id)
+	if err != nil {
+		h.respondWithError(c, http.StatusNotFound, "Calendar not found")
+		return
+	}
+
+	plannings, err := calendarPlannings(h.DB, scope, id, from, to, drafts)
+	if err != nil {
+		h.respondWithError(c, http.StatusInternalServerError, "Failed to fetch planning data")
+		return
+	}
+
+	shifts, err := loadShiftDefinitions(h.DB)
+	if err != nil {
+		h.respondWithError(c, http.StatusInternalServerError, "Failed to fetch shift definitions")
+		return
+	}
+
+	c.Header("Content-Disposition", fmt.Sprintf(`inline; filename="%s-%d.ics"`, scope, id))
+	c.Data(http.StatusOK, "text/calendar; charset=utf-8", []byte(buildCalendar(name, plannings, shifts, scope, id)))
+}
+
+func (h *Handler) exportCalendar(c *gin.Context, scope string) {
+	id, err := strconv.Atoi(strings.TrimSuffix(c.Param("id"), ".ics"))
+	if err != nil {
+		h.respondWithError(c, http.StatusBadRequest, "Invalid ID")
+		return
+	}
+	// Drafts only show up for admins, as in the planning grid
+	h.respondWithCalendar(c, scope, uint(id), c.GetString("role") == "admin")
+}
+
+func (h *Handler) GetEmployeeCalendar(c *gin.Context) {
+	h.exportCalendar(c, CalendarScopeEmployee)
+}
+
+func (h *Handler) GetCECalendar(c *gin.Context) {
+	h.exportCalendar(c, CalendarScopeCE)
+}
+
+func (h *Handler) GetSectorCalendar(c *gin.Context) {
+	h.exportCalendar(c, CalendarScopeSector)
+}
+
+// GetCalendarFeed serves the feed of a calendar token without
+// authentication. It only shows published weeks.
+func (h *Handler) GetCalendarFeed(c *gin.Context) {
+	token := strings.TrimSuffix(c.Param("token"), ".ics")
+
+	var calendarToken models.CalendarToken
+	if err := h.DB.Where("token = ?", token).First(&calendarToken).Error; err != nil {
+		h.respondWithError(c, http.StatusNotFound, "Calendar not found")
+		return
+	}
+
+	if err := h.DB.Model(&calendarToken).Update("last_used_at", time.Now()).Error; err != nil {
+		h.respondWithError(c, http.StatusInternalServerError, "Failed to update calendar token")
+		return
+	}
+
+	h.respondWithCalendar(c, calendarToken.Scope, calendarToken.ScopeID, false)
+}
+
+func newCalendarToken() (string, error) {
+	data := make([]byte, 32)
+	if _, err := rand.Read(data); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(data), nil
+}
+
+// calendarTokenResponse is a calendar token as listed. The token itself is
+// only shown once, in the response to its creation.
+type calendarTokenResponse struct {
+	ID         uint       `json:"id"`
+	Scope      string     `json:"scope"`
+	ScopeID    uint       `json:"scope_id"`
+	CreatedBy  string     `json:"created_by"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+func newCalendarTokenResponse(token models.CalendarToken) calendarTokenResponse {
+	return calendarTokenResponse{
+		ID:         token.ID,
+		Scope:      token.Scope,
+		ScopeID:    token.ScopeID,
+		CreatedBy:  token.CreatedBy,
+		LastUsedAt: token.LastUsedAt,
+		CreatedAt:  token.CreatedAt,
+	}
+}
+
+// AddCalendarToken creates a feed token. Users other than admins may only
+// create one for the feed of their own employee.
+func (h *Handler) AddCalendarToken(c *gin.Context) {
+	var input struct {
+		Scope   string `json:"scope" binding:"required"`
+		ScopeID uint   `json:"scope_id" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&input); err != nil {
+		h.respondWithError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if !isValidCalendarScope(input.Scope) {
+		h.respondWithError(c, http.StatusBadRequest, "Invalid scope")
+		return
+	}
+	if c.GetString("role") != "admin" {
+		if input.Scope != CalendarScopeEmployee {
+			h.respondWithError(c, http.StatusForbidden, "Only admins can create CE and sector calendar tokens")
+			return
+		}
+		employee, err := h.currentEmployee(c)
+		if errors.Is(err, ErrNoEmployeeLinked) {
+			h.respondWithError(c, http.StatusForbidden, "No employee linked to this account")
+			return
+		}
+		if err != nil {
+			h.respondWithError(c, http.StatusInternalServerError, "Failed to fetch the employee of the current user")
+			return
+		}
+		if employee.ID != input.ScopeID {
+			h.respondWithError(c, http.StatusForbidden, "Calendar tokens can only be created for your own employee")
+			return
+		}
+	}
+	if _, err := calendarScopeName(h.DB, input.Scope, input.ScopeID); err != nil {
+		h.respondWithError(c, http.StatusBadRequest, "Calendar scope not found")
+		return
+	}
+
+	token, err := newCalendarToken()
+	if err != nil {
+		h.respondWithError(c, http.StatusInternalServerError, "Failed to create calendar token")
+		return
+	}
+
+	calendarToken := models.CalendarToken{
+		Token:     token,
+		Scope:     input.Scope,
+		ScopeID:   input.ScopeID,
+		CreatedBy: c.GetString("username"),
+	}
+	// The audit log keeps the token without its secret
+	if err := h.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&calendarToken).Error; err != nil {
+			return err
+		}
+		return h.audit(c, tx, AuditCreate, "calendar_token", calendarToken.ID, nil, newCalendarTokenResponse(calendarToken))
+	}); err != nil {
+		h.respondWithError(c, http.StatusInternalServerError, "Failed to create calendar token")
+		return
+	}
+
+	h.respondWithSuccess(c, http.StatusCreated, calendarToken)
+}
+
+// GetCalendarTokens lists the tokens of the current user, or every token for
+// admins, without the tokens themselves.
+func (h *Handler) GetCalendarTokens(c *gin.Context) {
+	query := h.DB.Order("created_at DESC")
+	if c.GetString("role") != "admin" {
+		query = query.Where("created_by = ?", c.GetString("username"))
+	}
+
+	var tokens []models.CalendarToken
+	if err := query.Find(&tokens).Error; err != nil {
+		h.respondWithError(c, http.StatusInternalServerError, "Failed to fetch calendar tokens")
+		return
+	}
+
+	response := make([]calendarTokenResponse, 0, len(tokens))
+	for _, token := range tokens {
+		response = append(response, newCalendarTokenResponse(token))
+	}
+	h.respondWithSuccess(c, http.StatusOK, response)
+}
+
+func (h *Handler) DeleteCalendarToken(c *gin.Context) {
+	id := c.Param("id")
+
+	var calendarToken models.CalendarToken
+	if err := h.DB.First(&calendarToken, id).Error; err != nil {
+		h.respondWithError(c, http.StatusNotFound, "Calendar token not found")
+		return
+	}
+
+	if c.GetString("role") != "admin" && calendarToken.CreatedBy != c.GetString("username") {
+		h.respondWithError(c, http.StatusForbidden, "Calendar token belongs to another user")
+		return
+	}
+
+	if err := h.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Delete(&calendarToken).Error; err != nil {
+			return err
+		}
+		return h.audit(c, tx, AuditDelete, "calendar_token", calendarToken.ID, newCalendarTokenResponse(calendarToken), nil)
+	}); err != nil {
+		h.respondWithError(c, http.StatusInternalServerError, "Failed to delete calendar token")
+		return
+	}
+
+	h.respondWithSuccess(c, http.StatusOK, gin.H{"message": "Calendar token deleted successfully"})
+}
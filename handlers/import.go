@@ -0,0 +1,436 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path/filepath"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/xuri/excelize/v2"
+	"gorm.io/gorm"
+	"planning_hager/models"
+)
+
+// Imported files hold one entity per row, its kind given by the type column.
+// CEs, skills and sectors are created before employees, so rows may refer to
+// entities defined further down the file.
+const (
+	ImportTypeCE       = "ce"
+	ImportTypeSkill    = "skill"
+	ImportTypeSector   = "sector"
+	ImportTypeEmployee = "employee"
+)
+
+const maxImportSize = 5 << 20
+
+var importTypeOrder = []string{ImportTypeCE, ImportTypeSkill, ImportTypeSector, ImportTypeEmployee}
+
+type importRow struct {
+	Row    int      `json:"row"`
+	Type   string   `json:"type"`
+	Name   string   `json:"name"`
+	CE     string   `json:"ce,omitempty"`
+	Sector string   `json:"sector,omitempty"`
+	Skills []string `json:"skills,omitempty"`
+	Errors []string `json:"errors,omitempty"`
+}
+
+func (r *importRow) fail(format string, args ...interface{}) {
+	r.Errors = append(r.Errors, fmt.Sprintf(format, args...))
+}
+
+// importKey identifies an entity by its name, regardless of case and
+// surrounding spaces.
+func importKey(name string) string {
+	return strings.ToLower(strings.TrimSpace(name))
+}
+
+// readImportRecords reads the first sheet of an XLSX file, or a CSV file
+// separated by commas or semicolons.
+func readImportRecords(filename string, data []byte) ([][]string, error) {
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".xlsx":
+		f, err := excelize.OpenReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		return f.GetRows(f.GetSheetName(0))
+
+	case ".csv":
+		r := csv.NewReader(bytes.NewReader(data))
+		firstLine, _, _ := bytes.Cut(data, []byte("\n"))
+		if bytes.Count(firstLine, []byte(";")) > bytes.Count(firstLine, []byte(",")) {
+			r.Comma = ';'
+		}
+		r.FieldsPerRecord = -1
+		return r.ReadAll()
+
+	default:
+		return nil, errors.New("only .csv and .xlsx files can be imported")
+	}
+}
+
+// parseImportRows maps the records to rows using the header line, whose
+// columns are type, name, ce, sector and skills in any order.
+func parseImportRows(records [][]string) ([]importRow, error) {
+	if len(records) == 0 {
+		return nil, errors.New("the file is empty")
+	}
+
+	columns := make(map[string]int)
+	for i, title := range records[0] {
+		columns[importKey(strings.TrimPrefix(title, "\ufeff"))] = i
+	}
+	for _, required := range []string{"type", "name"} {
+		if _, ok := columns[required]; !ok {
+			return nil, fmt.Errorf("missing %s column", required)
+		}
+	}
+
+	value := func(record []string, column string) string {
+		i, ok := columns[column]
+		if !ok || i >= len(record) {
+			return ""
+		}
+		return strings.TrimSpace(record[i])
+	}
+
+	var rows []importRow
+	for i, record := range records[1:] {
+		if strings.TrimSpace(strings.Join(record, "")) == "" {
+			continue
+		}
+
+		row := importRow{
+			Row:    i + 2,
+			Type:   importKey(value(record, "type")),
+			Name:   value(record, "name"),
+			CE:     value(record, "ce"),
+			Sector: value(record, "sector"),
+		}
+		for _, skill := range strings.FieldsFunc(value(record, "skills"), func(r rune) bool { return r == ';' || r == '|' }) {
+			if skill = strings.TrimSpace(skill); skill != "" {
+				row.Skills = append(row.Skills, skill)
+			}
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+// importNames holds, per type, the existing entities by name and the names
+// the file defines.
+type importNames struct {
+	ces, skills, sectors, employees map[string]uint
+	defined                         map[string]map[string]int
+}
+
+func loadImportNames(db *gorm.DB) (importNames, error) {
+	names := importNames{
+		ces:       make(map[string]uint),
+		skills:    make(map[string]uint),
+		sectors:   make(map[string]uint),
+		employees: make(map[string]uint),
+		defined:   make(map[string]map[string]int),
+	}
+	for _, t := range importTypeOrder {
+		names.defined[t] = make(map[string]int)
+	}
+
+	var ces []models.CE
+	if err := db.Find(&ces).Error; err != nil {
+		return names, err
+	}
+	for _, ce := range ces {
+		names.ces[importKey(ce.Name)] = ce.ID
+	}
+
+	var skills []models.Skill
+	if err := db.Find(&skills).Error; err != nil {
+		return names, err
+	}
+	for _, skill := range skills {
+		names.skills[importKey(skill.Name)] = skill.ID
+	}
+
+	var sectors []models.Sector
+	if err := db.Find(&sectors).Error; err != nil {
+		return names, err
+	}
+	for _, sector := range sectors {
+		names.sectors[importKey(sector.Name)] = sector.ID
+	}
+
+	var employees []models.Employee
+	if err := db.Find(&employees).Error; err != nil {
+		return names, err
+	}
+	for _, employee := range employees {
+		names.employees[importKey(employee.Name)] = employee.ID
+	}
+
+	return names, nil
+}
+
+func (n importNames) existing(importType string) map[string]uint {
+	switch importType {
+	case ImportTypeCE:
+		return n.ces
+	case ImportTypeSkill:
+		return n.skills
+	case ImportTypeSector:
+		return n.sectors
+	default:
+		return n.employees
+	}
+}
+
+func (n importNames) known(importType, name string) bool {
+	key := importKey(name)
+	_, exists := n.existing(importType)[key]
+	_, defined := n.defined[importType][key]
+	return exists || defined
+}
+
+// validateImportRows reports on each row the problems that prevent importing
+// it: unknown types, missing or duplicate names, and references to CEs,
+// sectors or skills that neither exist nor are defined in the file.
+func validateImportRows(rows []importRow, names importNames) bool {
+	for i := range rows {
+		row := &rows[i]
+		if !containsString(importTypeOrder, row.Type) {
+			row.fail("unknown type %q", row.Type)
+			continue
+		}
+		if row.Name == "" {
+			row.fail("name is required")
+			continue
+		}
+
+		key := importKey(row.Name)
+		if first, ok := names.defined[row.Type][key]; ok {
+			row.fail("duplicate of row %d", first)
+			continue
+		}
+		names.defined[row.Type][key] = row.Row
+		if _, ok := names.existing(row.Type)[key]; ok {
+			row.fail("%s %q already exists", row.Type, row.Name)
+		}
+	}
+
+	valid := true
+	for i := range rows {
+		row := &rows[i]
+		switch row.Type {
+		case ImportTypeEmployee:
+			if row.CE == "" {
+				row.fail("ce is required")
+			} else if !names.known(ImportTypeCE, row.CE) {
+				row.fail("unknown CE %q", row.CE)
+			}
+			if row.Sector == "" {
+				row.fail("sector is required")
+			} else if !names.known(ImportTypeSector, row.Sector) {
+				row.fail("unknown sector %q", row.Sector)
+			}
+		case ImportTypeCE, ImportTypeSkill:
+			if len(row.Skills) > 0 || row.CE != "" || row.Sector != "" {
+				row.fail("a %s only has a name", row.Type)
+			}
+		case ImportTypeSector:
+			if row.CE != "" || row.Sector != "" {
+				row.fail("a sector only has a name and required skills")
+			}
+		}
+		if row.Type == ImportTypeEmployee || row.Type == ImportTypeSector {
+			for _, skill := range row.Skills {
+				if !names.known(ImportTypeSkill, skill) {
+					row.fail("unknown skill %q", skill)
+				}
+			}
+		}
+
+		if len(row.Errors) > 0 {
+			valid = false
+		}
+	}
+	return valid
+}
+
+func importSkills(tx *gorm.DB, names importNames, row importRow) ([]models.Skill, error) {
+	ids := make([]uint, 0, len(row.Skills))
+	for _, name := range row.Skills {
+		ids = append(ids, names.skills[importKey(name)])
+	}
+
+	var skills []models.Skill
+	err := tx.Where("id IN ?", ids).Find(&skills).Error
+	return skills, err
+}
+
+// applyImportRows creates the entities of validated rows within tx, CEs,
+// skills and sectors first.
+func (h *Handler) applyImportRows(c *gin.Context, tx *gorm.DB, rows []importRow, names importNames) (map[string]int, error) {
+	summary := make(map[string]int, len(importTypeOrder))
+	for _, importType := range importTypeOrder {
+		summary[importType] = 0
+		for _, row := range rows {
+			if row.Type != importType {
+				continue
+			}
+
+			var id uint
+			var created interface{}
+			switch importType {
+			case ImportTypeCE:
+				ce := models.CE{Name: row.Name}
+				if err := tx.Create(&ce).Error; err != nil {
+					return nil, err
+				}
+				id, created = ce.ID, ce
+				names.ces[importKey(row.Name)] = id
+
+			case ImportTypeSkill:
+				skill := models.Skill{Name: row.Name}
+				if err := tx.Create(&skill).Error; err != nil {
+					return nil, err
+				}
+				id, created = skill.ID, skill
+				names.skills[importKey(row.Name)] = id
+
+			case ImportTypeSector:
+				sector := models.Sector{Name: row.Name}
+				if err := tx.Create(&sector).Error; err != nil {
+					return nil, err
+				}
+				if len(row.Skills) > 0 {
+					skills, err := importSkills(tx, names, row)
+					if err != nil {
+						return nil, err
+					}
+					if err := tx.Model(&sector).Association("RequiredSkills").Append(skills); err != nil {
+						return nil, err
+					}
+				}
+				id, created = sector.ID, sector
+				names.sectors[importKey(row.Name)] = id
+
+			case ImportTypeEmployee:
+				employee := models.Employee{
+					Name:     row.Name,
+					CEID:     names.ces[importKey(row.CE)],
+					SectorID: names.sectors[importKey(row.Sector)],
+				}
+				if err := tx.Omit("CE", "Sector").Create(&employee).Error; err != nil {
+					return nil, err
+				}
+				if len(row.Skills) > 0 {
+					skills, err := importSkills(tx, names, row)
+					if err != nil {
+						return nil, err
+					}
+					if err := tx.Model(&employee).Association("Skills").Append(skills); err != nil {
+						return nil, err
+					}
+				}
+				id, created = employee.ID, employee
+				names.employees[importKey(row.Name)] = id
+			}
+
+			if err := h.audit(c, tx, AuditCreate, importType, id, nil, created); err != nil {
+				return nil, err
+			}
+			summary[importType]++
+		}
+	}
+	return summary, nil
+}
+
+// ImportMasterData creates CEs, skills, sectors and employees from an uploaded
+// CSV or XLSX file. Every row is validated first; with dry_run, or when any
+// row is invalid, nothing is created and the per-row report is returned.
+func (h *Handler) ImportMasterData(c *gin.Context) {
+	fileHeader, err := c.FormFile("file")
+	if err != nil {
+		h.respondWithError(c, http.StatusBadRequest, "A file is required")
+		return
+	}
+	if fileHeader.Size > maxImportSize {
+		h.respondWithError(c, http.StatusRequestEntityTooLarge, "The file is too large")
+		return
+	}
+
+	file, err := fileHeader.Open()
+	if err != nil {
+		h.respondWithError(c, http.StatusBadRequest, "Failed to read the file")
+		return
+	}
+	defer file.Close()
+	data, err := io.ReadAll(file)
+	if err != nil {
+		h.respondWithError(c, http.StatusBadRequest, "Failed to read the file")
+		return
+	}
+
+	records, err := readImportRecords(fileHeader.Filename, data)
+	if err != nil {
+		h.respondWithError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	rows, err := parseImportRows(records)
+	if err != nil {
+		h.respondWithError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	dryRun := c.PostForm("dry_run") == "true" || c.Query("dry_run") == "true"
+
+	tx := h.DB.Begin()
+
+	names, err := loadImportNames(tx)
+	if err != nil {
+		tx.Rollback()
+		h.respondWithError(c, http.StatusInternalServerError, "Failed to fetch master data")
+		return
+	}
+
+	valid := validateImportRows(rows, names)
+	if dryRun || !valid {
+		tx.Rollback()
+		status := http.StatusOK
+		if !valid && !dryRun {
+			status = http.StatusUnprocessableEntity
+		}
+		c.JSON(status, gin.H{
+			"dry_run": dryRun,
+			"valid":   valid,
+			"rows":    rows,
+		})
+		return
+	}
+
+	summary, err := h.applyImportRows(c, tx, rows, names)
+	if err != nil {
+		tx.Rollback()
+		h.respondWithError(c, http.StatusInternalServerError, "Failed to import master data")
+		return
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		h.respondWithError(c, http.StatusInternalServerError, "Failed to commit transaction")
+		return
+	}
+
+	h.respondWithSuccess(c, http.StatusCreated, gin.H{
+		"dry_run": false,
+		"valid":   true,
+		"summary": summary,
+		"rows":    rows,
+	})
+}
@@ -0,0 +1,68 @@
+package handlers
+
+import (
+	"testing"
+	"time"
+)
+
+func TestISOWeeksInYear(t *testing.T) {
+	tests := []struct {
+		year  int
+		weeks int
+	}{
+		{2015, 53}, // starts on a Thursday
+		{2020, 53}, // leap year starting on a Wednesday
+		{2021, 52},
+		{2024, 52}, // leap year starting on a Monday
+		{2026, 53},
+		{2027, 52},
+	}
+	for _, tt := range tests {
+		if got := isoWeeksInYear(tt.year); got != tt.weeks {
+			t.Errorf("isoWeeksInYear(%d) = %d, want %d", tt.year, got, tt.weeks)
+		}
+	}
+}
+
+func TestISOWeekRange(t *testing.T) {
+	tests := []struct {
+		year, week int
+		from, to   time.Time
+	}{
+		{2027, 1, utcDate(2027, time.January, 4), utcDate(2027, time.January, 11)},
+		// Week 1 may start in the previous calendar year
+		{2026, 1, utcDate(2025, time.December, 29), utcDate(2026, time.January, 5)},
+		// and the last week end in the next one
+		{2026, 53, utcDate(2026, time.December, 28), utcDate(2027, time.January, 4)},
+		{2020, 53, utcDate(2020, time.December, 28), utcDate(2021, time.January, 4)},
+	}
+	for _, tt := range tests {
+		from, to := isoWeekRange(tt.year, tt.week)
+		if !from.Equal(tt.from) || !to.Equal(tt.to) {
+			t.Errorf("isoWeekRange(%d, %d) = %s, %s, want %s, %s", tt.year, tt.week,
+				from.Format("2006-01-02"), to.Format("2006-01-02"), tt.from.Format("2006-01-02"), tt.to.Format("2006-01-02"))
+		}
+	}
+}
+
+func TestISOWeekRangeCoversTheYear(t *testing.T) {
+	for year := 2015; year <= 2035; year++ {
+		var previousTo time.Time
+		for week := 1; week <= isoWeeksInYear(year); week++ {
+			from, to := isoWeekRange(year, week)
+			if y, w := from.ISOWeek(); y != year || w != week {
+				t.Errorf("isoWeekRange(%d, %d) starts in week %d-W%02d", year, week, y, w)
+			}
+			if from.Weekday() != time.Monday || to.Sub(from) != 7*24*time.Hour {
+				t.Errorf("isoWeekRange(%d, %d) = %s, %s is not a Monday to Monday week", year, week, from, to)
+			}
+			if week > 1 && !from.Equal(previousTo) {
+				t.Errorf("isoWeekRange(%d, %d) does not follow the previous week", year, week)
+			}
+			previousTo = to
+		}
+		if next, _ := isoWeekRange(year+1, 1); !previousTo.Equal(next) {
+			t.Errorf("the last week of %d does not end where %d starts", year, year+1)
+		}
+	}
+}
@@ -0,0 +1,521 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"planning_hager/models"
+)
+
+const (
+	EnforcementBlock = "block"
+	EnforcementWarn  = "warn"
+)
+
+const (
+	RuleMinRest         = "min_rest"
+	RuleConsecutiveDays = "max_consecutive_days"
+	RuleNightShifts     = "max_night_shifts"
+	RuleWeeklyHours     = "weekly_hour_cap"
+)
+
+// labourViolation is a rule broken by an employee or, when ReservistID is
+// set, by a reservist, whose name is then in EmployeeName.
+type labourViolation struct {
+	Rule         string    `json:"rule"`
+	EmployeeID   uint      `json:"employee_id"`
+	ReservistID  uint      `json:"reservist_id,omitempty"`
+	EmployeeName string    `json:"employee_name"`
+	Date         time.Time `json:"date"`
+	Message      string    `json:"message"`
+}
+
+// defaultLabourRules follows the plant rules: 11h rest between two shifts,
+// at most 6 days in a row, 5 nights a week and 48 hours a week.
+func defaultLabourRules() models.LabourRules {
+	return models.LabourRules{
+		MinRestHours:         11,
+		MaxConsecutiveDays:   6,
+		MaxNightShifts:       5,
+		NightShiftPeriodDays: 7,
+		WeeklyHourCap:        48,
+		Enforcement:          EnforcementWarn,
+	}
+}
+
+func (h *Handler) labourRules(db *gorm.DB) (models.LabourRules, error) {
+	var rules models.LabourRules
+	err := db.Order("id ASC").First(&rules).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return defaultLabourRules(), nil
+	}
+	return rules, err
+}
+
+// dayOf returns the calendar day of t as planning dates store it, at midnight
+// UTC.
+func dayOf(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+// checkEmployeeShifts applies the rules to the sorted shifts of one employee
+// and keeps the violations whose date falls between from and to.
+func checkEmployeeShifts(rules models.LabourRules, worked []workedShift, from, to time.Time) []labourViolation {
+	var violations []labourViolation
+	inRange := func(t time.Time) bool {
+		day := dayOf(t)
+		return !day.Before(from) && day.Before(to)
+	}
+
+	// Minimum rest between two shifts
+	if rules.MinRestHours > 0 {
+		minRest := time.Duration(rules.MinRestHours * float64(time.Hour))
+		for i := 1; i < len(worked); i++ {
+			previous, current := worked[i-1], worked[i]
+			rest := current.Start.Sub(previous.End)
+			if rest >= minRest || !(inRange(previous.Start) || inRange(current.Start)) {
+				continue
+			}
+			message := fmt.Sprintf("Only %.0fh rest between %s shift and %s shift, %.0fh required", rest.Hours(), previous.Shift, current.Shift, rules.MinRestHours)
+			if rest < 0 {
+				message = fmt.Sprintf("%s shift overlaps the previous %s shift", current.Shift, previous.Shift)
+			}
+			violations = append(violations, labourViolation{
+				Rule:    RuleMinRest,
+				Date:    dayOf(current.Start),
+				Message: message,
+			})
+		}
+	}
+
+	// Consecutive working days
+	if rules.MaxConsecutiveDays > 0 {
+		var days []time.Time
+		for _, w := range worked {
+			day := dayOf(w.Start)
+			if len(days) == 0 || !days[len(days)-1].Equal(day) {
+				days = append(days, day)
+			}
+		}
+		run := 0
+		for i, day := range days {
+			if i > 0 && days[i-1].AddDate(0, 0, 1).Equal(day) {
+				run++
+			} else {
+				run = 1
+			}
+			if run == rules.MaxConsecutiveDays+1 && inRange(day) {
+				violations = append(violations, labourViolation{
+					Rule:    RuleConsecutiveDays,
+					Date:    day,
+					Message: fmt.Sprintf("More than %d consecutive working days", rules.MaxConsecutiveDays),
+				})
+			}
+		}
+	}
+
+	// Night shifts over a rolling period
+	if rules.MaxNightShifts > 0 && rules.NightShiftPeriodDays > 0 {
+		var nights []time.Time
+		for _, w := range worked {
+			if w.Night {
+				nights = append(nights, dayOf(w.Start))
+			}
+		}
+		for i, night := range nights {
+			periodStart := night.AddDate(0, 0, -rules.NightShiftPeriodDays+1)
+			count := 0
+			for j := i; j >= 0 && !nights[j].Before(periodStart); j-- {
+				count++
+			}
+			if count == rules.MaxNightShifts+1 && inRange(night) {
+				violations = append(violations, labourViolation{
+					Rule:    RuleNightShifts,
+					Date:    night,
+					Message: fmt.Sprintf("More than %d night shifts over %d days", rules.MaxNightShifts, rules.NightShiftPeriodDays),
+				})
+			}
+		}
+	}
+
+	// Weekly hour cap, per ISO week
+	if rules.WeeklyHourCap > 0 {
+		hours := make(map[time.Time]float64)
+		for _, w := range worked {
+			year, week := w.Start.ISOWeek()
+			hours[isoWeekStart(year, week)] += w.hours()
+		}
+		for weekStart, total := range hours {
+			if total > rules.WeeklyHourCap && weekStart.Before(to) && weekStart.AddDate(0, 0, 7).After(from) {
+				year, week := weekStart.ISOWeek()
+				violations = append(violations, labourViolation{
+					Rule:    RuleWeeklyHours,
+					Date:    weekStart,
+					Message: fmt.Sprintf("%.0fh worked in week %d of %d, cap is %.0fh", total, week, year, rules.WeeklyHourCap),
+				})
+			}
+		}
+	}
+
+	return violations
+}
+
+// labourMargin is how many days around a range the rolling rules look at.
+func labourMargin(rules models.LabourRules) int {
+	margin := 7
+	if rules.MaxConsecutiveDays+1 > margin {
+		margin = rules.MaxConsecutiveDays + 1
+	}
+	if rules.NightShiftPeriodDays > margin {
+		margin = rules.NightShiftPeriodDays
+	}
+	return margin
+}
+
+// evaluateLabourRules checks the shifts worked by the given employees, and by
+// the reservists substituting between from and to, looking far enough around
+// that range for the rolling rules.
+func (h *Handler) evaluateLabourRules(db *gorm.DB, rules models.LabourRules, employeeIDs []uint, from, to time.Time) ([]labourViolation, error) {
+	margin := labourMargin(rules)
+	from, to = dayOf(from), dayOf(to)
+
+	violations, err := reservistViolations(db, rules, from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(employeeIDs) > 0 {
+		worked, err := employeeWorkedShifts(db, employeeIDs, from.AddDate(0, 0, -margin), to.AddDate(0, 0, margin))
+		if err != nil {
+			return nil, err
+		}
+
+		var employees []models.Employee
+		if err := db.Where("id IN ?", employeeIDs).Find(&employees).Error; err != nil {
+			return nil, err
+		}
+		names := make(map[uint]string, len(employees))
+		for _, emp := range employees {
+			names[emp.ID] = emp.Name
+		}
+
+		for _, id := range employeeIDs {
+			for _, v := range checkEmployeeShifts(rules, worked[id], from, to) {
+				v.EmployeeID = id
+				v.EmployeeName = names[id]
+				violations = append(violations, v)
+			}
+		}
+	}
+
+	sort.SliceStable(violations, func(i, j int) bool {
+		return violations[i].Date.Before(violations[j].Date)
+	})
+
+	return violations, nil
+}
+
+// reservistViolations checks the shifts worked by the reservists substituting
+// between from and to. Reservists have no planning of their own, so every
+// change around their substitutions is checked for them.
+func reservistViolations(db *gorm.DB, rules models.LabourRules, from, to time.Time) ([]labourViolation, error) {
+	violations := []labourViolation{}
+
+	var reservistIDs []uint
+	if err := db.Model(&models.Planning{}).
+		Where("date >= ? AND date < ? AND substitute_reservist_id IS NOT NULL", from, to).
+		Distinct().Pluck("substitute_reservist_id", &reservistIDs).Error; err != nil {
+		return nil, err
+	}
+	if len(reservistIDs) == 0 {
+		return violations, nil
+	}
+
+	margin := labourMargin(rules)
+	worked, err := reservistWorkedShifts(db, reservistIDs, from.AddDate(0, 0, -margin), to.AddDate(0, 0, margin))
+	if err != nil {
+		return nil, err
+	}
+
+	var reservists []models.Reservist
+	if err := db.Where("id IN ?", reservistIDs).Find(&reservists).Error; err != nil {
+		return nil, err
+	}
+	names := make(map[uint]string, len(reservists))
+	for _, reservist := range reservists {
+		names[reservist.ID] = reservist.Name
+	}
+
+	for _, id := range reservistIDs {
+		for _, v := range checkEmployeeShifts(rules, worked[id], from, to) {
+			v.ReservistID = id
+			v.EmployeeName = names[id]
+			violations = append(violations, v)
+		}
+	}
+	return violations, nil
+}
+
+// labourBaseline holds the violations present before a planning mutation, so
+// that only the ones the mutation introduces can refuse it.
+type labourBaseline map[string]bool
+
+func violationKey(v labourViolation) string {
+	return fmt.Sprintf("%s|%d|%d|%s", v.Rule, v.EmployeeID, v.ReservistID, v.Date.Format(time.RFC3339))
+}
+
+// existingViolations evaluates the rules before a planning mutation, within
+// the mutation's transaction, for everyone working around from and to.
+func (h *Handler) existingViolations(tx *gorm.DB, from, to time.Time) (labourBaseline, error) {
+	rules, err := h.labourRules(tx)
+	if err != nil {
+		return nil, err
+	}
+
+	margin := labourMargin(rules)
+	var rows []models.Planning
+	if err := tx.Select("employee_id, substitute_id").
+		Where("date >= ? AND date < ?", dayOf(from).AddDate(0, 0, -margin), dayOf(to).AddDate(0, 0, margin)).
+		Where("employee_id IS NOT NULL OR substitute_id IS NOT NULL").
+		Find(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	violations, err := h.evaluateLabourRules(tx, rules, planningEmployeeIDs(rows), from, to)
+	if err != nil {
+		return nil, err
+	}
+	baseline := make(labourBaseline, len(violations))
+	for _, v := range violations {
+		baseline[violationKey(v)] = true
+	}
+	return baseline, nil
+}
+
+// enforceLabourRules evaluates the rules after a planning mutation, within the
+// mutation's transaction. Besides every violation, it returns those refusing
+// the mutation: the ones missing from the baseline when the rules block.
+func (h *Handler) enforceLabourRules(tx *gorm.DB, baseline labourBaseline, employeeIDs []uint, from, to time.Time) ([]labourViolation, []labourViolation, error) {
+	rules, err := h.labourRules(tx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	violations, err := h.evaluateLabourRules(tx, rules, employeeIDs, from, to)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var blocking []labourViolation
+	if rules.Enforcement == EnforcementBlock {
+		for _, v := range violations {
+			if !baseline[violationKey(v)] {
+				blocking = append(blocking, v)
+			}
+		}
+	}
+	return violations, blocking, nil
+}
+
+func (h *Handler) respondWithViolations(c *gin.Context, violations []labourViolation) {
+	c.JSON(http.StatusConflict, gin.H{
+		"error":      "Labour rules violated",
+		"violations": violations,
+	})
+}
+
+// planningEmployeeIDs returns the employees working on the given rows, either
+// as planned employee or as substitute.
+func planningEmployeeIDs(plannings []models.Planning) []uint {
+	seen := make(map[uint]bool)
+	var ids []uint
+	add := func(id *uint) {
+		if id != nil && !seen[*id] {
+			seen[*id] = true
+			ids = append(ids, *id)
+		}
+	}
+	for _, p := range plannings {
+		add(p.EmployeeID)
+		add(p.SubstituteID)
+	}
+	return ids
+}
+
+func (h *Handler) GetLabourRules(c *gin.Context) {
+	rules, err := h.labourRules(h.DB)
+	if err != nil {
+		h.respondWithError(c, http.StatusInternalServerError, "Failed to fetch labour rules")
+		return
+	}
+	h.respondWithSuccess(c, http.StatusOK, rules)
+}
+
+func (h *Handler) UpdateLabourRules(c *gin.Context) {
+	var input struct {
+		MinRestHours         float64 `json:"min_rest_hours"`
+		MaxConsecutiveDays   int     `json:"max_consecutive_days"`
+		MaxNightShifts       int     `json:"max_night_shifts"`
+		NightShiftPeriodDays int     `json:"night_shift_period_days"`
+		WeeklyHourCap        float64 `json:"weekly_hour_cap"`
+		Enforcement          string  `json:"enforcement" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&input); err != nil {
+		h.respondWithError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if input.Enforcement != EnforcementBlock && input.Enforcement != EnforcementWarn {
+		h.respondWithError(c, http.StatusBadRequest, "Enforcement must be block or warn")
+		return
+	}
+	if input.MinRestHours < 0 || input.MaxConsecutiveDays < 0 || input.MaxNightShifts < 0 ||
+		input.NightShiftPeriodDays < 0 || input.WeeklyHourCap < 0 {
+		h.respondWithError(c, http.StatusBadRequest, "Rule values must not be negative")
+		return
+	}
+
+	rules, err := h.labourRules(h.DB)
+	if err != nil {
+		h.respondWithError(c, http.StatusInternalServerError, "Failed to fetch labour rules")
+		return
+	}
+
+	before := rules
+	rules.MinRestHours = input.MinRestHours
+	rules.MaxConsecutiveDays = input.MaxConsecutiveDays
+	rules.MaxNightShifts = input.MaxNightShifts
+	rules.NightShiftPeriodDays = input.NightShiftPeriodDays
+	rules.WeeklyHourCap = input.WeeklyHourCap
+	rules.Enforcement = input.Enforcement
+
+	if err := h.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(&rules).Error; err != nil {
+			return err
+		}
+		if before.ID == 0 {
+			return h.audit(c, tx, AuditCreate, "labour_rules", rules.ID, nil, rules)
+		}
+		return h.audit(c, tx, AuditUpdate, "labour_rules", rules.ID, before, rules)
+	}); err != nil {
+		h.respondWithError(c, http.StatusInternalServerError, "Failed to update labour rules")
+		return
+	}
+
+	h.respondWithSuccess(c, http.StatusOK, rules)
+}
+
+// GetPlanningViolations reports the labour rule violations of a week. Draft
+// weeks report none to users other than admins.
+func (h *Handler) GetPlanningViolations(c *gin.Context) {
+	year, week, err := parseISOWeek(c.Query("year"), c.Query("week"))
+	if err != nil {
+		h.respondWithError(c, http.StatusBadRequest, "Invalid year or week parameter")
+		return
+	}
+
+	hidden, err := h.weekHidden(c, year, week)
+	if err != nil {
+		h.respondWithError(c, http.StatusInternalServerError, "Failed to fetch week publication")
+		return
+	}
+	if hidden {
+		h.respondWithSuccess(c, http.StatusOK, []labourViolation{})
+		return
+	}
+
+	from, to := isoWeekRange(year, week)
+
+	var plannings []models.Planning
+	if err := h.DB.Where("date >= ? AND date < ?", from, to).Find(&plannings).Error; err != nil {
+		h.respondWithError(c, http.StatusInternalServerError, "Failed to fetch planning data")
+		return
+	}
+
+	rules, err := h.labourRules(h.DB)
+	if err != nil {
+		h.respondWithError(c, http.StatusInternalServerError, "Failed to fetch labour rules")
+		return
+	}
+
+	violations, err := h.evaluateLabourRules(h.DB, rules, planningEmployeeIDs(plannings), from, to)
+	if err != nil {
+		h.respondWithError(c, http.StatusInternalServerError, "Failed to evaluate labour rules")
+		return
+	}
+
+	h.respondWithSuccess(c, http.StatusOK, violations)
+}
+
+// blockingBaseline returns the violations present before a planning mutation
+// for enforceLabourRules, none when the rules only warn as nothing is refused
+// then.
+func (h *Handler) blockingBaseline(tx *gorm.DB, from, to time.Time) (labourBaseline, error) {
+	rules, err := h.labourRules(tx)
+	if err != nil {
+		return nil, err
+	}
+	if rules.Enforcement != EnforcementBlock {
+		return nil, nil
+	}
+	return h.existingViolations(tx, from, to)
+}
+
+// LabourRulesError refuses a planning change breaking blocking labour rules.
+type LabourRulesError struct {
+	Violations []labourViolation
+}
+
+func (e *LabourRulesError) Error() string {
+	messages := make([]string, 0, len(e.Violations))
+	for _, v := range e.Violations {
+		messages = append(messages, fmt.Sprintf("%s on %s: %s", v.EmployeeName, v.Date.Format("2006-01-02"), v.Message))
+	}
+	return "labour rules violated: " + strings.Join(messages, "; ")
+}
+
+// startLabourCheck records the violations present before a planning mutation
+// for checkLabourRules. When the rules cannot be evaluated, it rolls tx back,
+// writes the response and returns false.
+func (h *Handler) startLabourCheck(c *gin.Context, tx *gorm.DB, from, to time.Time) (labourBaseline, bool) {
+	baseline, err := h.blockingBaseline(tx, from, to)
+	if err != nil {
+		tx.Rollback()
+		h.respondWithError(c, http.StatusInternalServerError, "Failed to evaluate labour rules")
+		return nil, false
+	}
+	return baseline, true
+}
+
+// checkLabourRules evaluates the rules within the mutation's transaction. When
+// the rules cannot be evaluated or the mutation breaks a blocking rule that
+// was not broken before, it rolls tx back, writes the response and returns
+// false.
+func (h *Handler) checkLabourRules(c *gin.Context, tx *gorm.DB, baseline labourBaseline, employeeIDs []uint, from, to time.Time) ([]labourViolation, bool) {
+	violations, blocking, err := h.enforceLabourRules(tx, baseline, employeeIDs, from, to)
+	if err != nil {
+		tx.Rollback()
+		h.respondWithError(c, http.StatusInternalServerError, "Failed to evaluate labour rules")
+		return nil, false
+	}
+	if len(blocking) > 0 {
+		tx.Rollback()
+		h.respondWithViolations(c, blocking)
+		return nil, false
+	}
+	return violations, true
+}
+
+// planningResponse is a planning entry along with the labour rules it breaks
+// when the rules only warn.
+type planningResponse struct {
+	models.Planning
+	Violations []labourViolation `json:"violations"`
+}
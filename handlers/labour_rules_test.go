@@ -0,0 +1,62 @@
+package handlers
+
+import (
+	"errors"
+	"testing"
+
+	"planning_hager/models"
+)
+
+func TestPopulateYearRefusesLabourViolations(t *testing.T) {
+	db := newTestDB(t)
+	seedTeams(t, db)
+	rules := defaultLabourRules()
+	rules.MaxConsecutiveDays = 1
+	rules.Enforcement = EnforcementBlock
+	if err := db.Create(&rules).Error; err != nil {
+		t.Fatalf("saving labour rules: %v", err)
+	}
+
+	_, err := PopulateYear(db, 2030, false, "tester", "")
+	var labourErr *LabourRulesError
+	if !errors.As(err, &labourErr) || len(labourErr.Violations) == 0 {
+		t.Fatalf("PopulateYear = %v, want the labour rule violations refused", err)
+	}
+	if n := countRows(t, db, &models.Planning{}); n != 0 {
+		t.Errorf("refused PopulateYear saved %d rows", n)
+	}
+}
+
+func TestLabourRulesCheckReservists(t *testing.T) {
+	db := newTestDB(t)
+	h := NewHandler(db)
+	employees := seedTeams(t, db)
+	rules := defaultLabourRules()
+
+	reservist := models.Reservist{Name: "Alain"}
+	if err := db.Create(&reservist).Error; err != nil {
+		t.Fatalf("creating reservist: %v", err)
+	}
+	// A night followed by a morning leaves no rest at all
+	for _, p := range []models.Planning{
+		{Date: utcDate(2030, 3, 4), Shift: "N"},
+		{Date: utcDate(2030, 3, 5), Shift: "M"},
+	} {
+		p.Year, p.Week = testYear, testWeek
+		p.EmployeeID, p.Status = &employees[0].ID, StatusAbsentU
+		p.SubstituteReservistID = &reservist.ID
+		if err := db.Create(&p).Error; err != nil {
+			t.Fatalf("creating planning: %v", err)
+		}
+	}
+
+	from, to := isoWeekRange(testYear, testWeek)
+	violations, err := h.evaluateLabourRules(db, rules, nil, from, to)
+	if err != nil {
+		t.Fatalf("evaluateLabourRules: %v", err)
+	}
+	if len(violations) != 1 || violations[0].Rule != RuleMinRest || violations[0].ReservistID != reservist.ID ||
+		violations[0].EmployeeID != 0 || violations[0].EmployeeName != "Alain" {
+		t.Errorf("violations = %+v, want the rest of the reservist broken", violations)
+	}
+}
@@ -0,0 +1,538 @@
+package handlers
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"planning_hager/models"
+)
+
+const (
+	LeavePaid     = "paid_leave"
+	LeaveRTT      = "rtt"
+	LeaveRecovery = "recovery_hours"
+)
+
+const (
+	LeaveUnitDays  = "days"
+	LeaveUnitHours = "hours"
+)
+
+const (
+	MovementAccrual    = "accrual"
+	MovementCarryOver  = "carry_over"
+	MovementDebit      = "debit"
+	MovementRefund     = "refund"
+	MovementAdjustment = "adjustment"
+)
+
+type leaveBalance struct {
+	Type     string  `json:"type"`
+	Label    string  `json:"label"`
+	Unit     string  `json:"unit"`
+	Year     int     `json:"year"`
+	Accrued  float64 `json:"accrued"`
+	Used     float64 `json:"used"`
+	Adjusted float64 `json:"adjusted"`
+	Balance  float64 `json:"balance"`
+}
+
+func loadLeaveAccrualRules(db *gorm.DB) ([]models.LeaveAccrualRule, error) {
+	var rules []models.LeaveAccrualRule
+	err := db.Order("id ASC").Find(&rules).Error
+	return rules, err
+}
+
+func leaveRulesByType(rules []models.LeaveAccrualRule) map[string]models.LeaveAccrualRule {
+	byType := make(map[string]models.LeaveAccrualRule, len(rules))
+	for _, rule := range rules {
+		byType[rule.Type] = rule
+	}
+	return byType
+}
+
+// leaveCost is what a planned absence on the given shift takes from an account:
+// a day, or the hours of the shift for accounts kept in hours.
+func leaveCost(rule models.LeaveAccrualRule, shift models.ShiftDefinition, date time.Time) float64 {
+	if rule.Unit != LeaveUnitHours {
+		return 1
+	}
+	if shift.Code == "" {
+		return 0
+	}
+	start, end := shiftBounds(shift, date)
+	return end.Sub(start).Hours()
+}
+
+func sameAmount(a, b float64) bool {
+	return math.Abs(a-b) < 1e-6
+}
+
+// syncLeaveDebits makes the leave accounts match the planned absences on the
+// given rows. A planned absence is debited once; the debit is refunded when
+// the row stops being a planned absence or its leave type changes. Rows must
+// be passed as they are after the change.
+func syncLeaveDebits(tx *gorm.DB, plannings []models.Planning, username string) error {
+	if len(plannings) == 0 {
+		return nil
+	}
+
+	rules, err := loadLeaveAccrualRules(tx)
+	if err != nil {
+		return err
+	}
+	rulesByType := leaveRulesByType(rules)
+
+	shifts, err := loadShiftDefinitions(tx)
+	if err != nil {
+		return err
+	}
+
+	ids := make([]uint, 0, len(plannings))
+	for _, p := range plannings {
+		ids = append(ids, p.ID)
+	}
+
+	var movements []models.LeaveMovement
+	if err := tx.Where("planning_id IN ?", ids).Find(&movements).Error; err != nil {
+		return err
+	}
+
+	// What is still debited per planning entry and leave type
+	type debit struct {
+		employeeID uint
+		year       int
+		amount     float64
+	}
+	outstanding := make(map[uint]map[string]debit)
+	for _, m := range movements {
+		if outstanding[*m.PlanningID] == nil {
+			outstanding[*m.PlanningID] = make(map[string]debit)
+		}
+		d := outstanding[*m.PlanningID][m.Type]
+		d.employeeID, d.year = m.EmployeeID, m.Year
+		d.amount += m.Amount
+		outstanding[*m.PlanningID][m.Type] = d
+	}
+
+	var created []models.LeaveMovement
+	for _, p := range plannings {
+		planningID := p.ID
+
+		wantType, wantAmount := "", 0.0
+		if p.Status == StatusAbsentP && p.EmployeeID != nil {
+			wantType = p.LeaveType
+			if wantType == "" {
+				wantType = LeavePaid
+			}
+			if rule, ok := rulesByType[wantType]; ok {
+				wantAmount = -leaveCost(rule, shifts[p.Shift], p.Date)
+			} else {
+				wantType = ""
+			}
+		}
+
+		for leaveType, d := range outstanding[p.ID] {
+			if sameAmount(d.amount, 0) {
+				continue
+			}
+			if leaveType == wantType && sameAmount(d.amount, wantAmount) && p.EmployeeID != nil && *p.EmployeeID == d.employeeID {
+				wantType = ""
+				continue
+			}
+			created = append(created, models.LeaveMovement{
+				EmployeeID: d.employeeID,
+				Type:       leaveType,
+				Year:       d.year,
+				Kind:       MovementRefund,
+				Amount:     -d.amount,
+				PlanningID: &planningID,
+				Note:       "Planned absence withdrawn",
+				CreatedBy:  username,
+			})
+		}
+
+		if wantType != "" && !sameAmount(wantAmount, 0) {
+			created = append(created, models.LeaveMovement{
+				EmployeeID: *p.EmployeeID,
+				Type:       wantType,
+				Year:       p.Date.Year(),
+				Kind:       MovementDebit,
+				Amount:     wantAmount,
+				PlanningID: &planningID,
+				Note:       "Planned absence on " + p.Date.Format("2006-01-02") + " (" + p.Shift + ")",
+				CreatedBy:  username,
+			})
+		}
+	}
+
+	if len(created) == 0 {
+		return nil
+	}
+	return tx.CreateInBatches(&created, 100).Error
+}
+
+// leaveBalances returns the accounts of an employee for a year, one per leave
+// type.
+func leaveBalances(db *gorm.DB, employeeID uint, year int) ([]leaveBalance, error) {
+	rules, err := loadLeaveAccrualRules(db)
+	if err != nil {
+		return nil, err
+	}
+
+	var movements []models.LeaveMovement
+	if err := db.Where("employee_id = ? AND year = ?", employeeID, year).Find(&movements).Error; err != nil {
+		return nil, err
+	}
+
+	balances := make([]leaveBalance, 0, len(rules))
+	index := make(map[string]int, len(rules))
+	for _, rule := range rules {
+		index[rule.Type] = len(balances)
+		balances = append(balances, leaveBalance{Type: rule.Type, Label: rule.Label, Unit: rule.Unit, Year: year})
+	}
+
+	for _, m := range movements {
+		i, ok := index[m.Type]
+		if !ok {
+			continue
+		}
+		switch m.Kind {
+		case MovementAccrual, MovementCarryOver:
+			balances[i].Accrued += m.Amount
+		case MovementDebit, MovementRefund:
+			balances[i].Used -= m.Amount
+		default:
+			balances[i].Adjusted += m.Amount
+		}
+		balances[i].Balance += m.Amount
+	}
+
+	return balances, nil
+}
+
+// accrueLeave credits every employee with the yearly amount of each leave type
+// and carries over what was left the year before. Employees already credited
+// for a type and year are skipped, so it can be run again safely.
+func accrueLeave(tx *gorm.DB, year int, username string) ([]models.LeaveMovement, error) {
+	created := []models.LeaveMovement{}
+
+	rules, err := loadLeaveAccrualRules(tx)
+	if err != nil {
+		return nil, err
+	}
+
+	var employees []models.Employee
+	if err := tx.Find(&employees).Error; err != nil {
+		return nil, err
+	}
+
+	var existing []models.LeaveMovement
+	if err := tx.Where("year = ? AND kind IN ?", year, []string{MovementAccrual, MovementCarryOver}).Find(&existing).Error; err != nil {
+		return nil, err
+	}
+	credited := make(map[uint]map[string]bool)
+	for _, m := range existing {
+		if credited[m.EmployeeID] == nil {
+			credited[m.EmployeeID] = make(map[string]bool)
+		}
+		credited[m.EmployeeID][m.Type] = true
+	}
+
+	type account struct {
+		employeeID uint
+		leaveType  string
+	}
+	var previous []struct {
+		EmployeeID uint
+		Type       string
+		Total      float64
+	}
+	if err := tx.Model(&models.LeaveMovement{}).
+		Select("employee_id, type, SUM(amount) AS total").
+		Where("year = ?", year-1).
+		Group("employee_id, type").
+		Scan(&previous).Error; err != nil {
+		return nil, err
+	}
+	remaining := make(map[account]float64, len(previous))
+	for _, p := range previous {
+		remaining[account{p.EmployeeID, p.Type}] = p.Total
+	}
+
+	for _, emp := range employees {
+		for _, rule := range rules {
+			if credited[emp.ID][rule.Type] {
+				continue
+			}
+
+			// An overdrawn account carries its debt over in full
+			carry := remaining[account{emp.ID, rule.Type}]
+			if carry > 0 && rule.CarryOverMax >= 0 {
+				carry = math.Min(carry, rule.CarryOverMax)
+			}
+			if !sameAmount(carry, 0) {
+				created = append(created, models.LeaveMovement{
+					EmployeeID: emp.ID,
+					Type:       rule.Type,
+					Year:       year,
+					Kind:       MovementCarryOver,
+					Amount:     carry,
+					Note:       "Carried over from " + strconv.Itoa(year-1),
+					CreatedBy:  username,
+				})
+			}
+
+			if rule.YearlyAmount > 0 {
+				created = append(created, models.LeaveMovement{
+					EmployeeID: emp.ID,
+					Type:       rule.Type,
+					Year:       year,
+					Kind:       MovementAccrual,
+					Amount:     rule.YearlyAmount,
+					Note:       "Yearly accrual " + strconv.Itoa(year),
+					CreatedBy:  username,
+				})
+			}
+		}
+	}
+
+	if len(created) > 0 {
+		if err := tx.CreateInBatches(&created, 100).Error; err != nil {
+			return nil, err
+		}
+	}
+
+	return created, nil
+}
+
+func parseLeaveYear(c *gin.Context) (int, error) {
+	if yearStr := c.Query("year"); yearStr != "" {
+		return strconv.Atoi(yearStr)
+	}
+	return time.Now().Year(), nil
+}
+
+func (h *Handler) GetCurrentEmployeeLeaveBalance(c *gin.Context) {
+	year, err := parseLeaveYear(c)
+	if err != nil {
+		h.respondWithError(c, http.StatusBadRequest, "Invalid year parameter")
+		return
+	}
+
+	employee, err := h.currentEmployee(c)
+	if err != nil {
+		h.respondWithError(c, http.StatusNotFound, "No employee found for the current user")
+		return
+	}
+
+	balances, err := leaveBalances(h.DB, employee.ID, year)
+	if err != nil {
+		h.respondWithError(c, http.StatusInternalServerError, "Failed to compute leave balance")
+		return
+	}
+
+	h.respondWithSuccess(c, http.StatusOK, balances)
+}
+
+func (h *Handler) GetLeaveBalance(c *gin.Context) {
+	id := c.Param("id")
+	year, err := parseLeaveYear(c)
+	if err != nil {
+		h.respondWithError(c, http.StatusBadRequest, "Invalid year parameter")
+		return
+	}
+
+	var employee models.Employee
+	if err := h.DB.First(&employee, id).Error; err != nil {
+		h.respondWithError(c, http.StatusNotFound, "Employee not found")
+		return
+	}
+
+	balances, err := leaveBalances(h.DB, employee.ID, year)
+	if err != nil {
+		h.respondWithError(c, http.StatusInternalServerError, "Failed to compute leave balance")
+		return
+	}
+
+	h.respondWithSuccess(c, http.StatusOK, balances)
+}
+
+func (h *Handler) GetLeaveMovements(c *gin.Context) {
+	id := c.Param("id")
+	year, err := parseLeaveYear(c)
+	if err != nil {
+		h.respondWithError(c, http.StatusBadRequest, "Invalid year parameter")
+		return
+	}
+
+	var movements []models.LeaveMovement
+	if err := h.DB.Where("employee_id = ? AND year = ?", id, year).Order("created_at ASC, id ASC").Find(&movements).Error; err != nil {
+		h.respondWithError(c, http.StatusInternalServerError, "Failed to fetch leave movements")
+		return
+	}
+
+	h.respondWithSuccess(c, http.StatusOK, movements)
+}
+
+func (h *Handler) AddLeaveAdjustment(c *gin.Context) {
+	var input struct {
+		EmployeeID uint    `json:"employee_id" binding:"required"`
+		Type       string  `json:"type" binding:"required"`
+		Year       int     `json:"year" binding:"required"`
+		Amount     float64 `json:"amount" binding:"required"`
+		Note       string  `json:"note" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&input); err != nil {
+		h.respondWithError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	var rule models.LeaveAccrualRule
+	if err := h.DB.Where("type = ?", input.Type).First(&rule).Error; err != nil {
+		h.respondWithError(c, http.StatusBadRequest, "Invalid leave type")
+		return
+	}
+
+	var employee models.Employee
+	if err := h.DB.First(&employee, input.EmployeeID).Error; err != nil {
+		h.respondWithError(c, http.StatusNotFound, "Employee not found")
+		return
+	}
+
+	movement := models.LeaveMovement{
+		EmployeeID: employee.ID,
+		Type:       rule.Type,
+		Year:       input.Year,
+		Kind:       MovementAdjustment,
+		Amount:     input.Amount,
+		Note:       input.Note,
+		CreatedBy:  c.GetString("username"),
+	}
+
+	if err := h.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&movement).Error; err != nil {
+			return err
+		}
+		return h.audit(c, tx, AuditCreate, "leave_movement", movement.ID, nil, movement)
+	}); err != nil {
+		h.respondWithError(c, http.StatusInternalServerError, "Failed to create leave adjustment")
+		return
+	}
+
+	h.respondWithSuccess(c, http.StatusCreated, movement)
+}
+
+func (h *Handler) GetLeaveAccrualRules(c *gin.Context) {
+	rules, err := loadLeaveAccrualRules(h.DB)
+	if err != nil {
+		h.respondWithError(c, http.StatusInternalServerError, "Failed to fetch leave accrual rules")
+		return
+	}
+	h.respondWithSuccess(c, http.StatusOK, rules)
+}
+
+func (h *Handler) UpdateLeaveAccrualRule(c *gin.Context) {
+	id := c.Param("id")
+	var input struct {
+		Label        string  `json:"label"`
+		Unit         string  `json:"unit" binding:"required"`
+		YearlyAmount float64 `json:"yearly_amount"`
+		CarryOverMax float64 `json:"carry_over_max"`
+	}
+
+	if err := c.ShouldBindJSON(&input); err != nil {
+		h.respondWithError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if input.Unit != LeaveUnitDays && input.Unit != LeaveUnitHours {
+		h.respondWithError(c, http.StatusBadRequest, "Unit must be days or hours")
+		return
+	}
+	if input.YearlyAmount < 0 {
+		h.respondWithError(c, http.StatusBadRequest, "Yearly amount must not be negative")
+		return
+	}
+
+	var rule models.LeaveAccrualRule
+	if err := h.DB.First(&rule, id).Error; err != nil {
+		h.respondWithError(c, http.StatusNotFound, "Leave accrual rule not found")
+		return
+	}
+
+	before := rule
+	if input.Label != "" {
+		rule.Label = input.Label
+	}
+	rule.Unit = input.Unit
+	rule.YearlyAmount = input.YearlyAmount
+	rule.CarryOverMax = input.CarryOverMax
+
+	if err := h.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(&rule).Error; err != nil {
+			return err
+		}
+		return h.audit(c, tx, AuditUpdate, "leave_accrual_rule", rule.ID, before, rule)
+	}); err != nil {
+		h.respondWithError(c, http.StatusInternalServerError, "Failed to update leave accrual rule")
+		return
+	}
+
+	h.respondWithSuccess(c, http.StatusOK, rule)
+}
+
+func (h *Handler) AccrueLeave(c *gin.Context) {
+	var input struct {
+		Year int `json:"year" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&input); err != nil {
+		h.respondWithError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	var created []models.LeaveMovement
+	err := h.DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		if created, err = accrueLeave(tx, input.Year, c.GetString("username")); err != nil {
+			return err
+		}
+		for _, movement := range created {
+			if err := h.audit(c, tx, AuditCreate, "leave_movement", movement.ID, nil, movement); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		h.respondWithError(c, http.StatusInternalServerError, "Failed to accrue leave")
+		return
+	}
+
+	h.respondWithSuccess(c, http.StatusCreated, gin.H{
+		"message":   "Leave accrued successfully",
+		"movements": created,
+	})
+}
+
+// refundLeaveDebits gives back the leave debited for rows about to be deleted.
+func refundLeaveDebits(tx *gorm.DB, plannings []models.Planning, username string) error {
+	withdrawn := make([]models.Planning, len(plannings))
+	for i, p := range plannings {
+		p.Status = ""
+		withdrawn[i] = p
+	}
+	return syncLeaveDebits(tx, withdrawn, username)
+}
+
+func isValidLeaveType(db *gorm.DB, leaveType string) (bool, error) {
+	var count int64
+	err := db.Model(&models.LeaveAccrualRule{}).Where("type = ?", leaveType).Count(&count).Error
+	return count > 0, err
+}
@@ -0,0 +1,261 @@
+package handlers
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-pdf/fpdf"
+	"planning_hager/models"
+)
+
+// CE bands are coloured in turn from this palette.
+var pdfBandColors = [][3]int{
+	{255, 229, 143},
+	{145, 213, 255},
+	{173, 198, 255},
+	{183, 235, 143},
+	{255, 187, 150},
+	{211, 173, 247},
+}
+
+// pdfSection gathers the rows of one CE, one line per sector with the shifts
+// of each day.
+type pdfSection struct {
+	CE      string
+	Sectors []string
+	Cells   map[string][][]string
+}
+
+// pdfCellLine annotates a row with its status and substitute.
+func pdfCellLine(p models.Planning) string {
+	line := p.Shift + " " + p.Employee.Name
+	if p.Status != "" && p.Status != StatusScheduled {
+		line += " (" + p.Status + ")"
+	}
+	switch {
+	case p.Substitute != nil:
+		line += " > " + p.Substitute.Name
+	case p.SubstituteReservist != nil:
+		line += " > " + p.SubstituteReservist.Name + " (reservist)"
+	}
+	return line
+}
+
+// pdfSections groups the rows by CE, then sector and day. Rows without an
+// employee, such as CE rows, are left out.
+func pdfSections(plannings []models.Planning, days []time.Time) []pdfSection {
+	index := make(map[string]int, len(days))
+	for i, day := range days {
+		index[day.Format("2006-01-02")] = i
+	}
+
+	sort.Slice(plannings, func(i, j int) bool {
+		if !plannings[i].Date.Equal(plannings[j].Date) {
+			return plannings[i].Date.Before(plannings[j].Date)
+		}
+		return plannings[i].Shift < plannings[j].Shift
+	})
+
+	sections := make(map[string]*pdfSection)
+	for _, p := range plannings {
+		if p.Employee == nil {
+			continue
+		}
+		day, ok := index[p.Date.Format("2006-01-02")]
+		if !ok {
+			continue
+		}
+
+		ce, sector := "No CE", "No sector"
+		if p.CE != nil {
+			ce = p.CE.Name
+		}
+		if p.Sector != nil {
+			sector = p.Sector.Name
+		}
+
+		section, ok := sections[ce]
+		if !ok {
+			section = &pdfSection{CE: ce, Cells: make(map[string][][]string)}
+			sections[ce] = section
+		}
+		if _, ok := section.Cells[sector]; !ok {
+			section.Sectors = append(section.Sectors, sector)
+			section.Cells[sector] = make([][]string, len(days))
+		}
+		section.Cells[sector][day] = append(section.Cells[sector][day], pdfCellLine(p))
+	}
+
+	result := make([]pdfSection, 0, len(sections))
+	for _, section := range sections {
+		sort.Strings(section.Sectors)
+		result = append(result, *section)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].CE < result[j].CE
+	})
+	return result
+}
+
+// renderRosterPDF draws the roster on landscape pages, A4 for a week and A3
+// for a month, repeating the day header on each page.
+func renderRosterPDF(title string, days []time.Time, sections []pdfSection) ([]byte, error) {
+	size, fontSize := "A4", 7.0
+	if len(days) > 7 {
+		size, fontSize = "A3", 5.0
+	}
+
+	pdf := fpdf.New("L", "mm", size, "")
+	tr := pdf.UnicodeTranslatorFromDescriptor("")
+	pdf.SetMargins(8, 8, 8)
+	pdf.SetAutoPageBreak(false, 8)
+	pdf.SetTitle(title, true)
+
+	pageWidth, pageHeight := pdf.GetPageSize()
+	left, _, right, bottom := pdf.GetMargins()
+	sectorWidth := 28.0
+	dayWidth := (pageWidth - left - right - sectorWidth) / float64(len(days))
+	lineHeight := fontSize * 0.5
+
+	header := func() {
+		pdf.AddPage()
+		pdf.SetFont("Helvetica", "B", 12)
+		pdf.CellFormat(0, 8, tr(title), "", 1, "L", false, 0, "")
+
+		pdf.SetFont("Helvetica", "B", fontSize)
+		pdf.SetFillColor(230, 230, 230)
+		pdf.CellFormat(sectorWidth, lineHeight*2, tr("Sector"), "1", 0, "C", true, 0, "")
+		for _, day := range days {
+			label := fmt.Sprintf("%s %s", weekdayCode(day), day.Format("02/01"))
+			pdf.CellFormat(dayWidth, lineHeight*2, label, "1", 0, "C", true, 0, "")
+		}
+		pdf.Ln(-1)
+		pdf.SetFont("Helvetica", "", fontSize)
+	}
+	ensureSpace := func(height float64) {
+		if pdf.GetY()+height > pageHeight-bottom {
+			header()
+		}
+	}
+
+	header()
+	for i, section := range sections {
+		color := pdfBandColors[i%len(pdfBandColors)]
+
+		ensureSpace(lineHeight * 3)
+		pdf.SetFont("Helvetica", "B", fontSize+1)
+		pdf.SetFillColor(color[0], color[1], color[2])
+		pdf.CellFormat(0, lineHeight*1.6, tr(section.CE), "1", 1, "L", true, 0, "")
+		pdf.SetFont("Helvetica", "", fontSize)
+
+		for _, sector := range section.Sectors {
+			cells := section.Cells[sector]
+
+			// Wrap every cell first, the tallest one sets the row height
+			wrapped := make([][]string, len(cells))
+			lines := 1
+			for day, entries := range cells {
+				for _, entry := range entries {
+					for _, line := range pdf.SplitLines([]byte(tr(entry)), dayWidth-1) {
+						wrapped[day] = append(wrapped[day], string(line))
+					}
+				}
+				if len(wrapped[day]) > lines {
+					lines = len(wrapped[day])
+				}
+			}
+			height := float64(lines)*lineHeight + 1
+
+			ensureSpace(height)
+			x, y := left, pdf.GetY()
+
+			pdf.SetFillColor(color[0], color[1], color[2])
+			pdf.Rect(x, y, sectorWidth, height, "FD")
+			pdf.SetXY(x+0.5, y+0.5)
+			pdf.MultiCell(sectorWidth-1, lineHeight, tr(sector), "", "L", false)
+
+			for day := range cells {
+				cellX := left + sectorWidth + float64(day)*dayWidth
+				pdf.Rect(cellX, y, dayWidth, height, "D")
+				for n, line := range wrapped[day] {
+					pdf.SetXY(cellX+0.5, y+0.5+float64(n)*lineHeight)
+					pdf.CellFormat(dayWidth-1, lineHeight, line, "", 0, "L", false, 0, "")
+				}
+			}
+			pdf.SetXY(left, y+height)
+		}
+	}
+
+	pdf.SetY(pageHeight - bottom - lineHeight)
+	pdf.SetFont("Helvetica", "I", fontSize)
+	pdf.CellFormat(0, lineHeight, "Printed "+time.Now().Format("02/01/2006 15:04"), "", 0, "R", false, 0, "")
+
+	var buf bytes.Buffer
+	if err := pdf.Output(&buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// rosterPeriod reads either an ISO week or a month of the year from the
+// query, and returns its first day, the day after its last and a title.
+func rosterPeriod(c *gin.Context) (time.Time, time.Time, string, error) {
+	if monthStr := c.Query("month"); monthStr != "" {
+		year := time.Now().Year()
+		if yearStr := c.Query("year"); yearStr != "" {
+			var err error
+			if year, err = strconv.Atoi(yearStr); err != nil {
+				return time.Time{}, time.Time{}, "", fmt.Errorf("invalid year parameter")
+			}
+		}
+		month, err := strconv.Atoi(monthStr)
+		if err != nil || month < 1 || month > 12 {
+			return time.Time{}, time.Time{}, "", fmt.Errorf("invalid month parameter")
+		}
+		from := time.Date(year, time.Month(month), 1, 0, 0, 0, 0, time.UTC)
+		return from, from.AddDate(0, 1, 0), fmt.Sprintf("Roster %d-%02d", year, month), nil
+	}
+
+	year, week, err := parseISOWeek(c.Query("year"), c.Query("week"))
+	if err != nil {
+		return time.Time{}, time.Time{}, "", err
+	}
+	from, to := isoWeekRange(year, week)
+	return from, to, fmt.Sprintf("Roster %d-W%02d", year, week), nil
+}
+
+// ExportRosterPDF renders the planning of a week, or of a month when the
+// month parameter is given, as a printable PDF.
+func (h *Handler) ExportRosterPDF(c *gin.Context) {
+	from, to, title, err := rosterPeriod(c)
+	if err != nil {
+		h.respondWithError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	plannings, err := h.rosterPlannings(c, from, to)
+	if err != nil {
+		h.respondWithError(c, http.StatusInternalServerError, "Failed to fetch planning data")
+		return
+	}
+
+	var days []time.Time
+	for day := from; day.Before(to); day = day.AddDate(0, 0, 1) {
+		days = append(days, day)
+	}
+
+	data, err := renderRosterPDF(title, days, pdfSections(plannings, days))
+	if err != nil {
+		h.respondWithError(c, http.StatusInternalServerError, "Failed to render roster")
+		return
+	}
+
+	c.Header("Content-Disposition", fmt.Sprintf(`inline; filename="%s.pdf"`, strings.ReplaceAll(title, " ", "_")))
+	c.Data(http.StatusOK, "application/pdf", data)
+}
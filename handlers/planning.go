@@ -1,68 +1,130 @@
 package handlers
 
 import (
-	"gorm.io/gorm"
+	"errors"
+	"fmt"
 	"net/http"
-	"strconv"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
 	"planning_hager/models"
 )
 
-func (h *Handler) GetPlannings(c *gin.Context) {
-	weekStr := c.Query("week")
-	week, err := strconv.Atoi(weekStr)
-	if err != nil {
-		h.respondWithError(c, http.StatusBadRequest, "Invalid week parameter")
-		return
+// visiblePlannings keeps the rows users other than admins may see, those of
+// published weeks.
+func visiblePlannings(db *gorm.DB, plannings []models.Planning) ([]models.Planning, error) {
+	var publications []models.WeekPublication
+	if err := db.Find(&publications).Error; err != nil {
+		return nil, err
+	}
+	visible := make(map[[2]int]bool, len(publications))
+	for _, p := range publications {
+		visible[[2]int{p.Year, p.Week}] = isWeekVisible(p)
 	}
 
+	published := plannings[:0]
+	for _, p := range plannings {
+		if visible[[2]int{p.Year, p.Week}] {
+			published = append(published, p)
+		}
+	}
+	return published, nil
+}
+
+// rosterPlannings loads the planning rows dated between from and to with
+// everything the planning grid shows, leaving out unpublished weeks for users
+// other than admins.
+func (h *Handler) rosterPlannings(c *gin.Context, from, to time.Time) ([]models.Planning, error) {
 	var plannings []models.Planning
-	if err := h.DB.Preload("Employee").Preload("Sector").Preload("CE").Preload("Substitute").
-		Where("week = ?", week).
+	if err := h.DB.Preload("Employee").Preload("Sector").Preload("CE").Preload("Substitute").Preload("SubstituteReservist").
+		Where("date >= ? AND date < ?", from, to).
 		Find(&plannings).Error; err != nil {
-		h.respondWithError(c, http.StatusInternalServerError, "Failed to fetch planning data")
-		return
+		return nil, err
 	}
 
-	response := make([]gin.H, len(plannings))
-	for i, p := range plannings {
-		entry := gin.H{
-			"id":     p.ID,
-			"date":   p.Date,
-			"week":   p.Week,
-			"day":    p.Weekday,
-			"shift":  p.Shift,
-			"status": p.Status,
-		}
+	if c.GetString("role") == "admin" {
+		return plannings, nil
+	}
+	return visiblePlannings(h.DB, plannings)
+}
 
-		if p.Sector != nil {
-			entry["sector"] = p.Sector
-		}
+func planningEntry(p models.Planning, shifts map[string]models.ShiftDefinition) gin.H {
+	entry := gin.H{
+		"id":     p.ID,
+		"date":   p.Date,
+		"year":   p.Year,
+		"week":   p.Week,
+		"day":    weekdayCode(p.Date),
+		"shift":  p.Shift,
+		"status": p.Status,
+	}
 
-		if p.Employee != nil {
-			entry["employee"] = gin.H{
-				"id":   p.Employee.ID,
-				"name": p.Employee.Name,
-			}
+	if definition, ok := shifts[p.Shift]; ok {
+		start, end := shiftBounds(definition, p.Date)
+		entry["start"] = start
+		entry["end"] = end
+	}
+
+	if p.Sector != nil {
+		entry["sector"] = p.Sector
+	}
+
+	if p.Employee != nil {
+		entry["employee"] = gin.H{
+			"id":   p.Employee.ID,
+			"name": p.Employee.Name,
 		}
+	}
 
-		if p.CE != nil {
-			entry["ce"] = gin.H{
-				"id":   p.CE.ID,
-				"name": p.CE.Name,
-			}
+	if p.CE != nil {
+		entry["ce"] = gin.H{
+			"id":   p.CE.ID,
+			"name": p.CE.Name,
 		}
+	}
 
-		if p.Substitute != nil {
-			entry["substitute"] = gin.H{
-				"id":   p.Substitute.ID,
-				"name": p.Substitute.Name,
-			}
+	if p.Substitute != nil {
+		entry["substitute"] = gin.H{
+			"id":   p.Substitute.ID,
+			"name": p.Substitute.Name,
+			"type": CandidateEmployee,
+		}
+	} else if p.SubstituteReservist != nil {
+		entry["substitute"] = gin.H{
+			"id":   p.SubstituteReservist.ID,
+			"name": p.SubstituteReservist.Name,
+			"type": CandidateReservist,
 		}
+	}
+
+	return entry
+}
+
+func (h *Handler) GetPlannings(c *gin.Context) {
+	year, week, err := parseISOWeek(c.Query("year"), c.Query("week"))
+	if err != nil {
+		h.respondWithError(c, http.StatusBadRequest, "Invalid year or week parameter")
+		return
+	}
+
+	from, to := isoWeekRange(year, week)
 
-		response[i] = entry
+	plannings, err := h.rosterPlannings(c, from, to)
+	if err != nil {
+		h.respondWithError(c, http.StatusInternalServerError, "Failed to fetch planning data")
+		return
+	}
+
+	shifts, err := loadShiftDefinitions(h.DB)
+	if err != nil {
+		h.respondWithError(c, http.StatusInternalServerError, "Failed to fetch shift definitions")
+		return
+	}
+
+	response := make([]gin.H, len(plannings))
+	for i, p := range plannings {
+		response[i] = planningEntry(p, shifts)
 	}
 
 	h.respondWithSuccess(c, http.StatusOK, response)
@@ -71,11 +133,14 @@ func (h *Handler) GetPlannings(c *gin.Context) {
 func (h *Handler) AddPlanning(c *gin.Context) {
 	var input struct {
 		Date       string `json:"date" binding:"required"`
-		Week       int    `json:"week" binding:"required"`
+		Week       int    `json:"week"`
 		Shift      string `json:"shift" binding:"required"`
 		SectorID   uint   `json:"sector_id" binding:"required"`
 		EmployeeID uint   `json:"employee_id" binding:"required"`
 		Status     string `json:"status" binding:"required"`
+		LeaveType  string `json:"leave_type"`
+
+		OverrideReason string `json:"override_reason"`
 	}
 
 	if err := c.ShouldBindJSON(&input); err != nil {
@@ -89,34 +154,94 @@ func (h *Handler) AddPlanning(c *gin.Context) {
 		return
 	}
 
+	if err := validateShiftCode(h.DB, input.Shift); err != nil {
+		h.respondWithError(c, http.StatusBadRequest, "Invalid shift")
+		return
+	}
+
+	year, week := date.ISOWeek()
+	if input.Week != 0 && input.Week != week {
+		h.respondWithError(c, http.StatusBadRequest, "Week does not match date")
+		return
+	}
+
+	if input.LeaveType != "" {
+		valid, err := isValidLeaveType(h.DB, input.LeaveType)
+		if err != nil {
+			h.respondWithError(c, http.StatusInternalServerError, "Failed to fetch leave types")
+			return
+		}
+		if !valid {
+			h.respondWithError(c, http.StatusBadRequest, "Invalid leave type")
+			return
+		}
+	}
+
 	planning := models.Planning{
 		Date:       date,
-		Week:       input.Week,
-		Year:       date.Year(),
+		Week:       week,
+		Year:       year,
 		Shift:      input.Shift,
 		SectorID:   &input.SectorID,
 		EmployeeID: &input.EmployeeID,
 		Status:     input.Status,
+		LeaveType:  input.LeaveType,
+	}
+
+	tx := h.DB.Begin()
+
+	if !h.checkPlanningUnlocked(c, tx, date, date.AddDate(0, 0, 1), overrideReason(c, input.OverrideReason)) {
+		return
+	}
+	baseline, ok := h.startLabourCheck(c, tx, date, date.AddDate(0, 0, 1))
+	if !ok {
+		return
 	}
 
-	if err := h.DB.Create(&planning).Error; err != nil {
+	if err := tx.Create(&planning).Error; err != nil {
+		tx.Rollback()
 		h.respondWithError(c, http.StatusInternalServerError, "Failed to create planning entry")
 		return
 	}
 
+	if err := syncLeaveDebits(tx, []models.Planning{planning}, c.GetString("username")); err != nil {
+		tx.Rollback()
+		h.respondWithError(c, http.StatusInternalServerError, "Failed to update leave balance")
+		return
+	}
+
+	if err := h.audit(c, tx, AuditCreate, "planning", planning.ID, nil, planning); err != nil {
+		tx.Rollback()
+		h.respondWithError(c, http.StatusInternalServerError, "Failed to record audit log")
+		return
+	}
+
+	violations, ok := h.checkLabourRules(c, tx, baseline, planningEmployeeIDs([]models.Planning{planning}), date, date.AddDate(0, 0, 1))
+	if !ok {
+		return
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		h.respondWithError(c, http.StatusInternalServerError, "Failed to commit transaction")
+		return
+	}
+
 	if err := h.DB.Preload("Sector").Preload("Employee").First(&planning, planning.ID).Error; err != nil {
 		h.respondWithError(c, http.StatusInternalServerError, "Failed to fetch created planning entry")
 		return
 	}
 
-	h.respondWithSuccess(c, http.StatusCreated, planning)
+	h.respondWithSuccess(c, http.StatusCreated, planningResponse{Planning: planning, Violations: violations})
 }
 
 func (h *Handler) UpdatePlanning(c *gin.Context) {
 	id := c.Param("id")
 	var input struct {
-		Status       string `json:"status" binding:"required"`
-		SubstituteID *uint  `json:"substituteId"`
+		Status                string  `json:"status" binding:"required"`
+		SubstituteID          *uint   `json:"substituteId"`
+		SubstituteReservistID *uint   `json:"substituteReservistId"`
+		LeaveType             *string `json:"leaveType"`
+		OverrideReason        string  `json:"override_reason"`
 	}
 
 	if err := c.ShouldBindJSON(&input); err != nil {
@@ -124,6 +249,23 @@ func (h *Handler) UpdatePlanning(c *gin.Context) {
 		return
 	}
 
+	if input.SubstituteID != nil && input.SubstituteReservistID != nil {
+		h.respondWithError(c, http.StatusBadRequest, "A substitute is either an employee or a reservist")
+		return
+	}
+
+	if input.LeaveType != nil && *input.LeaveType != "" {
+		valid, err := isValidLeaveType(h.DB, *input.LeaveType)
+		if err != nil {
+			h.respondWithError(c, http.StatusInternalServerError, "Failed to fetch leave types")
+			return
+		}
+		if !valid {
+			h.respondWithError(c, http.StatusBadRequest, "Invalid leave type")
+			return
+		}
+	}
+
 	tx := h.DB.Begin()
 
 	var planning models.Planning
@@ -133,12 +275,24 @@ func (h *Handler) UpdatePlanning(c *gin.Context) {
 		return
 	}
 
+	if !h.checkPlanningUnlocked(c, tx, planning.Date, planning.Date.AddDate(0, 0, 1), overrideReason(c, input.OverrideReason)) {
+		return
+	}
+	baseline, ok := h.startLabourCheck(c, tx, planning.Date, planning.Date.AddDate(0, 0, 1))
+	if !ok {
+		return
+	}
+
+	before := planning
+	changed := []models.Planning{}
+
 	// If a substitute is being assigned
 	if input.SubstituteID != nil {
 		// Check if the substitute is already assigned elsewhere on the same date and shift
 		var existingAssignment models.Planning
 		if err := tx.Where("date = ? AND shift = ? AND employee_id = ?", planning.Date, planning.Shift, input.SubstituteID).First(&existingAssignment).Error; err == nil {
 			// Remove the substitute from their original position
+			existingBefore := existingAssignment
 			existingAssignment.EmployeeID = nil
 			existingAssignment.Status = "Unassigned"
 			if err := tx.Save(&existingAssignment).Error; err != nil {
@@ -146,12 +300,46 @@ func (h *Handler) UpdatePlanning(c *gin.Context) {
 				h.respondWithError(c, http.StatusInternalServerError, "Failed to update existing assignment")
 				return
 			}
+			if err := h.audit(c, tx, AuditUpdate, "planning", existingAssignment.ID, existingBefore, existingAssignment); err != nil {
+				tx.Rollback()
+				h.respondWithError(c, http.StatusInternalServerError, "Failed to record audit log")
+				return
+			}
+			changed = append(changed, existingAssignment)
+		}
+	}
+
+	// If a reservist is being assigned
+	if input.SubstituteReservistID != nil {
+		var reservist models.Reservist
+		if err := tx.First(&reservist, *input.SubstituteReservistID).Error; err != nil {
+			tx.Rollback()
+			h.respondWithError(c, http.StatusBadRequest, "Reservist not found")
+			return
+		}
+
+		var count int64
+		if err := tx.Model(&models.Planning{}).
+			Where("date = ? AND shift = ? AND substitute_reservist_id = ? AND id <> ?", planning.Date, planning.Shift, reservist.ID, planning.ID).
+			Count(&count).Error; err != nil {
+			tx.Rollback()
+			h.respondWithError(c, http.StatusInternalServerError, "Failed to check reservist assignments")
+			return
+		}
+		if count > 0 {
+			tx.Rollback()
+			h.respondWithError(c, http.StatusConflict, "Reservist is already assigned on this shift")
+			return
 		}
 	}
 
 	// Update the planning entry
 	planning.Status = input.Status
 	planning.SubstituteID = input.SubstituteID
+	planning.SubstituteReservistID = input.SubstituteReservistID
+	if input.LeaveType != nil {
+		planning.LeaveType = *input.LeaveType
+	}
 
 	if err := tx.Save(&planning).Error; err != nil {
 		tx.Rollback()
@@ -159,37 +347,82 @@ func (h *Handler) UpdatePlanning(c *gin.Context) {
 		return
 	}
 
+	// The displaced row may have been a planned absence, its debit is refunded
+	if err := syncLeaveDebits(tx, append(changed, planning), c.GetString("username")); err != nil {
+		tx.Rollback()
+		h.respondWithError(c, http.StatusInternalServerError, "Failed to update leave balance")
+		return
+	}
+
+	if err := h.audit(c, tx, AuditUpdate, "planning", planning.ID, before, planning); err != nil {
+		tx.Rollback()
+		h.respondWithError(c, http.StatusInternalServerError, "Failed to record audit log")
+		return
+	}
+
+	violations, ok := h.checkLabourRules(c, tx, baseline, planningEmployeeIDs([]models.Planning{planning}), planning.Date, planning.Date.AddDate(0, 0, 1))
+	if !ok {
+		return
+	}
+
 	if err := tx.Commit().Error; err != nil {
 		h.respondWithError(c, http.StatusInternalServerError, "Failed to commit transaction")
 		return
 	}
 
-	h.respondWithSuccess(c, http.StatusOK, planning)
+	h.respondWithSuccess(c, http.StatusOK, planningResponse{Planning: planning, Violations: violations})
 }
 
 func (h *Handler) DeletePlanning(c *gin.Context) {
 	id := c.Param("id")
 
+	tx := h.DB.Begin()
+
 	var planning models.Planning
-	if err := h.DB.First(&planning, id).Error; err != nil {
+	if err := tx.First(&planning, id).Error; err != nil {
+		tx.Rollback()
 		h.respondWithError(c, http.StatusNotFound, "Planning entry not found")
 		return
 	}
 
-	if err := h.DB.Delete(&planning).Error; err != nil {
+	if !h.checkPlanningUnlocked(c, tx, planning.Date, planning.Date.AddDate(0, 0, 1), overrideReason(c, "")) {
+		return
+	}
+
+	if err := refundLeaveDebits(tx, []models.Planning{planning}, c.GetString("username")); err != nil {
+		tx.Rollback()
+		h.respondWithError(c, http.StatusInternalServerError, "Failed to update leave balance")
+		return
+	}
+
+	if err := tx.Delete(&planning).Error; err != nil {
+		tx.Rollback()
 		h.respondWithError(c, http.StatusInternalServerError, "Failed to delete planning entry")
 		return
 	}
 
+	if err := h.audit(c, tx, AuditDelete, "planning", planning.ID, planning, nil); err != nil {
+		tx.Rollback()
+		h.respondWithError(c, http.StatusInternalServerError, "Failed to record audit log")
+		return
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		h.respondWithError(c, http.StatusInternalServerError, "Failed to commit transaction")
+		return
+	}
+
 	h.respondWithSuccess(c, http.StatusOK, gin.H{"message": "Planning entry deleted successfully"})
 }
 
 func (h *Handler) AddCEPlanning(c *gin.Context) {
 	var input struct {
 		Date  string `json:"date" binding:"required"`
-		Week  int    `json:"week" binding:"required"`
+		Week  int    `json:"week"`
 		Shift string `json:"shift" binding:"required"`
 		CEID  uint   `json:"ce_id" binding:"required"`
+
+		OverrideReason string `json:"override_reason"`
 	}
 
 	if err := c.ShouldBindJSON(&input); err != nil {
@@ -203,54 +436,166 @@ func (h *Handler) AddCEPlanning(c *gin.Context) {
 		return
 	}
 
+	if err := validateShiftCode(h.DB, input.Shift); err != nil {
+		h.respondWithError(c, http.StatusBadRequest, "Invalid shift")
+		return
+	}
+
+	year, week := date.ISOWeek()
+	if input.Week != 0 && input.Week != week {
+		h.respondWithError(c, http.StatusBadRequest, "Week does not match date")
+		return
+	}
+
 	planning := models.Planning{
 		Date:  date,
-		Week:  input.Week,
-		Year:  date.Year(),
+		Week:  week,
+		Year:  year,
 		Shift: input.Shift,
 		CEID:  &input.CEID,
 	}
 
-	if err := h.DB.Create(&planning).Error; err != nil {
+	tx := h.DB.Begin()
+
+	if !h.checkPlanningUnlocked(c, tx, date, date.AddDate(0, 0, 1), overrideReason(c, input.OverrideReason)) {
+		return
+	}
+	baseline, ok := h.startLabourCheck(c, tx, date, date.AddDate(0, 0, 1))
+	if !ok {
+		return
+	}
+
+	if err := tx.Create(&planning).Error; err != nil {
+		tx.Rollback()
 		h.respondWithError(c, http.StatusInternalServerError, "Failed to create CE planning entry")
 		return
 	}
 
+	diff := planningDiff{Created: []models.Planning{planning}}
+	if err := h.auditPlanningDiff(c, tx, diff); err != nil {
+		tx.Rollback()
+		h.respondWithError(c, http.StatusInternalServerError, "Failed to record audit log")
+		return
+	}
+
+	if _, err := h.recordPlanningChangeSet(c, tx, ChangeSetCEPlanning,
+		fmt.Sprintf("Shift %s of CE %d added on %s", input.Shift, input.CEID, input.Date), diff); err != nil {
+		tx.Rollback()
+		h.respondWithError(c, http.StatusInternalServerError, "Failed to record change set")
+		return
+	}
+
+	violations, ok := h.checkLabourRules(c, tx, baseline, planningEmployeeIDs(diff.Created), date, date.AddDate(0, 0, 1))
+	if !ok {
+		return
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		h.respondWithError(c, http.StatusInternalServerError, "Failed to commit transaction")
+		return
+	}
+
 	if err := h.DB.Preload("CE").First(&planning, planning.ID).Error; err != nil {
 		h.respondWithError(c, http.StatusInternalServerError, "Failed to fetch created CE planning entry")
 		return
 	}
 
-	h.respondWithSuccess(c, http.StatusCreated, planning)
+	h.respondWithSuccess(c, http.StatusCreated, planningResponse{Planning: planning, Violations: violations})
 }
 
-func generateCESchedule(ceID uint, week int) map[int][]string {
-	schedule := make(map[int][]string)
-	ceIndex := int(ceID-1) % 4
-	weekInCycle := (week - 1) % 4
+// AddCEWeekPlanning schedules a CE and its team for a whole week, following
+// the rotation pattern and the regime stored for that week.
+func (h *Handler) AddCEWeekPlanning(c *gin.Context) {
+	var input struct {
+		Year int  `json:"year" binding:"required"`
+		Week int  `json:"week" binding:"required"`
+		CEID uint `json:"ce_id" binding:"required"`
+
+		OverrideReason string `json:"override_reason"`
+	}
+
+	if err := c.ShouldBindJSON(&input); err != nil {
+		h.respondWithError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if !isValidISOWeek(input.Year, input.Week) {
+		h.respondWithError(c, http.StatusBadRequest, "Invalid week parameter")
+		return
+	}
+
+	tx := h.DB.Begin()
+
+	desired, err := h.desiredPlanning(tx, input.Year, input.Week, input.Week, input.CEID)
+	if err != nil {
+		tx.Rollback()
+		h.respondWithError(c, http.StatusInternalServerError, "Failed to compute CE schedule")
+		return
+	}
 
-	patterns := [][]string{
-		{"", "M", "M", "M", "M", "M", ""}, // Week 1
-		{"S", "S", "S", "S", "", "", "N"}, // Week 2
-		{"N", "N", "", "", "S", "", ""},   // Week 3
-		{"M", "", "N", "N", "N", "", ""},  // Week 4
+	from, to := isoWeekRange(input.Year, input.Week)
+	var existing []models.Planning
+	if err := tx.Where("date >= ? AND date < ?", from, to).Find(&existing).Error; err != nil {
+		tx.Rollback()
+		h.respondWithError(c, http.StatusInternalServerError, "Failed to fetch planning data")
+		return
 	}
 
-	currentPattern := patterns[(ceIndex+weekInCycle)%4]
+	present := make(map[string]bool, len(existing))
+	for _, p := range existing {
+		present[planningKey(p)] = true
+	}
 
-	for day := 0; day < 7; day++ {
-		if currentPattern[day] != "" {
-			schedule[day] = append(schedule[day], currentPattern[day])
+	created := []models.Planning{}
+	for _, p := range desired {
+		if !present[planningKey(p)] {
+			present[planningKey(p)] = true
+			created = append(created, p)
 		}
 	}
 
-	return schedule
+	baseline, ok := h.startLabourCheck(c, tx, from, to)
+	if !ok {
+		return
+	}
+	if len(created) > 0 {
+		if !h.checkPlanningUnlocked(c, tx, from, to, overrideReason(c, input.OverrideReason)) {
+			return
+		}
+		if err := tx.CreateInBatches(&created, 100).Error; err != nil {
+			tx.Rollback()
+			h.respondWithError(c, http.StatusInternalServerError, "Failed to create CE planning entries")
+			return
+		}
+	}
+
+	if err := h.auditPlanningDiff(c, tx, planningDiff{Created: created}); err != nil {
+		tx.Rollback()
+		h.respondWithError(c, http.StatusInternalServerError, "Failed to record audit log")
+		return
+	}
+
+	violations, ok := h.checkLabourRules(c, tx, baseline, planningEmployeeIDs(created), from, to)
+	if !ok {
+		return
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		h.respondWithError(c, http.StatusInternalServerError, "Failed to commit transaction")
+		return
+	}
+
+	h.respondWithSuccess(c, http.StatusCreated, gin.H{
+		"created":    created,
+		"violations": violations,
+	})
 }
 
 func (h *Handler) UpdateCEPlanning(c *gin.Context) {
 	id := c.Param("id")
 	var input struct {
-		Status string `json:"status" binding:"required"`
+		Status         string `json:"status" binding:"required"`
+		OverrideReason string `json:"override_reason"`
 	}
 
 	if err := c.ShouldBindJSON(&input); err != nil {
@@ -258,20 +603,55 @@ func (h *Handler) UpdateCEPlanning(c *gin.Context) {
 		return
 	}
 
+	tx := h.DB.Begin()
+
 	var planning models.Planning
-	if err := h.DB.First(&planning, id).Error; err != nil {
+	if err := tx.First(&planning, id).Error; err != nil {
+		tx.Rollback()
 		h.respondWithError(c, http.StatusNotFound, "CE planning entry not found")
 		return
 	}
 
+	if !h.checkPlanningUnlocked(c, tx, planning.Date, planning.Date.AddDate(0, 0, 1), overrideReason(c, input.OverrideReason)) {
+		return
+	}
+	baseline, ok := h.startLabourCheck(c, tx, planning.Date, planning.Date.AddDate(0, 0, 1))
+	if !ok {
+		return
+	}
+
+	before := planning
 	planning.Status = input.Status
 
-	if err := h.DB.Save(&planning).Error; err != nil {
+	if err := tx.Save(&planning).Error; err != nil {
+		tx.Rollback()
 		h.respondWithError(c, http.StatusInternalServerError, "Failed to update CE planning entry")
 		return
 	}
 
-	h.respondWithSuccess(c, http.StatusOK, planning)
+	if err := syncLeaveDebits(tx, []models.Planning{planning}, c.GetString("username")); err != nil {
+		tx.Rollback()
+		h.respondWithError(c, http.StatusInternalServerError, "Failed to update leave balance")
+		return
+	}
+
+	if err := h.audit(c, tx, AuditUpdate, "planning", planning.ID, before, planning); err != nil {
+		tx.Rollback()
+		h.respondWithError(c, http.StatusInternalServerError, "Failed to record audit log")
+		return
+	}
+
+	violations, ok := h.checkLabourRules(c, tx, baseline, planningEmployeeIDs([]models.Planning{planning}), planning.Date, planning.Date.AddDate(0, 0, 1))
+	if !ok {
+		return
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		h.respondWithError(c, http.StatusInternalServerError, "Failed to commit transaction")
+		return
+	}
+
+	h.respondWithSuccess(c, http.StatusOK, planningResponse{Planning: planning, Violations: violations})
 }
 
 func (h *Handler) DeleteCEPlanning(c *gin.Context) {
@@ -286,6 +666,10 @@ func (h *Handler) DeleteCEPlanning(c *gin.Context) {
 		return
 	}
 
+	if !h.checkPlanningUnlocked(c, tx, cePlanning.Date, cePlanning.Date.AddDate(0, 0, 1), overrideReason(c, "")) {
+		return
+	}
+
 	// Delete CE planning entry
 	if err := tx.Delete(&cePlanning).Error; err != nil {
 		tx.Rollback()
@@ -293,6 +677,19 @@ func (h *Handler) DeleteCEPlanning(c *gin.Context) {
 		return
 	}
 
+	var associated []models.Planning
+	if err := tx.Where("date = ? AND shift = ? AND week = ?", cePlanning.Date, cePlanning.Shift, cePlanning.Week).Find(&associated).Error; err != nil {
+		tx.Rollback()
+		h.respondWithError(c, http.StatusInternalServerError, "Failed to fetch associated employee planning entries")
+		return
+	}
+
+	if err := refundLeaveDebits(tx, append(associated, cePlanning), c.GetString("username")); err != nil {
+		tx.Rollback()
+		h.respondWithError(c, http.StatusInternalServerError, "Failed to update leave balance")
+		return
+	}
+
 	// Delete associated employee planning entries
 	if err := tx.Where("date = ? AND shift = ? AND week = ?", cePlanning.Date, cePlanning.Shift, cePlanning.Week).Delete(&models.Planning{}).Error; err != nil {
 		tx.Rollback()
@@ -300,6 +697,12 @@ func (h *Handler) DeleteCEPlanning(c *gin.Context) {
 		return
 	}
 
+	if err := h.auditPlanningDiff(c, tx, planningDiff{Deleted: append(associated, cePlanning)}); err != nil {
+		tx.Rollback()
+		h.respondWithError(c, http.StatusInternalServerError, "Failed to record audit log")
+		return
+	}
+
 	if err := tx.Commit().Error; err != nil {
 		h.respondWithError(c, http.StatusInternalServerError, "Failed to commit transaction")
 		return
@@ -310,8 +713,9 @@ func (h *Handler) DeleteCEPlanning(c *gin.Context) {
 
 func (h *Handler) UpdateCEStatus(c *gin.Context) {
 	var input struct {
-		PlanningID uint   `json:"planning_id" binding:"required"`
-		Status     string `json:"status" binding:"required"`
+		PlanningID     uint   `json:"planning_id" binding:"required"`
+		Status         string `json:"status" binding:"required"`
+		OverrideReason string `json:"override_reason"`
 	}
 
 	if err := c.ShouldBindJSON(&input); err != nil {
@@ -324,26 +728,66 @@ func (h *Handler) UpdateCEStatus(c *gin.Context) {
 		return
 	}
 
+	tx := h.DB.Begin()
+
 	var planning models.Planning
-	if err := h.DB.First(&planning, input.PlanningID).Error; err != nil {
+	if err := tx.First(&planning, input.PlanningID).Error; err != nil {
+		tx.Rollback()
 		h.respondWithError(c, http.StatusNotFound, "Planning entry not found")
 		return
 	}
 
+	if !h.checkPlanningUnlocked(c, tx, planning.Date, planning.Date.AddDate(0, 0, 1), overrideReason(c, input.OverrideReason)) {
+		return
+	}
+	baseline, ok := h.startLabourCheck(c, tx, planning.Date, planning.Date.AddDate(0, 0, 1))
+	if !ok {
+		return
+	}
+
+	before := planning
 	planning.Status = input.Status
 
-	if err := h.DB.Save(&planning).Error; err != nil {
+	if err := tx.Save(&planning).Error; err != nil {
+		tx.Rollback()
 		h.respondWithError(c, http.StatusInternalServerError, "Failed to update status")
 		return
 	}
 
-	h.respondWithSuccess(c, http.StatusOK, planning)
+	if err := syncLeaveDebits(tx, []models.Planning{planning}, c.GetString("username")); err != nil {
+		tx.Rollback()
+		h.respondWithError(c, http.StatusInternalServerError, "Failed to update leave balance")
+		return
+	}
+
+	if err := h.audit(c, tx, AuditUpdate, "planning", planning.ID, before, planning); err != nil {
+		tx.Rollback()
+		h.respondWithError(c, http.StatusInternalServerError, "Failed to record audit log")
+		return
+	}
+
+	violations, ok := h.checkLabourRules(c, tx, baseline, planningEmployeeIDs([]models.Planning{planning}), planning.Date, planning.Date.AddDate(0, 0, 1))
+	if !ok {
+		return
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		h.respondWithError(c, http.StatusInternalServerError, "Failed to commit transaction")
+		return
+	}
+
+	h.respondWithSuccess(c, http.StatusOK, planningResponse{Planning: planning, Violations: violations})
 }
 
+// UpdatePlanningShiftType is kept for older clients; it behaves like
+// SetWeekRegime.
 func (h *Handler) UpdatePlanningShiftType(c *gin.Context) {
 	var input struct {
+		Year      int    `json:"year"`
 		Week      int    `json:"week" binding:"required"`
 		ShiftType string `json:"shiftType" binding:"required"`
+
+		OverrideReason string `json:"override_reason"`
 	}
 
 	if err := c.ShouldBindJSON(&input); err != nil {
@@ -351,50 +795,43 @@ func (h *Handler) UpdatePlanningShiftType(c *gin.Context) {
 		return
 	}
 
+	if input.Year == 0 {
+		input.Year, _ = time.Now().ISOWeek()
+	}
+	if !isValidISOWeek(input.Year, input.Week) {
+		h.respondWithError(c, http.StatusBadRequest, "Invalid week parameter")
+		return
+	}
+	if !isValidRegime(input.ShiftType) {
+		h.respondWithError(c, http.StatusBadRequest, "Invalid shift type")
+		return
+	}
+	if err := validateRegimeShifts(h.DB, input.ShiftType); err != nil {
+		h.respondWithError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
 	tx := h.DB.Begin()
 
-	year := time.Now().Year()
-	startDate := getWeekStartDate(year, input.Week)
+	from, to := isoWeekRange(input.Year, input.Week)
+	if !h.checkPlanningUnlocked(c, tx, from, to, overrideReason(c, input.OverrideReason)) {
+		return
+	}
+	baseline, ok := h.startLabourCheck(c, tx, from, to)
+	if !ok {
+		return
+	}
 
-	// Remove existing entries for Saturday morning and Sunday night
-	if err := tx.Where("week = ? AND (date = ? OR date = ?) AND (shift = ? OR shift = ?)",
-		input.Week,
-		startDate.AddDate(0, 0, 5), // Saturday
-		startDate.AddDate(0, 0, 6), // Sunday
-		"M", "N").Delete(&models.Planning{}).Error; err != nil {
+	_, diff, changeSet, err := h.setWeekRegime(c, tx, input.Year, input.Week, input.ShiftType)
+	if err != nil {
 		tx.Rollback()
-		h.respondWithError(c, http.StatusInternalServerError, "Failed to remove existing entries")
+		h.respondWithError(c, http.StatusInternalServerError, "Failed to update planning")
 		return
 	}
 
-	saturdayCEID, sundayCEID := getCEsForWeek(input.Week)
-
-	switch input.ShiftType {
-	case "4x8 L":
-		// Add Saturday morning shift
-		if err := addShiftWithCEAndTeam(tx, startDate.AddDate(0, 0, 5), input.Week, "M", saturdayCEID); err != nil {
-			tx.Rollback()
-			h.respondWithError(c, http.StatusInternalServerError, "Failed to add Saturday morning shift")
-			return
-		}
-
-		// Add Sunday night shift
-		if err := addShiftWithCEAndTeam(tx, startDate.AddDate(0, 0, 6), input.Week, "N", sundayCEID); err != nil {
-			tx.Rollback()
-			h.respondWithError(c, http.StatusInternalServerError, "Failed to add Sunday night shift")
-			return
-		}
-
-	case "4x8 N":
-		// Add only Saturday morning shift
-		if err := addShiftWithCEAndTeam(tx, startDate.AddDate(0, 0, 5), input.Week, "M", saturdayCEID); err != nil {
-			tx.Rollback()
-			h.respondWithError(c, http.StatusInternalServerError, "Failed to add Saturday morning shift")
-			return
-		}
-
-	case "4x8 C":
-		// No additional shifts to add
+	violations, ok := h.checkLabourRules(c, tx, baseline, planningEmployeeIDs(diff.touched()), from, to)
+	if !ok {
+		return
 	}
 
 	if err := tx.Commit().Error; err != nil {
@@ -402,84 +839,127 @@ func (h *Handler) UpdatePlanningShiftType(c *gin.Context) {
 		return
 	}
 
-	h.respondWithSuccess(c, http.StatusOK, gin.H{"message": "Planning updated successfully"})
+	h.respondWithSuccess(c, http.StatusOK, gin.H{
+		"message":       "Planning updated successfully",
+		"violations":    violations,
+		"change_set_id": changeSet.ID,
+	})
 }
 
-func getCEsForWeek(week int) (saturdayCEID, sundayCEID uint) {
-	switch week % 4 {
-	case 0:
-		return 2, 3
-	case 1:
-		return 1, 2
-	case 2:
-		return 4, 1
-	case 3:
-		return 3, 4
-	default:
-		return 0, 0 // This should never happen
-	}
+// PopulateResult is what PopulateYear changed, or would change on a dry run.
+type PopulateResult struct {
+	DryRun      bool              `json:"dry_run,omitempty"`
+	Summary     map[string]int    `json:"summary"`
+	Diff        planningDiff      `json:"-"`
+	Violations  []labourViolation `json:"violations"`
+	LockedWeeks []string          `json:"locked_weeks,omitempty"`
+	ChangeSetID uint              `json:"change_set_id,omitempty"`
 }
 
-func addShiftWithCEAndTeam(tx *gorm.DB, date time.Time, week int, shift string, ceID uint) error {
-	// Add CE to planning
-	cePlanning := models.Planning{
-		Date:   date,
-		Week:   week,
-		Year:   date.Year(),
-		Shift:  shift,
-		CEID:   &ceID,
-		Status: "Scheduled",
-	}
-	if err := tx.Create(&cePlanning).Error; err != nil {
-		return err
-	}
-
-	// Fetch CE with employees
-	var ce models.CE
-	if err := tx.Preload("Employees").First(&ce, ceID).Error; err != nil {
-		return err
-	}
-
-	// Add employees to planning
-	for _, emp := range ce.Employees {
-		empPlanning := models.Planning{
-			Date:       date,
-			Week:       week,
-			Year:       date.Year(),
-			Shift:      shift,
-			CEID:       &ceID,
-			EmployeeID: &emp.ID,
-			SectorID:   &emp.SectorID,
-			Status:     "Scheduled",
+// PopulateYear generates the planning of a year from the rotation patterns and
+// week regimes on behalf of username. A dry run saves nothing and lists the
+// changes, the labour rules they break and the protected weeks they touch.
+// Otherwise locked weeks are refused, past and published weeks need a reason,
+// which is kept in the audit log, and changes breaking blocking labour rules
+// that were not broken before are refused with a LabourRulesError.
+func PopulateYear(db *gorm.DB, year int, dryRun bool, username, reason string) (PopulateResult, error) {
+	h := NewHandler(db)
+	result := PopulateResult{DryRun: dryRun, LockedWeeks: []string{}}
+
+	// Start a transaction
+	tx := db.Begin()
+
+	diff, err := h.reconcileYearlyPlanning(tx, year)
+	if err != nil {
+		tx.Rollback()
+		return result, fmt.Errorf("computing yearly planning: %w", err)
+	}
+	result.Diff = diff
+	result.Summary = diff.summary()
+
+	// Only the weeks the diff changes need to be unlocked. A dry run lists the
+	// locked ones instead of refusing.
+	if lockedRows := append(diff.touched(), diff.Deleted...); len(lockedRows) > 0 {
+		lockedFrom, lockedTo := planningDateRange(lockedRows)
+		if dryRun {
+			result.LockedWeeks, err = lockedWeeks(tx, lockedFrom, lockedTo)
+		} else {
+			_, err = unlockPlanning(tx, lockedFrom, lockedTo, username, reason)
 		}
-		if err := tx.Create(&empPlanning).Error; err != nil {
-			return err
+		if err != nil {
+			tx.Rollback()
+			return result, err
 		}
 	}
 
-	return nil
-}
+	from, _ := isoWeekRange(year, 1)
+	_, to := isoWeekRange(year, isoWeeksInYear(year))
+	var baseline labourBaseline
+	if !dryRun {
+		if baseline, err = h.blockingBaseline(tx, from, to); err != nil {
+			tx.Rollback()
+			return result, fmt.Errorf("evaluating labour rules: %w", err)
+		}
+	}
+
+	// The diff is applied even for a dry run, so the labour rules see the
+	// planning as it would be
+	if err := applyPlanningDiff(tx, &diff); err != nil {
+		tx.Rollback()
+		return result, fmt.Errorf("applying yearly planning: %w", err)
+	}
+	result.Diff = diff
+
+	if dryRun {
+		// A dry run shows the violations along with the diff instead of
+		// refusing it
+		rules, err := h.labourRules(tx)
+		if err == nil {
+			result.Violations, err = h.evaluateLabourRules(tx, rules, planningEmployeeIDs(diff.touched()), from, to)
+		}
+		tx.Rollback()
+		if err != nil {
+			return result, fmt.Errorf("evaluating labour rules: %w", err)
+		}
+		return result, nil
+	}
 
-func getWeekStartDate(year, week int) time.Time {
-	// Jan 1 of the year
-	t := time.Date(year, 1, 1, 0, 0, 0, 0, time.UTC)
+	violations, blocking, err := h.enforceLabourRules(tx, baseline, planningEmployeeIDs(diff.touched()), from, to)
+	if err != nil {
+		tx.Rollback()
+		return result, fmt.Errorf("evaluating labour rules: %w", err)
+	}
+	if len(blocking) > 0 {
+		tx.Rollback()
+		return result, &LabourRulesError{Violations: blocking}
+	}
+	result.Violations = violations
 
-	// Roll forward to Monday
-	if wd := t.Weekday(); wd == time.Sunday {
-		t = t.AddDate(0, 0, 1)
-	} else if wd != time.Monday {
-		t = t.AddDate(0, 0, int(time.Monday-wd))
+	if err := auditPlanningDiffBy(tx, username, reason, diff); err != nil {
+		tx.Rollback()
+		return result, fmt.Errorf("recording audit log: %w", err)
 	}
 
-	// Add weeks
-	t = t.AddDate(0, 0, (week-1)*7)
+	changeSet, err := createPlanningChangeSet(tx, username, ChangeSetPopulateYear, fmt.Sprintf("Yearly planning %d populated", year), diff)
+	if err != nil {
+		tx.Rollback()
+		return result, fmt.Errorf("recording change set: %w", err)
+	}
+	result.ChangeSetID = changeSet.ID
 
-	return t
+	// Commit the transaction
+	if err := tx.Commit().Error; err != nil {
+		return result, fmt.Errorf("committing yearly planning: %w", err)
+	}
+	return result, nil
 }
 
 func (h *Handler) PopulateYearlyPlanning(c *gin.Context) {
 	var input struct {
-		Year int `json:"year" binding:"required"`
+		Year   int  `json:"year" binding:"required"`
+		DryRun bool `json:"dry_run"`
+
+		OverrideReason string `json:"override_reason"`
 	}
 
 	if err := c.ShouldBindJSON(&input); err != nil {
@@ -487,87 +967,45 @@ func (h *Handler) PopulateYearlyPlanning(c *gin.Context) {
 		return
 	}
 
-	// Start a transaction
-	tx := h.DB.Begin()
-
-	// Fetch all CEs
-	var ces []models.CE
-	if err := tx.Find(&ces).Error; err != nil {
-		tx.Rollback()
-		h.respondWithError(c, http.StatusInternalServerError, "Failed to fetch CEs")
-		return
+	// The reason given for the change overrides past and published weeks as well
+	reason := overrideReason(c, input.OverrideReason)
+	if reason == "" {
+		reason = c.GetHeader(ChangeReasonHeader)
 	}
 
-	// Calculate the first Monday of the year
-	firstDay := time.Date(input.Year, 1, 1, 0, 0, 0, 0, time.UTC)
-	for firstDay.Weekday() != time.Monday {
-		firstDay = firstDay.AddDate(0, 0, 1)
-	}
-
-	// Generate planning for 52 weeks
-	for week := 1; week <= 52; week++ {
-		weekStart := firstDay.AddDate(0, 0, (week-1)*7)
-
-		for _, ce := range ces {
-			schedule := generateCESchedule(ce.ID, week)
-
-			// Fetch employees under this CE
-			var employees []models.Employee
-			if err := tx.Where("ce_id = ?", ce.ID).Find(&employees).Error; err != nil {
-				tx.Rollback()
-				h.respondWithError(c, http.StatusInternalServerError, "Failed to fetch employees")
-				return
-			}
-
-			for day, shifts := range schedule {
-				currentDate := weekStart.AddDate(0, 0, day)
-				for _, shift := range shifts {
-					// Add CE planning
-					cePlanning := models.Planning{
-						Date:   currentDate,
-						Week:   week,
-						Year:   input.Year,
-						Shift:  shift,
-						CEID:   &ce.ID,
-						Status: "Scheduled",
-					}
-
-					if err := tx.Create(&cePlanning).Error; err != nil {
-						tx.Rollback()
-						h.respondWithError(c, http.StatusInternalServerError, "Failed to create CE planning entry")
-						return
-					}
-
-					// Add employee planning
-					for _, emp := range employees {
-						empPlanning := models.Planning{
-							Date:       currentDate,
-							Week:       week,
-							Year:       input.Year,
-							Shift:      shift,
-							EmployeeID: &emp.ID,
-							SectorID:   &emp.SectorID,
-							Status:     "Scheduled",
-						}
-
-						if err := tx.Create(&empPlanning).Error; err != nil {
-							tx.Rollback()
-							h.respondWithError(c, http.StatusInternalServerError, "Failed to create employee planning entry")
-							return
-						}
-					}
-				}
-			}
-		}
+	result, err := PopulateYear(h.DB, input.Year, input.DryRun, c.GetString("username"), reason)
+	var lockedErr *PlanningLockedError
+	var labourErr *LabourRulesError
+	switch {
+	case errors.As(err, &lockedErr):
+		h.respondWithLocked(c, lockedErr)
+		return
+	case errors.As(err, &labourErr):
+		h.respondWithViolations(c, labourErr.Violations)
+		return
+	case err != nil:
+		h.respondWithError(c, http.StatusInternalServerError, "Failed to populate yearly planning")
+		return
 	}
 
-	// Commit the transaction
-	if err := tx.Commit().Error; err != nil {
-		h.respondWithError(c, http.StatusInternalServerError, "Failed to commit transaction")
+	if input.DryRun {
+		h.respondWithSuccess(c, http.StatusOK, gin.H{
+			"dry_run":      true,
+			"summary":      result.Summary,
+			"diff":         result.Diff,
+			"violations":   result.Violations,
+			"locked_weeks": result.LockedWeeks,
+		})
 		return
 	}
 
-	h.respondWithSuccess(c, http.StatusCreated, gin.H{"message": "Yearly planning populated successfully"})
+	h.respondWithSuccess(c, http.StatusCreated, gin.H{
+		"message":       "Yearly planning populated successfully",
+		"summary":       result.Summary,
+		"diff":          result.Diff,
+		"violations":    result.Violations,
+		"change_set_id": result.ChangeSetID,
+	})
 }
 
 func isValidStatus(status string) bool {
@@ -585,6 +1023,8 @@ func (h *Handler) BulkUpdatePlanning(c *gin.Context) {
 		CEID       uint      `json:"ce_id" binding:"required"`
 		SectorID   uint      `json:"sector_id" binding:"required"`
 		StartDate  time.Time `json:"start_date" binding:"required"`
+
+		OverrideReason string `json:"override_reason"`
 	}
 
 	if err := c.ShouldBindJSON(&input); err != nil {
@@ -592,17 +1032,83 @@ func (h *Handler) BulkUpdatePlanning(c *gin.Context) {
 		return
 	}
 
-	if err := h.DB.Model(&models.Planning{}).
+	tx := h.DB.Begin()
+
+	var last models.Planning
+	to := input.StartDate.AddDate(0, 0, 1)
+	if err := tx.Where("employee_id = ? AND date >= ?", input.EmployeeID, input.StartDate).
+		Order("date DESC").Limit(1).Find(&last).Error; err != nil {
+		tx.Rollback()
+		h.respondWithError(c, http.StatusInternalServerError, "Failed to fetch planning data")
+		return
+	}
+	if last.ID != 0 {
+		to = last.Date.AddDate(0, 0, 1)
+	}
+
+	if !h.checkPlanningUnlocked(c, tx, input.StartDate, to, overrideReason(c, input.OverrideReason)) {
+		return
+	}
+	baseline, ok := h.startLabourCheck(c, tx, input.StartDate, to)
+	if !ok {
+		return
+	}
+
+	var before []models.Planning
+	if err := tx.Where("employee_id = ? AND date >= ?", input.EmployeeID, input.StartDate).
+		Order("id").Find(&before).Error; err != nil {
+		tx.Rollback()
+		h.respondWithError(c, http.StatusInternalServerError, "Failed to fetch planning data")
+		return
+	}
+
+	if err := tx.Model(&models.Planning{}).
 		Where("employee_id = ? AND date >= ?", input.EmployeeID, input.StartDate).
 		Updates(map[string]interface{}{
 			"ce_id":     input.CEID,
 			"sector_id": input.SectorID,
 		}).Error; err != nil {
+		tx.Rollback()
 		h.respondWithError(c, http.StatusInternalServerError, "Failed to update planning entries")
 		return
 	}
 
-	h.respondWithSuccess(c, http.StatusOK, gin.H{"message": "Planning entries updated successfully"})
+	diff := planningDiff{}
+	for _, p := range before {
+		after := p
+		after.CEID = &input.CEID
+		after.SectorID = &input.SectorID
+		diff.Updated = append(diff.Updated, planningChange{Before: p, After: after})
+	}
+	if err := h.auditPlanningDiff(c, tx, diff); err != nil {
+		tx.Rollback()
+		h.respondWithError(c, http.StatusInternalServerError, "Failed to record audit log")
+		return
+	}
+
+	changeSet, err := h.recordPlanningChangeSet(c, tx, ChangeSetBulkUpdate,
+		fmt.Sprintf("Planning of employee %d moved to CE %d, sector %d from %s", input.EmployeeID, input.CEID, input.SectorID, input.StartDate.Format("2006-01-02")), diff)
+	if err != nil {
+		tx.Rollback()
+		h.respondWithError(c, http.StatusInternalServerError, "Failed to record change set")
+		return
+	}
+
+	violations, ok := h.checkLabourRules(c, tx, baseline, []uint{input.EmployeeID}, input.StartDate, to)
+	if !ok {
+		return
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		h.respondWithError(c, http.StatusInternalServerError, "Failed to commit transaction")
+		return
+	}
+
+	h.respondWithSuccess(c, http.StatusOK, gin.H{
+		"message":       "Planning entries updated successfully",
+		"violations":    violations,
+		"change_set_id": changeSet.ID,
+	})
 }
 
 const (
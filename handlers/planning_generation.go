@@ -0,0 +1,293 @@
+package handlers
+
+import (
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+	"planning_hager/models"
+)
+
+// Sources recorded on generated planning rows. Rows without a source were
+// entered by hand and are never removed by a regeneration, neither are rows
+// exchanged through an approved shift swap.
+const (
+	PlanningSourceRotation = "rotation"
+	PlanningSourceRegime   = "regime"
+	PlanningSourceSwap     = "swap"
+)
+
+type planningChange struct {
+	Before models.Planning `json:"before"`
+	After  models.Planning `json:"after"`
+}
+
+type planningDiff struct {
+	Created []models.Planning `json:"created"`
+	Updated []planningChange  `json:"updated"`
+	Deleted []models.Planning `json:"deleted"`
+}
+
+func (d planningDiff) summary() map[string]int {
+	return map[string]int{
+		"created": len(d.Created),
+		"updated": len(d.Updated),
+		"deleted": len(d.Deleted),
+	}
+}
+
+// touched returns the rows the diff creates or changes, as they are saved.
+func (d planningDiff) touched() []models.Planning {
+	rows := append([]models.Planning{}, d.Created...)
+	for _, change := range d.Updated {
+		rows = append(rows, change.After)
+	}
+	return rows
+}
+
+// planningDateRange returns the first day of the rows and the day after the
+// last one.
+func planningDateRange(rows []models.Planning) (time.Time, time.Time) {
+	var from, to time.Time
+	for i, p := range rows {
+		if i == 0 || p.Date.Before(from) {
+			from = p.Date
+		}
+		if i == 0 || p.Date.AddDate(0, 0, 1).After(to) {
+			to = p.Date.AddDate(0, 0, 1)
+		}
+	}
+	return from, to
+}
+
+// planningKey identifies the slot a planning row occupies: a CE row per
+// date/shift/CE, or an employee row per date/shift/employee.
+func planningKey(p models.Planning) string {
+	day := p.Date.Format("2006-01-02")
+	if p.EmployeeID != nil {
+		return fmt.Sprintf("%s|%s|employee:%d", day, p.Shift, *p.EmployeeID)
+	}
+	if p.CEID != nil {
+		return fmt.Sprintf("%s|%s|ce:%d", day, p.Shift, *p.CEID)
+	}
+	return fmt.Sprintf("%s|%s|row:%d", day, p.Shift, p.ID)
+}
+
+// isUntouchedPlanning reports whether a row still holds the values it was
+// generated with, i.e. nobody changed its status or assigned a substitute.
+func isUntouchedPlanning(p models.Planning) bool {
+	return p.Status == StatusScheduled && p.SubstituteID == nil && p.SubstituteReservistID == nil
+}
+
+func isGeneratedPlanning(p models.Planning) bool {
+	return p.Source == PlanningSourceRotation || p.Source == PlanningSourceRegime
+}
+
+func sameUint(a, b *uint) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
+// desiredPlanning builds the rows the active rotation pattern and the stored
+// week regimes expect for weeks fromWeek to toWeek of the ISO year. When ceID
+// is not zero only the rows of that CE are returned.
+func (h *Handler) desiredPlanning(tx *gorm.DB, year, fromWeek, toWeek int, ceID uint) ([]models.Planning, error) {
+	query := tx.Preload("Employees")
+	if ceID != 0 {
+		query = query.Where("id = ?", ceID)
+	}
+	var ces []models.CE
+	if err := query.Find(&ces).Error; err != nil {
+		return nil, err
+	}
+
+	pattern, err := activeRotationPattern(tx)
+	if err != nil {
+		return nil, err
+	}
+
+	regimes, err := weekRegimesByWeek(tx, year, fromWeek, toWeek)
+	if err != nil {
+		return nil, err
+	}
+	// A shift definition may have been removed since the regime was set
+	checked := make(map[string]bool)
+	for _, regime := range regimes {
+		if checked[regime] {
+			continue
+		}
+		if err := validateRegimeShifts(tx, regime); err != nil {
+			return nil, err
+		}
+		checked[regime] = true
+	}
+
+	var desired []models.Planning
+	for week := fromWeek; week <= toWeek; week++ {
+		weekStart := isoWeekStart(year, week)
+
+		for _, ce := range ces {
+			ceID := ce.ID
+			for day, shifts := range ceSchedule(pattern, ceID, week) {
+				currentDate := weekStart.AddDate(0, 0, day)
+				for _, shift := range shifts {
+					desired = append(desired, models.Planning{
+						Date:   currentDate,
+						Week:   week,
+						Year:   year,
+						Shift:  shift,
+						CEID:   &ceID,
+						Status: StatusScheduled,
+						Source: PlanningSourceRotation,
+					})
+
+					for _, emp := range ce.Employees {
+						employeeID, sectorID := emp.ID, emp.SectorID
+						desired = append(desired, models.Planning{
+							Date:       currentDate,
+							Week:       week,
+							Year:       year,
+							Shift:      shift,
+							CEID:       &ceID,
+							EmployeeID: &employeeID,
+							SectorID:   &sectorID,
+							Status:     StatusScheduled,
+							Source:     PlanningSourceRotation,
+						})
+					}
+				}
+			}
+
+			// Weekend shifts added by the regime of the week
+			for _, extra := range regimeWeekendShifts(pattern, regimes[week], week) {
+				if extra.CEID != ceID {
+					continue
+				}
+				currentDate := weekStart.AddDate(0, 0, extra.Day)
+				desired = append(desired, models.Planning{
+					Date:   currentDate,
+					Week:   week,
+					Year:   year,
+					Shift:  extra.Shift,
+					CEID:   &ceID,
+					Status: StatusScheduled,
+					Source: PlanningSourceRegime,
+				})
+
+				for _, emp := range ce.Employees {
+					employeeID, sectorID := emp.ID, emp.SectorID
+					desired = append(desired, models.Planning{
+						Date:       currentDate,
+						Week:       week,
+						Year:       year,
+						Shift:      extra.Shift,
+						CEID:       &ceID,
+						EmployeeID: &employeeID,
+						SectorID:   &sectorID,
+						Status:     StatusScheduled,
+						Source:     PlanningSourceRegime,
+					})
+				}
+			}
+		}
+	}
+
+	return desired, nil
+}
+
+// reconcilePlanning compares the rows expected for weeks fromWeek to toWeek
+// with the ones already stored. Missing rows are created, generated rows that
+// no longer match are updated or deleted, and rows edited by hand are kept.
+func (h *Handler) reconcilePlanning(tx *gorm.DB, year, fromWeek, toWeek int) (planningDiff, error) {
+	diff := planningDiff{
+		Created: []models.Planning{},
+		Updated: []planningChange{},
+		Deleted: []models.Planning{},
+	}
+
+	desired, err := h.desiredPlanning(tx, year, fromWeek, toWeek, 0)
+	if err != nil {
+		return diff, err
+	}
+
+	from, _ := isoWeekRange(year, fromWeek)
+	_, to := isoWeekRange(year, toWeek)
+
+	var existing []models.Planning
+	if err := tx.Where("date >= ? AND date < ?", from, to).
+		Order("id ASC").Find(&existing).Error; err != nil {
+		return diff, err
+	}
+
+	existingByKey := make(map[string]models.Planning, len(existing))
+	for _, p := range existing {
+		if _, ok := existingByKey[planningKey(p)]; !ok {
+			existingByKey[planningKey(p)] = p
+		}
+	}
+
+	// Slots given away through a swap must not be handed back
+	swapped, err := swappedPlanningKeys(tx, from, to)
+	if err != nil {
+		return diff, err
+	}
+
+	wanted := make(map[string]bool, len(desired))
+	for _, want := range desired {
+		key := planningKey(want)
+		if wanted[key] || swapped[key] {
+			continue
+		}
+		wanted[key] = true
+
+		current, ok := existingByKey[key]
+		if !ok {
+			diff.Created = append(diff.Created, want)
+			continue
+		}
+
+		if current.EmployeeID != nil && (!sameUint(current.SectorID, want.SectorID) || !sameUint(current.CEID, want.CEID)) &&
+			isGeneratedPlanning(current) && isUntouchedPlanning(current) {
+			after := current
+			after.SectorID, after.CEID = want.SectorID, want.CEID
+			diff.Updated = append(diff.Updated, planningChange{Before: current, After: after})
+		}
+	}
+
+	for _, p := range existing {
+		if isGeneratedPlanning(p) && isUntouchedPlanning(p) && !wanted[planningKey(p)] {
+			diff.Deleted = append(diff.Deleted, p)
+		}
+	}
+
+	return diff, nil
+}
+
+// reconcileYearlyPlanning reconciles every ISO week of the year.
+func (h *Handler) reconcileYearlyPlanning(tx *gorm.DB, year int) (planningDiff, error) {
+	return h.reconcilePlanning(tx, year, 1, isoWeeksInYear(year))
+}
+
+func applyPlanningDiff(tx *gorm.DB, diff *planningDiff) error {
+	if len(diff.Created) > 0 {
+		if err := tx.CreateInBatches(&diff.Created, 100).Error; err != nil {
+			return err
+		}
+	}
+
+	for i := range diff.Updated {
+		if err := tx.Save(&diff.Updated[i].After).Error; err != nil {
+			return err
+		}
+	}
+
+	for i := range diff.Deleted {
+		if err := tx.Delete(&diff.Deleted[i]).Error; err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,133 @@
+package handlers
+
+import (
+	"testing"
+
+	"gorm.io/gorm"
+	"planning_hager/models"
+)
+
+const testYear, testWeek = 2030, 10
+
+// reconcileTestWeek reconciles the test week and applies the diff.
+func reconcileTestWeek(t *testing.T, h *Handler, db *gorm.DB) planningDiff {
+	t.Helper()
+	diff, err := h.reconcilePlanning(db, testYear, testWeek, testWeek)
+	if err != nil {
+		t.Fatalf("reconcilePlanning: %v", err)
+	}
+	if err := applyPlanningDiff(db, &diff); err != nil {
+		t.Fatalf("applyPlanningDiff: %v", err)
+	}
+	return diff
+}
+
+func employeeRows(rows []models.Planning, employeeID uint) []models.Planning {
+	var result []models.Planning
+	for _, p := range rows {
+		if p.EmployeeID != nil && *p.EmployeeID == employeeID {
+			result = append(result, p)
+		}
+	}
+	return result
+}
+
+func TestReconcilePlanning(t *testing.T) {
+	db := newTestDB(t)
+	h := NewHandler(db)
+	employees := seedTeams(t, db)
+	jean, paul := employees[0], employees[1]
+	ceOf := make(map[uint]uint, len(employees))
+	for _, emp := range employees {
+		ceOf[emp.ID] = emp.CEID
+	}
+
+	// An empty week gets every row of the rotation
+	diff := reconcileTestWeek(t, h, db)
+	if len(diff.Created) == 0 || len(diff.Updated) != 0 || len(diff.Deleted) != 0 {
+		t.Fatalf("first reconciliation = %v, want only created rows", diff.summary())
+	}
+	for _, p := range diff.Created {
+		if p.Source != PlanningSourceRotation || p.Status != StatusScheduled {
+			t.Errorf("created row %s has source %q and status %q", planningKey(p), p.Source, p.Status)
+		}
+		if p.EmployeeID != nil && (p.SectorID == nil || *p.SectorID != jean.SectorID) {
+			t.Errorf("created row %s is not in the sector of its employee", planningKey(p))
+		}
+		if p.EmployeeID != nil && (p.CEID == nil || *p.CEID != ceOf[*p.EmployeeID]) {
+			t.Errorf("created row %s is not in the CE of its employee", planningKey(p))
+		}
+	}
+	jeanRows := employeeRows(diff.Created, jean.ID)
+	if len(jeanRows) < 2 {
+		t.Fatalf("Jean has %d rows in the test week, the test needs two", len(jeanRows))
+	}
+
+	// Running it again changes nothing
+	if diff := reconcileTestWeek(t, h, db); len(diff.Created)+len(diff.Updated)+len(diff.Deleted) != 0 {
+		t.Fatalf("second reconciliation = %v, want no change", diff.summary())
+	}
+
+	// A row edited by hand and a row entered by hand are kept
+	edited := jeanRows[0]
+	if err := db.Model(&edited).Update("status", StatusTraining).Error; err != nil {
+		t.Fatalf("editing row: %v", err)
+	}
+	manual := models.Planning{
+		Date: utcDate(2030, 3, 6), Year: testYear, Week: testWeek, Shift: "S",
+		EmployeeID: &paul.ID, SectorID: &paul.SectorID, Status: StatusScheduled,
+	}
+	if err := db.Create(&manual).Error; err != nil {
+		t.Fatalf("creating manual row: %v", err)
+	}
+
+	// Moving the employees to another sector updates their untouched rows
+	sector := models.Sector{Name: "Peinture"}
+	if err := db.Create(&sector).Error; err != nil {
+		t.Fatalf("creating sector: %v", err)
+	}
+	if err := db.Model(&models.Employee{}).Where("1 = 1").Update("sector_id", sector.ID).Error; err != nil {
+		t.Fatalf("moving employees: %v", err)
+	}
+
+	var employeeRowCount int64
+	if err := db.Model(&models.Planning{}).Where("employee_id IS NOT NULL AND source = ?", PlanningSourceRotation).Count(&employeeRowCount).Error; err != nil {
+		t.Fatalf("counting rows: %v", err)
+	}
+	diff = reconcileTestWeek(t, h, db)
+	if len(diff.Created) != 0 || len(diff.Deleted) != 0 || len(diff.Updated) != int(employeeRowCount)-1 {
+		t.Fatalf("reconciliation after the sector change = %v, want %d updated rows", diff.summary(), employeeRowCount-1)
+	}
+	for _, change := range diff.Updated {
+		if change.After.ID == edited.ID {
+			t.Errorf("row edited by hand was updated")
+		}
+		if change.After.SectorID == nil || *change.After.SectorID != sector.ID {
+			t.Errorf("row %s was not moved to the new sector", planningKey(change.After))
+		}
+	}
+
+	// Moving Jean to another CE replaces the rows of the old rotation slot,
+	// except the one edited by hand
+	if err := db.Model(&jean).Update("ce_id", paul.CEID).Error; err != nil {
+		t.Fatalf("moving Jean: %v", err)
+	}
+	diff, err := h.reconcilePlanning(db, testYear, testWeek, testWeek)
+	if err != nil {
+		t.Fatalf("reconcilePlanning: %v", err)
+	}
+	if len(diff.Updated) != 0 {
+		t.Errorf("reconciliation after the CE change updated %d rows", len(diff.Updated))
+	}
+	if len(employeeRows(diff.Created, jean.ID)) != len(diff.Created) || len(diff.Created) == 0 {
+		t.Errorf("reconciliation after the CE change created rows other than the new ones of Jean")
+	}
+	if len(employeeRows(diff.Deleted, jean.ID)) != len(diff.Deleted) || len(diff.Deleted) == 0 {
+		t.Errorf("reconciliation after the CE change deleted rows other than the old ones of Jean")
+	}
+	for _, p := range diff.Deleted {
+		if p.ID == edited.ID || p.ID == manual.ID {
+			t.Errorf("row %s changed by hand was deleted", planningKey(p))
+		}
+	}
+}
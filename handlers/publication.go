@@ -0,0 +1,322 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"planning_hager/models"
+)
+
+// Weeks without a publication record are drafts, only visible to admins.
+const (
+	PublicationDraft     = "draft"
+	PublicationPublished = "published"
+	PublicationLocked    = "locked"
+)
+
+func weekPublication(db *gorm.DB, year, week int) (models.WeekPublication, error) {
+	var publication models.WeekPublication
+	err := db.Where("year = ? AND week = ?", year, week).First(&publication).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return models.WeekPublication{Year: year, Week: week, State: PublicationDraft}, nil
+	}
+	return publication, err
+}
+
+// isWeekVisible reports whether users other than admins may see the week.
+func isWeekVisible(publication models.WeekPublication) bool {
+	return publication.State == PublicationPublished || publication.State == PublicationLocked
+}
+
+// weekHidden reports whether the week is a draft the current user may not
+// see.
+func (h *Handler) weekHidden(c *gin.Context, year, week int) (bool, error) {
+	if c.GetString("role") == "admin" {
+		return false, nil
+	}
+	publication, err := weekPublication(h.DB, year, week)
+	if err != nil {
+		return false, err
+	}
+	return !isWeekVisible(publication), nil
+}
+
+// weekLock is the reason a week's planning is protected: "past", published or
+// locked. PublicationID is zero for past weeks that were never published.
+type weekLock struct {
+	PublicationID uint   `json:"publication_id"`
+	Year          int    `json:"year"`
+	Week          int    `json:"week"`
+	State         string `json:"state"`
+}
+
+func (l weekLock) String() string {
+	return fmt.Sprintf("%d-W%02d (%s)", l.Year, l.Week, l.State)
+}
+
+// weekLocks lists the protected weeks between from and to. Past and published
+// weeks may be changed with an override reason, locked weeks only once
+// unlocked.
+func weekLocks(db *gorm.DB, from, to time.Time) ([]weekLock, error) {
+	var publications []models.WeekPublication
+	if err := db.Where("state IN ?", []string{PublicationPublished, PublicationLocked}).Find(&publications).Error; err != nil {
+		return nil, err
+	}
+	published := make(map[[2]int]models.WeekPublication, len(publications))
+	for _, p := range publications {
+		published[[2]int{p.Year, p.Week}] = p
+	}
+
+	now := time.Now()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+
+	var locks []weekLock
+	year, week := from.ISOWeek()
+	for weekStart := isoWeekStart(year, week); weekStart.Before(to); weekStart = weekStart.AddDate(0, 0, 7) {
+		year, week := weekStart.ISOWeek()
+		publication := published[[2]int{year, week}]
+		state := publication.State
+		if state == "" && !weekStart.AddDate(0, 0, 7).After(today) {
+			state = "past"
+		}
+		if state != "" {
+			locks = append(locks, weekLock{PublicationID: publication.ID, Year: year, Week: week, State: state})
+		}
+	}
+	return locks, nil
+}
+
+// lockedWeeks lists the protected weeks between from and to for display.
+func lockedWeeks(db *gorm.DB, from, to time.Time) ([]string, error) {
+	locks, err := weekLocks(db, from, to)
+	if err != nil {
+		return nil, err
+	}
+	locked := make([]string, len(locks))
+	for i, l := range locks {
+		locked[i] = l.String()
+	}
+	return locked, nil
+}
+
+// PlanningLockedError refuses a change to protected weeks. Frozen is set when
+// some of them are locked, which no override reason opens.
+type PlanningLockedError struct {
+	Weeks  []string
+	Frozen bool
+}
+
+func (e *PlanningLockedError) Error() string {
+	if e.Frozen {
+		return "planning period is locked, unlock it before changing it: " + strings.Join(e.Weeks, ", ")
+	}
+	return "planning period is locked, an override reason is required: " + strings.Join(e.Weeks, ", ")
+}
+
+// unlockPlanning returns a PlanningLockedError when weeks between from and to
+// are locked, or are past or published and no override reason is given.
+// Overrides are recorded in the audit log of tx, one entry per week, and it
+// reports whether the reason overrode a lock.
+func unlockPlanning(tx *gorm.DB, from, to time.Time, username, reason string) (bool, error) {
+	locks, err := weekLocks(tx, from, to)
+	if err != nil {
+		return false, err
+	}
+	if len(locks) == 0 {
+		return false, nil
+	}
+
+	var locked, frozen []string
+	for _, l := range locks {
+		locked = append(locked, l.String())
+		if l.State == PublicationLocked {
+			frozen = append(frozen, l.String())
+		}
+	}
+	if len(frozen) > 0 {
+		return false, &PlanningLockedError{Weeks: frozen, Frozen: true}
+	}
+	if reason == "" {
+		return false, &PlanningLockedError{Weeks: locked}
+	}
+
+	logs := make([]models.AuditLog, len(locks))
+	for i, l := range locks {
+		if logs[i], err = newAuditLogBy(username, reason, AuditOverride, "week_publication", l.PublicationID, nil, l); err != nil {
+			return false, err
+		}
+	}
+	if err := tx.Create(&logs).Error; err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (h *Handler) respondWithLocked(c *gin.Context, err *PlanningLockedError) {
+	message := "Planning period is locked, an override reason is required"
+	if err.Frozen {
+		message = "Planning period is locked, unlock it before changing it"
+	}
+	c.JSON(http.StatusLocked, gin.H{
+		"error":        message,
+		"locked_weeks": err.Weeks,
+	})
+}
+
+// checkPlanningUnlocked refuses changes to planning entries dated between from
+// and to when the period is locked, or is past or published and no override
+// reason is given. The reason of an override is kept for the audit log. It
+// rolls tx back and writes the response when refusing.
+func (h *Handler) checkPlanningUnlocked(c *gin.Context, tx *gorm.DB, from, to time.Time, reason string) bool {
+	overridden, err := unlockPlanning(tx, from, to, c.GetString("username"), reason)
+	var lockedErr *PlanningLockedError
+	if errors.As(err, &lockedErr) {
+		tx.Rollback()
+		h.respondWithLocked(c, lockedErr)
+		return false
+	}
+	if err != nil {
+		tx.Rollback()
+		h.respondWithError(c, http.StatusInternalServerError, "Failed to check planning period")
+		return false
+	}
+
+	if overridden {
+		c.Set("change_reason", reason)
+	}
+	return true
+}
+
+// overrideReason reads the reason given to change a past or published period,
+// from the request body or, for requests without one, the query string.
+func overrideReason(c *gin.Context, fromBody string) string {
+	if fromBody != "" {
+		return fromBody
+	}
+	return c.Query("override_reason")
+}
+
+func (h *Handler) GetWeekPublication(c *gin.Context) {
+	year, week, err := parseISOWeek(c.Query("year"), c.Query("week"))
+	if err != nil {
+		h.respondWithError(c, http.StatusBadRequest, "Invalid year or week parameter")
+		return
+	}
+
+	publication, err := weekPublication(h.DB, year, week)
+	if err != nil {
+		h.respondWithError(c, http.StatusInternalServerError, "Failed to fetch week publication")
+		return
+	}
+
+	h.respondWithSuccess(c, http.StatusOK, publication)
+}
+
+func (h *Handler) GetWeekPublications(c *gin.Context) {
+	year, _ := time.Now().ISOWeek()
+	if yearStr := c.Query("year"); yearStr != "" {
+		var err error
+		if year, err = strconv.Atoi(yearStr); err != nil {
+			h.respondWithError(c, http.StatusBadRequest, "Invalid year parameter")
+			return
+		}
+	}
+
+	var publications []models.WeekPublication
+	if err := h.DB.Where("year = ?", year).Order("week ASC").Find(&publications).Error; err != nil {
+		h.respondWithError(c, http.StatusInternalServerError, "Failed to fetch week publications")
+		return
+	}
+
+	h.respondWithSuccess(c, http.StatusOK, publications)
+}
+
+// setWeekPublication moves a week to the given state, provided it currently is
+// in one of the allowed states.
+func (h *Handler) setWeekPublication(c *gin.Context, state string, allowed ...string) {
+	var input struct {
+		Year int `json:"year" binding:"required"`
+		Week int `json:"week" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&input); err != nil {
+		h.respondWithError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if !isValidISOWeek(input.Year, input.Week) {
+		h.respondWithError(c, http.StatusBadRequest, "Invalid week parameter")
+		return
+	}
+
+	publication, err := weekPublication(h.DB, input.Year, input.Week)
+	if err != nil {
+		h.respondWithError(c, http.StatusInternalServerError, "Failed to fetch week publication")
+		return
+	}
+
+	if !containsString(allowed, publication.State) {
+		h.respondWithError(c, http.StatusConflict, fmt.Sprintf("Week is %s", publication.State))
+		return
+	}
+
+	before := publication
+	now := time.Now()
+	username := c.GetString("username")
+	switch state {
+	case PublicationPublished:
+		if publication.PublishedAt == nil {
+			publication.PublishedBy = username
+			publication.PublishedAt = &now
+		}
+		publication.LockedBy = ""
+		publication.LockedAt = nil
+	case PublicationLocked:
+		publication.LockedBy = username
+		publication.LockedAt = &now
+	case PublicationDraft:
+		publication.PublishedBy = ""
+		publication.PublishedAt = nil
+	}
+	publication.State = state
+
+	if err := h.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(&publication).Error; err != nil {
+			return err
+		}
+		if before.ID == 0 {
+			return h.audit(c, tx, AuditCreate, "week_publication", publication.ID, nil, publication)
+		}
+		return h.audit(c, tx, AuditUpdate, "week_publication", publication.ID, before, publication)
+	}); err != nil {
+		h.respondWithError(c, http.StatusInternalServerError, "Failed to update week publication")
+		return
+	}
+
+	h.respondWithSuccess(c, http.StatusOK, publication)
+}
+
+func (h *Handler) PublishWeek(c *gin.Context) {
+	h.setWeekPublication(c, PublicationPublished, PublicationDraft)
+}
+
+func (h *Handler) UnpublishWeek(c *gin.Context) {
+	h.setWeekPublication(c, PublicationDraft, PublicationPublished)
+}
+
+// LockWeek freezes a published week: its planning can no longer be changed,
+// even with an override reason, until it is unlocked.
+func (h *Handler) LockWeek(c *gin.Context) {
+	h.setWeekPublication(c, PublicationLocked, PublicationPublished)
+}
+
+// UnlockWeek reopens a locked week; it stays published.
+func (h *Handler) UnlockWeek(c *gin.Context) {
+	h.setWeekPublication(c, PublicationPublished, PublicationLocked)
+}
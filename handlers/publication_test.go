@@ -0,0 +1,225 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"planning_hager/models"
+)
+
+func setPublication(t *testing.T, db *gorm.DB, year, week int, state string) {
+	t.Helper()
+	if err := db.Create(&models.WeekPublication{Year: year, Week: week, State: state}).Error; err != nil {
+		t.Fatalf("setting publication: %v", err)
+	}
+}
+
+func TestLockedWeeks(t *testing.T) {
+	db := newTestDB(t)
+	setPublication(t, db, 2030, 11, PublicationPublished)
+	setPublication(t, db, 2030, 12, PublicationLocked)
+	setPublication(t, db, 2030, 13, PublicationDraft)
+
+	tests := []struct {
+		name       string
+		year, week int
+		weeks      int
+		want       []string
+	}{
+		{"past week", 2020, 10, 1, []string{"2020-W10 (past)"}},
+		{"draft week", 2030, 10, 1, []string{}},
+		{"published and locked weeks", 2030, 10, 4, []string{"2030-W11 (published)", "2030-W12 (locked)"}},
+	}
+	for _, tt := range tests {
+		from, _ := isoWeekRange(tt.year, tt.week)
+		_, to := isoWeekRange(tt.year, tt.week+tt.weeks-1)
+		got, err := lockedWeeks(db, from, to)
+		if err != nil {
+			t.Fatalf("%s: lockedWeeks: %v", tt.name, err)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: lockedWeeks = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestUnlockPlanning(t *testing.T) {
+	db := newTestDB(t)
+	setPublication(t, db, 2030, 11, PublicationPublished)
+	setPublication(t, db, 2030, 12, PublicationLocked)
+	from, to := isoWeekRange(2030, 11)
+
+	_, err := unlockPlanning(db, from, to, "tester", "")
+	var lockedErr *PlanningLockedError
+	if !errors.As(err, &lockedErr) || lockedErr.Frozen || !reflect.DeepEqual(lockedErr.Weeks, []string{"2030-W11 (published)"}) {
+		t.Errorf("unlockPlanning without a reason = %v, want the published week refused", err)
+	}
+
+	if overridden, err := unlockPlanning(db, from, to, "tester", "Late absence"); err != nil || !overridden {
+		t.Errorf("unlockPlanning with a reason = %v, %v, want the publication overridden", overridden, err)
+	}
+	var overrides []models.AuditLog
+	if err := db.Where("action = ?", AuditOverride).Find(&overrides).Error; err != nil {
+		t.Fatalf("reading audit log: %v", err)
+	}
+	if len(overrides) != 1 || overrides[0].Entity != "week_publication" || overrides[0].Username != "tester" || overrides[0].Reason != "Late absence" {
+		t.Errorf("override audit log = %+v, want the published week overridden by tester", overrides)
+	}
+
+	// A locked week stays closed whatever the reason
+	_, to = isoWeekRange(2030, 12)
+	_, err = unlockPlanning(db, from, to, "tester", "Late absence")
+	if !errors.As(err, &lockedErr) || !lockedErr.Frozen || !reflect.DeepEqual(lockedErr.Weeks, []string{"2030-W12 (locked)"}) {
+		t.Errorf("unlockPlanning of a locked week = %v, want it refused", err)
+	}
+
+	from, to = isoWeekRange(2030, 10)
+	if overridden, err := unlockPlanning(db, from, to, "tester", ""); err != nil || overridden {
+		t.Errorf("unlockPlanning of a draft week = %v, %v, want nothing to override", overridden, err)
+	}
+}
+
+func TestPopulateYearRespectsLocks(t *testing.T) {
+	db := newTestDB(t)
+	seedTeams(t, db)
+	setPublication(t, db, 2030, 10, PublicationPublished)
+	setPublication(t, db, 2030, 11, PublicationLocked)
+
+	_, err := PopulateYear(db, 2030, false, "tester", "Rotation change")
+	var lockedErr *PlanningLockedError
+	if !errors.As(err, &lockedErr) || !lockedErr.Frozen || !reflect.DeepEqual(lockedErr.Weeks, []string{"2030-W11 (locked)"}) {
+		t.Fatalf("PopulateYear over a locked week = %v, want it refused", err)
+	}
+
+	// A dry run lists the protected weeks instead
+	result, err := PopulateYear(db, 2030, true, "tester", "")
+	if err != nil {
+		t.Fatalf("PopulateYear dry run: %v", err)
+	}
+	if !reflect.DeepEqual(result.LockedWeeks, []string{"2030-W10 (published)", "2030-W11 (locked)"}) || result.Summary["created"] == 0 {
+		t.Errorf("PopulateYear dry run = %+v, want rows created and the protected weeks listed", result)
+	}
+	if n := countRows(t, db, &models.Planning{}); n != 0 {
+		t.Fatalf("PopulateYear dry run saved %d rows", n)
+	}
+
+	if err := db.Model(&models.WeekPublication{}).Where("week = ?", 11).Update("state", PublicationPublished).Error; err != nil {
+		t.Fatalf("unlocking week: %v", err)
+	}
+	_, err = PopulateYear(db, 2030, false, "tester", "")
+	if !errors.As(err, &lockedErr) || lockedErr.Frozen || len(lockedErr.Weeks) != 2 {
+		t.Fatalf("PopulateYear without a reason = %v, want the published weeks refused", err)
+	}
+	if n := countRows(t, db, &models.Planning{}); n != 0 {
+		t.Fatalf("refused PopulateYear saved %d rows", n)
+	}
+
+	// The reason overrides the publication and is kept in the audit log
+	result, err = PopulateYear(db, 2030, false, "tester", "Rotation change")
+	if err != nil {
+		t.Fatalf("PopulateYear with a reason: %v", err)
+	}
+	if n := countRows(t, db, &models.Planning{}); n != int64(result.Summary["created"]) || n == 0 {
+		t.Errorf("PopulateYear saved %d rows, summary %v", n, result.Summary)
+	}
+	var logs []models.AuditLog
+	if err := db.Where("entity = ?", "planning").Find(&logs).Error; err != nil {
+		t.Fatalf("reading audit log: %v", err)
+	}
+	if len(logs) != result.Summary["created"] {
+		t.Errorf("audit log has %d planning entries, want %d", len(logs), result.Summary["created"])
+	}
+	for _, log := range logs {
+		if log.Username != "tester" || log.Reason != "Rotation change" {
+			t.Fatalf("audit log entry by %q for %q, want tester for the override reason", log.Username, log.Reason)
+		}
+	}
+	var changeSet models.ChangeSet
+	if err := db.First(&changeSet, result.ChangeSetID).Error; err != nil || changeSet.CreatedBy != "tester" {
+		t.Errorf("change set %d = %+v, %v, want one created by tester", result.ChangeSetID, changeSet, err)
+	}
+}
+
+// callAsAdmin runs a handler on a JSON body as the admin user.
+func callAsAdmin(handler gin.HandlerFunc, body gin.H) *httptest.ResponseRecorder {
+	gin.SetMode(gin.TestMode)
+	payload, _ := json.Marshal(body)
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+	c.Request = httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(payload))
+	c.Request.Header.Set("Content-Type", "application/json")
+	c.Set("username", "admin")
+	c.Set("role", RoleAdmin)
+	handler(c)
+	return recorder
+}
+
+func TestPopulateYearlyPlanningLocked(t *testing.T) {
+	db := newTestDB(t)
+	seedTeams(t, db)
+	setPublication(t, db, 2030, 10, PublicationPublished)
+	h := NewHandler(db)
+
+	recorder := callAsAdmin(h.PopulateYearlyPlanning, gin.H{"year": 2030})
+	if recorder.Code != http.StatusLocked {
+		t.Fatalf("populating a published year returned %d, want %d", recorder.Code, http.StatusLocked)
+	}
+	var response struct {
+		LockedWeeks []string `json:"locked_weeks"`
+	}
+	if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil || !reflect.DeepEqual(response.LockedWeeks, []string{"2030-W10 (published)"}) {
+		t.Errorf("locked response = %s, want the published week listed", recorder.Body.String())
+	}
+
+	if recorder := callAsAdmin(h.PopulateYearlyPlanning, gin.H{"year": 2030, "override_reason": "Rotation change"}); recorder.Code != http.StatusCreated {
+		t.Errorf("populating with an override reason returned %d: %s", recorder.Code, recorder.Body.String())
+	}
+}
+
+func TestWeekLockLifecycle(t *testing.T) {
+	db := newTestDB(t)
+	h := NewHandler(db)
+	week := gin.H{"year": 2030, "week": 10}
+
+	// Locking a draft would publish it without anyone publishing it
+	if recorder := callAsAdmin(h.LockWeek, week); recorder.Code != http.StatusConflict {
+		t.Errorf("locking a draft returned %d, want %d", recorder.Code, http.StatusConflict)
+	}
+
+	for _, step := range []struct {
+		name    string
+		handler gin.HandlerFunc
+		state   string
+	}{
+		{"publish", h.PublishWeek, PublicationPublished},
+		{"lock", h.LockWeek, PublicationLocked},
+		{"unlock", h.UnlockWeek, PublicationPublished},
+	} {
+		if recorder := callAsAdmin(step.handler, week); recorder.Code != http.StatusOK {
+			t.Fatalf("%s returned %d: %s", step.name, recorder.Code, recorder.Body.String())
+		}
+		publication, err := weekPublication(db, 2030, 10)
+		if err != nil {
+			t.Fatalf("reading publication: %v", err)
+		}
+		if publication.State != step.state || publication.PublishedAt == nil || publication.PublishedBy != "admin" {
+			t.Errorf("after %s the week is %s published by %q at %v, want %s and published", step.name,
+				publication.State, publication.PublishedBy, publication.PublishedAt, step.state)
+		}
+	}
+
+	var logs []models.AuditLog
+	if err := db.Where("entity = ?", "week_publication").Order("id").Find(&logs).Error; err != nil {
+		t.Fatalf("reading audit log: %v", err)
+	}
+	if len(logs) != 3 || logs[0].Action != AuditCreate || logs[2].Action != AuditUpdate || logs[2].Username != "admin" {
+		t.Errorf("audit log has %+v, want the publication created then updated twice by admin", logs)
+	}
+}
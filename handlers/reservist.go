@@ -3,13 +3,16 @@ package handlers
 import (
 	"fmt"
 	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
 	"net/http"
 	"planning_hager/models"
+	"strconv"
+	"time"
 )
 
 func (h *Handler) GetReservists(c *gin.Context) {
 	var reservists []models.Reservist
-	if err := h.DB.Preload("Skills").Find(&reservists).Error; err != nil {
+	if err := h.DB.Preload("Skills").Preload("Availabilities").Find(&reservists).Error; err != nil {
 		h.respondWithError(c, http.StatusInternalServerError, "Failed to fetch reservists")
 		return
 	}
@@ -32,19 +35,24 @@ func (h *Handler) AddReservist(c *gin.Context) {
 
 	reservist := models.Reservist{Name: input.Name}
 
-	if err := h.DB.Create(&reservist).Error; err != nil {
-		h.respondWithError(c, http.StatusInternalServerError, "Failed to create reservist")
-		return
-	}
+	err := h.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&reservist).Error; err != nil {
+			return err
+		}
 
-	var skills []models.Skill
-	if err := h.DB.Where("id IN ?", input.Skills).Find(&skills).Error; err != nil {
-		h.respondWithError(c, http.StatusInternalServerError, "Failed to fetch skills")
-		return
-	}
+		var skills []models.Skill
+		if err := tx.Where("id IN ?", input.Skills).Find(&skills).Error; err != nil {
+			return err
+		}
 
-	if err := h.DB.Model(&reservist).Association("Skills").Append(skills); err != nil {
-		h.respondWithError(c, http.StatusInternalServerError, "Failed to associate skills")
+		if err := tx.Model(&reservist).Association("Skills").Append(skills); err != nil {
+			return err
+		}
+
+		return h.audit(c, tx, AuditCreate, "reservist", reservist.ID, nil, reservist)
+	})
+	if err != nil {
+		h.respondWithError(c, http.StatusInternalServerError, "Failed to create reservist")
 		return
 	}
 
@@ -69,25 +77,30 @@ func (h *Handler) UpdateReservist(c *gin.Context) {
 		return
 	}
 
+	before := reservist
 	reservist.Name = input.Name
 
-	if err := h.DB.Model(&reservist).Association("Skills").Clear(); err != nil {
-		h.respondWithError(c, http.StatusInternalServerError, "Failed to clear existing skills")
-		return
-	}
+	err := h.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&reservist).Association("Skills").Clear(); err != nil {
+			return err
+		}
 
-	var skills []models.Skill
-	if err := h.DB.Where("id IN ?", input.SkillIDs).Find(&skills).Error; err != nil {
-		h.respondWithError(c, http.StatusInternalServerError, "Failed to fetch skills")
-		return
-	}
+		var skills []models.Skill
+		if err := tx.Where("id IN ?", input.SkillIDs).Find(&skills).Error; err != nil {
+			return err
+		}
 
-	if err := h.DB.Model(&reservist).Association("Skills").Append(skills); err != nil {
-		h.respondWithError(c, http.StatusInternalServerError, "Failed to associate skills")
-		return
-	}
+		if err := tx.Model(&reservist).Association("Skills").Append(skills); err != nil {
+			return err
+		}
+
+		if err := tx.Save(&reservist).Error; err != nil {
+			return err
+		}
 
-	if err := h.DB.Save(&reservist).Error; err != nil {
+		return h.audit(c, tx, AuditUpdate, "reservist", reservist.ID, before, reservist)
+	})
+	if err != nil {
 		h.respondWithError(c, http.StatusInternalServerError, "Failed to update reservist")
 		return
 	}
@@ -98,10 +111,73 @@ func (h *Handler) UpdateReservist(c *gin.Context) {
 func (h *Handler) DeleteReservist(c *gin.Context) {
 	id := c.Param("id")
 
-	if err := h.DB.Delete(&models.Reservist{}, id).Error; err != nil {
+	var reservist models.Reservist
+	if err := h.DB.Preload("Skills").First(&reservist, id).Error; err != nil {
+		h.respondWithError(c, http.StatusNotFound, "Reservist not found")
+		return
+	}
+
+	err := h.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Delete(&models.Reservist{}, reservist.ID).Error; err != nil {
+			return err
+		}
+		return h.audit(c, tx, AuditDelete, "reservist", reservist.ID, reservist, nil)
+	})
+	if err != nil {
 		h.respondWithError(c, http.StatusInternalServerError, "Failed to delete reservist")
 		return
 	}
 
 	h.respondWithSuccess(c, http.StatusOK, gin.H{"message": "Reservist deleted successfully"})
 }
+
+// GetReservistUsage counts, per reservist and month, the shifts worked as a
+// substitute during the given year.
+func (h *Handler) GetReservistUsage(c *gin.Context) {
+	year := time.Now().Year()
+	if yearStr := c.Query("year"); yearStr != "" {
+		var err error
+		if year, err = strconv.Atoi(yearStr); err != nil {
+			h.respondWithError(c, http.StatusBadRequest, "Invalid year parameter")
+			return
+		}
+	}
+
+	var reservists []models.Reservist
+	if err := h.DB.Find(&reservists).Error; err != nil {
+		h.respondWithError(c, http.StatusInternalServerError, "Failed to fetch reservists")
+		return
+	}
+
+	from := time.Date(year, 1, 1, 0, 0, 0, 0, time.UTC)
+	var plannings []models.Planning
+	if err := h.DB.Where("substitute_reservist_id IS NOT NULL AND date >= ? AND date < ?", from, from.AddDate(1, 0, 0)).
+		Find(&plannings).Error; err != nil {
+		h.respondWithError(c, http.StatusInternalServerError, "Failed to fetch planning data")
+		return
+	}
+
+	months := make(map[uint][12]int)
+	for _, p := range plannings {
+		counts := months[*p.SubstituteReservistID]
+		counts[p.Date.Month()-1]++
+		months[*p.SubstituteReservistID] = counts
+	}
+
+	response := make([]gin.H, len(reservists))
+	for i, reservist := range reservists {
+		counts := months[reservist.ID]
+		total := 0
+		for _, n := range counts {
+			total += n
+		}
+		response[i] = gin.H{
+			"reservist_id": reservist.ID,
+			"name":         reservist.Name,
+			"months":       counts,
+			"total":        total,
+		}
+	}
+
+	h.respondWithSuccess(c, http.StatusOK, gin.H{"year": year, "usage": response})
+}
@@ -0,0 +1,187 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"planning_hager/models"
+)
+
+// reservistAvailableFor reports whether one of the declared availabilities
+// covers the date and shift, given the number of shifts the reservist already
+// works that week.
+func reservistAvailableFor(availabilities []models.ReservistAvailability, date time.Time, shift string, shiftsThisWeek int) (bool, string) {
+	day := date.Format("2006-01-02")
+	for _, a := range availabilities {
+		if day < a.StartDate.Format("2006-01-02") || day > a.EndDate.Format("2006-01-02") {
+			continue
+		}
+		if len(a.Shifts) > 0 && !containsString(a.Shifts, shift) {
+			continue
+		}
+		if a.MaxShiftsPerWeek > 0 && shiftsThisWeek >= a.MaxShiftsPerWeek {
+			continue
+		}
+
+		reason := fmt.Sprintf("Available from %s to %s", a.StartDate.Format("2006-01-02"), a.EndDate.Format("2006-01-02"))
+		if a.MaxShiftsPerWeek > 0 {
+			reason += fmt.Sprintf(", %d of %d shifts used this week", shiftsThisWeek, a.MaxShiftsPerWeek)
+		}
+		return true, reason
+	}
+	return false, ""
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+type reservistAvailabilityInput struct {
+	ReservistID      uint     `json:"reservist_id" binding:"required"`
+	StartDate        string   `json:"start_date" binding:"required"`
+	EndDate          string   `json:"end_date" binding:"required"`
+	Shifts           []string `json:"shifts"`
+	MaxShiftsPerWeek int      `json:"max_shifts_per_week"`
+}
+
+func (h *Handler) parseAvailabilityInput(input reservistAvailabilityInput, availability *models.ReservistAvailability) error {
+	startDate, err := time.Parse("2006-01-02", input.StartDate)
+	if err != nil {
+		return errors.New("Invalid start date format")
+	}
+	endDate, err := time.Parse("2006-01-02", input.EndDate)
+	if err != nil {
+		return errors.New("Invalid end date format")
+	}
+	if endDate.Before(startDate) {
+		return errors.New("End date must not be before start date")
+	}
+	if input.MaxShiftsPerWeek < 0 {
+		return errors.New("Max shifts per week must not be negative")
+	}
+	for _, shift := range input.Shifts {
+		if err := validateShiftCode(h.DB, shift); err != nil {
+			return fmt.Errorf("Invalid shift %q", shift)
+		}
+	}
+
+	availability.ReservistID = input.ReservistID
+	availability.StartDate = startDate
+	availability.EndDate = endDate
+	availability.Shifts = input.Shifts
+	availability.MaxShiftsPerWeek = input.MaxShiftsPerWeek
+	return nil
+}
+
+func (h *Handler) GetReservistAvailability(c *gin.Context) {
+	id := c.Param("id")
+
+	var availabilities []models.ReservistAvailability
+	if err := h.DB.Where("reservist_id = ?", id).Order("start_date ASC").Find(&availabilities).Error; err != nil {
+		h.respondWithError(c, http.StatusInternalServerError, "Failed to fetch reservist availability")
+		return
+	}
+
+	h.respondWithSuccess(c, http.StatusOK, availabilities)
+}
+
+func (h *Handler) AddReservistAvailability(c *gin.Context) {
+	var input reservistAvailabilityInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		h.respondWithError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	var reservist models.Reservist
+	if err := h.DB.First(&reservist, input.ReservistID).Error; err != nil {
+		h.respondWithError(c, http.StatusNotFound, "Reservist not found")
+		return
+	}
+
+	var availability models.ReservistAvailability
+	if err := h.parseAvailabilityInput(input, &availability); err != nil {
+		h.respondWithError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if err := h.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&availability).Error; err != nil {
+			return err
+		}
+		return h.audit(c, tx, AuditCreate, "reservist_availability", availability.ID, nil, availability)
+	}); err != nil {
+		h.respondWithError(c, http.StatusInternalServerError, "Failed to create reservist availability")
+		return
+	}
+
+	h.respondWithSuccess(c, http.StatusCreated, availability)
+}
+
+func (h *Handler) UpdateReservistAvailability(c *gin.Context) {
+	id := c.Param("id")
+	var input reservistAvailabilityInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		h.respondWithError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	var availability models.ReservistAvailability
+	if err := h.DB.First(&availability, id).Error; err != nil {
+		h.respondWithError(c, http.StatusNotFound, "Reservist availability not found")
+		return
+	}
+
+	if input.ReservistID != availability.ReservistID {
+		h.respondWithError(c, http.StatusBadRequest, "Availability belongs to another reservist")
+		return
+	}
+
+	before := availability
+	if err := h.parseAvailabilityInput(input, &availability); err != nil {
+		h.respondWithError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if err := h.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(&availability).Error; err != nil {
+			return err
+		}
+		return h.audit(c, tx, AuditUpdate, "reservist_availability", availability.ID, before, availability)
+	}); err != nil {
+		h.respondWithError(c, http.StatusInternalServerError, "Failed to update reservist availability")
+		return
+	}
+
+	h.respondWithSuccess(c, http.StatusOK, availability)
+}
+
+func (h *Handler) DeleteReservistAvailability(c *gin.Context) {
+	id := c.Param("id")
+
+	var availability models.ReservistAvailability
+	if err := h.DB.First(&availability, id).Error; err != nil {
+		h.respondWithError(c, http.StatusNotFound, "Reservist availability not found")
+		return
+	}
+
+	if err := h.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Delete(&availability).Error; err != nil {
+			return err
+		}
+		return h.audit(c, tx, AuditDelete, "reservist_availability", availability.ID, availability, nil)
+	}); err != nil {
+		h.respondWithError(c, http.StatusInternalServerError, "Failed to delete reservist availability")
+		return
+	}
+
+	h.respondWithSuccess(c, http.StatusOK, gin.H{"message": "Reservist availability deleted successfully"})
+}
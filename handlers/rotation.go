@@ -0,0 +1,281 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"planning_hager/models"
+)
+
+// defaultRotationPattern reproduces the historical 4-week, 4-CE rhythm. It is
+// used whenever no rotation pattern has been marked active.
+func defaultRotationPattern() models.RotationPattern {
+	return models.RotationPattern{
+		Name:        "Default 4-week rotation",
+		CycleLength: 4,
+		Weeks: [][]string{
+			{"", "M", "M", "M", "M", "M", ""}, // Week 1
+			{"S", "S", "S", "S", "", "", "N"}, // Week 2
+			{"N", "N", "", "", "S", "", ""},   // Week 3
+			{"M", "", "N", "N", "N", "", ""},  // Week 4
+		},
+		CESlots:       []uint{1, 2, 3, 4},
+		SaturdaySlots: []int{0, 3, 2, 1},
+		SundaySlots:   []int{1, 0, 3, 2},
+		Active:        true,
+	}
+}
+
+// activeRotationPattern returns the pattern the planning follows, the default
+// one while none is active.
+func activeRotationPattern(db *gorm.DB) (models.RotationPattern, error) {
+	var pattern models.RotationPattern
+	err := db.Where("active = ?", true).Order("id ASC").First(&pattern).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return defaultRotationPattern(), nil
+	}
+	return pattern, err
+}
+
+func validateRotationPattern(pattern models.RotationPattern, shifts map[string]models.ShiftDefinition) error {
+	if pattern.CycleLength < 1 {
+		return errors.New("cycle_length must be at least 1")
+	}
+	if len(pattern.Weeks) != pattern.CycleLength {
+		return fmt.Errorf("weeks must contain %d entries", pattern.CycleLength)
+	}
+	for i, days := range pattern.Weeks {
+		if len(days) != 7 {
+			return fmt.Errorf("week %d must contain 7 days", i+1)
+		}
+		for _, code := range days {
+			if _, ok := shifts[code]; code != "" && !ok {
+				return fmt.Errorf("week %d uses unknown shift %q", i+1, code)
+			}
+		}
+	}
+	if len(pattern.CESlots) == 0 {
+		return errors.New("ce_slots must not be empty")
+	}
+	for name, slots := range map[string][]int{"saturday_slots": pattern.SaturdaySlots, "sunday_slots": pattern.SundaySlots} {
+		if len(slots) == 0 {
+			continue
+		}
+		if len(slots) != pattern.CycleLength {
+			return fmt.Errorf("%s must contain %d entries", name, pattern.CycleLength)
+		}
+		for _, slot := range slots {
+			if slot < -1 || slot >= len(pattern.CESlots) {
+				return fmt.Errorf("%s references unknown CE slot %d", name, slot)
+			}
+		}
+	}
+	return nil
+}
+
+// ceSchedule returns the shifts worked by a CE for each day (0 = Monday) of
+// the given week, according to the rotation pattern.
+func ceSchedule(pattern models.RotationPattern, ceID uint, week int) map[int][]string {
+	schedule := make(map[int][]string)
+
+	slot := -1
+	for i, id := range pattern.CESlots {
+		if id == ceID {
+			slot = i
+			break
+		}
+	}
+	if slot < 0 || pattern.CycleLength < 1 {
+		return schedule
+	}
+
+	weekInCycle := (week - 1) % pattern.CycleLength
+	currentPattern := pattern.Weeks[(slot+weekInCycle)%pattern.CycleLength]
+
+	for day := 0; day < 7 && day < len(currentPattern); day++ {
+		if currentPattern[day] != "" {
+			schedule[day] = append(schedule[day], currentPattern[day])
+		}
+	}
+
+	return schedule
+}
+
+// weekendCEs returns the CEs covering the Saturday and Sunday extra shifts of
+// the given week. A zero ID means nobody is assigned.
+func weekendCEs(pattern models.RotationPattern, week int) (saturdayCEID, sundayCEID uint) {
+	if pattern.CycleLength < 1 {
+		return 0, 0
+	}
+	weekInCycle := (week - 1) % pattern.CycleLength
+
+	slotCE := func(slots []int) uint {
+		if weekInCycle >= len(slots) {
+			return 0
+		}
+		slot := slots[weekInCycle]
+		if slot < 0 || slot >= len(pattern.CESlots) {
+			return 0
+		}
+		return pattern.CESlots[slot]
+	}
+
+	return slotCE(pattern.SaturdaySlots), slotCE(pattern.SundaySlots)
+}
+
+func (h *Handler) GetRotationPatterns(c *gin.Context) {
+	var patterns []models.RotationPattern
+	if err := h.DB.Order("id ASC").Find(&patterns).Error; err != nil {
+		h.respondWithError(c, http.StatusInternalServerError, "Failed to fetch rotation patterns")
+		return
+	}
+	h.respondWithSuccess(c, http.StatusOK, patterns)
+}
+
+type rotationPatternInput struct {
+	Name          string     `json:"name" binding:"required"`
+	CycleLength   int        `json:"cycle_length" binding:"required"`
+	Weeks         [][]string `json:"weeks" binding:"required"`
+	CESlots       []uint     `json:"ce_slots" binding:"required"`
+	SaturdaySlots []int      `json:"saturday_slots"`
+	SundaySlots   []int      `json:"sunday_slots"`
+	Active        bool       `json:"active"`
+}
+
+func (input rotationPatternInput) apply(pattern *models.RotationPattern) {
+	pattern.Name = input.Name
+	pattern.CycleLength = input.CycleLength
+	pattern.Weeks = input.Weeks
+	pattern.CESlots = input.CESlots
+	pattern.SaturdaySlots = input.SaturdaySlots
+	pattern.SundaySlots = input.SundaySlots
+	pattern.Active = input.Active
+}
+
+// saveRotationPattern persists the pattern, making sure at most one pattern
+// is active at a time, and records the changes in the audit log. before is nil
+// for a new pattern.
+func (h *Handler) saveRotationPattern(c *gin.Context, tx *gorm.DB, pattern, before *models.RotationPattern) error {
+	if pattern.Active {
+		var others []models.RotationPattern
+		if err := tx.Where("active = ? AND id <> ?", true, pattern.ID).Find(&others).Error; err != nil {
+			return err
+		}
+		for _, other := range others {
+			deactivated := other
+			deactivated.Active = false
+			if err := tx.Model(&deactivated).Update("active", false).Error; err != nil {
+				return err
+			}
+			if err := h.audit(c, tx, AuditUpdate, "rotation_pattern", other.ID, other, deactivated); err != nil {
+				return err
+			}
+		}
+	}
+	if err := tx.Save(pattern).Error; err != nil {
+		return err
+	}
+	if before == nil {
+		return h.audit(c, tx, AuditCreate, "rotation_pattern", pattern.ID, nil, pattern)
+	}
+	return h.audit(c, tx, AuditUpdate, "rotation_pattern", pattern.ID, before, pattern)
+}
+
+func (h *Handler) AddRotationPattern(c *gin.Context) {
+	var input rotationPatternInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		h.respondWithError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	var pattern models.RotationPattern
+	input.apply(&pattern)
+
+	shifts, err := loadShiftDefinitions(h.DB)
+	if err != nil {
+		h.respondWithError(c, http.StatusInternalServerError, "Failed to fetch shift definitions")
+		return
+	}
+
+	if err := validateRotationPattern(pattern, shifts); err != nil {
+		h.respondWithError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if err := h.DB.Transaction(func(tx *gorm.DB) error {
+		return h.saveRotationPattern(c, tx, &pattern, nil)
+	}); err != nil {
+		h.respondWithError(c, http.StatusInternalServerError, "Failed to create rotation pattern")
+		return
+	}
+
+	h.respondWithSuccess(c, http.StatusCreated, pattern)
+}
+
+func (h *Handler) UpdateRotationPattern(c *gin.Context) {
+	id := c.Param("id")
+	var input rotationPatternInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		h.respondWithError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	var pattern models.RotationPattern
+	if err := h.DB.First(&pattern, id).Error; err != nil {
+		h.respondWithError(c, http.StatusNotFound, "Rotation pattern not found")
+		return
+	}
+
+	before := pattern
+	input.apply(&pattern)
+
+	shifts, err := loadShiftDefinitions(h.DB)
+	if err != nil {
+		h.respondWithError(c, http.StatusInternalServerError, "Failed to fetch shift definitions")
+		return
+	}
+
+	if err := validateRotationPattern(pattern, shifts); err != nil {
+		h.respondWithError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if err := h.DB.Transaction(func(tx *gorm.DB) error {
+		return h.saveRotationPattern(c, tx, &pattern, &before)
+	}); err != nil {
+		h.respondWithError(c, http.StatusInternalServerError, "Failed to update rotation pattern")
+		return
+	}
+
+	h.respondWithSuccess(c, http.StatusOK, pattern)
+}
+
+func (h *Handler) DeleteRotationPattern(c *gin.Context) {
+	id := c.Param("id")
+
+	var pattern models.RotationPattern
+	if err := h.DB.First(&pattern, id).Error; err != nil {
+		h.respondWithError(c, http.StatusNotFound, "Rotation pattern not found")
+		return
+	}
+	// The planning would silently fall back to another pattern
+	if pattern.Active {
+		h.respondWithError(c, http.StatusConflict, "Activate another rotation pattern before deleting the active one")
+		return
+	}
+
+	if err := h.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Delete(&pattern).Error; err != nil {
+			return err
+		}
+		return h.audit(c, tx, AuditDelete, "rotation_pattern", pattern.ID, pattern, nil)
+	}); err != nil {
+		h.respondWithError(c, http.StatusInternalServerError, "Failed to delete rotation pattern")
+		return
+	}
+
+	h.respondWithSuccess(c, http.StatusOK, gin.H{"message": "Rotation pattern deleted successfully"})
+}
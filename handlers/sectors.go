@@ -30,21 +30,26 @@ func (h *Handler) AddSector(c *gin.Context) {
 
 	sector := models.Sector{Name: input.Name}
 
-	if err := h.DB.Create(&sector).Error; err != nil {
-		h.respondWithError(c, http.StatusInternalServerError, "Failed to create sector")
-		return
-	}
-
-	if len(input.RequiredSkills) > 0 {
-		var skills []models.Skill
-		if err := h.DB.Where("id IN ?", input.RequiredSkills).Find(&skills).Error; err != nil {
-			h.respondWithError(c, http.StatusInternalServerError, "Failed to fetch skills")
-			return
+	err := h.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&sector).Error; err != nil {
+			return err
 		}
-		if err := h.DB.Model(&sector).Association("RequiredSkills").Append(skills); err != nil {
-			h.respondWithError(c, http.StatusInternalServerError, "Failed to associate skills")
-			return
+
+		if len(input.RequiredSkills) > 0 {
+			var skills []models.Skill
+			if err := tx.Where("id IN ?", input.RequiredSkills).Find(&skills).Error; err != nil {
+				return err
+			}
+			if err := tx.Model(&sector).Association("RequiredSkills").Append(skills); err != nil {
+				return err
+			}
 		}
+
+		return h.audit(c, tx, AuditCreate, "sector", sector.ID, nil, sector)
+	})
+	if err != nil {
+		h.respondWithError(c, http.StatusInternalServerError, "Failed to create sector")
+		return
 	}
 
 	h.respondWithSuccess(c, http.StatusCreated, sector)
@@ -65,34 +70,39 @@ func (h *Handler) UpdateSector(c *gin.Context) {
 	}
 
 	var sector models.Sector
-	if err := h.DB.First(&sector, id).Error; err != nil {
+	if err := h.DB.Preload("RequiredSkills").First(&sector, id).Error; err != nil {
 		h.respondWithError(c, http.StatusNotFound, "Sector not found")
 		return
 	}
 
+	before := sector
 	sector.Name = input.Name
 
-	if err := h.DB.Save(&sector).Error; err != nil {
-		h.respondWithError(c, http.StatusInternalServerError, "Failed to update sector")
-		return
-	}
-
-	// Update required skills
-	if err := h.DB.Model(&sector).Association("RequiredSkills").Clear(); err != nil {
-		h.respondWithError(c, http.StatusInternalServerError, "Failed to clear existing skills")
-		return
-	}
+	err := h.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Omit("RequiredSkills").Save(&sector).Error; err != nil {
+			return err
+		}
 
-	if len(input.RequiredSkills) > 0 {
-		var skills []models.Skill
-		if err := h.DB.Where("id IN ?", input.RequiredSkills).Find(&skills).Error; err != nil {
-			h.respondWithError(c, http.StatusInternalServerError, "Failed to fetch skills")
-			return
+		// Update required skills
+		if err := tx.Model(&sector).Association("RequiredSkills").Clear(); err != nil {
+			return err
 		}
-		if err := h.DB.Model(&sector).Association("RequiredSkills").Append(skills); err != nil {
-			h.respondWithError(c, http.StatusInternalServerError, "Failed to associate skills")
-			return
+
+		if len(input.RequiredSkills) > 0 {
+			var skills []models.Skill
+			if err := tx.Where("id IN ?", input.RequiredSkills).Find(&skills).Error; err != nil {
+				return err
+			}
+			if err := tx.Model(&sector).Association("RequiredSkills").Append(skills); err != nil {
+				return err
+			}
 		}
+
+		return h.audit(c, tx, AuditUpdate, "sector", sector.ID, before, sector)
+	})
+	if err != nil {
+		h.respondWithError(c, http.StatusInternalServerError, "Failed to update sector")
+		return
 	}
 
 	h.respondWithSuccess(c, http.StatusOK, sector)
@@ -101,7 +111,19 @@ func (h *Handler) UpdateSector(c *gin.Context) {
 func (h *Handler) DeleteSector(c *gin.Context) {
 	id := c.Param("id")
 
-	if err := h.DB.Delete(&models.Sector{}, id).Error; err != nil {
+	var sector models.Sector
+	if err := h.DB.Preload("RequiredSkills").First(&sector, id).Error; err != nil {
+		h.respondWithError(c, http.StatusNotFound, "Sector not found")
+		return
+	}
+
+	err := h.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Delete(&models.Sector{}, sector.ID).Error; err != nil {
+			return err
+		}
+		return h.audit(c, tx, AuditDelete, "sector", sector.ID, sector, nil)
+	})
+	if err != nil {
 		h.respondWithError(c, http.StatusInternalServerError, "Failed to delete sector")
 		return
 	}
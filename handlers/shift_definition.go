@@ -0,0 +1,233 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"planning_hager/models"
+)
+
+const shiftTimeLayout = "15:04"
+
+func loadShiftDefinitions(db *gorm.DB) (map[string]models.ShiftDefinition, error) {
+	var definitions []models.ShiftDefinition
+	if err := db.Find(&definitions).Error; err != nil {
+		return nil, err
+	}
+
+	byCode := make(map[string]models.ShiftDefinition, len(definitions))
+	for _, d := range definitions {
+		byCode[d.Code] = d
+	}
+	return byCode, nil
+}
+
+// validateShiftCode checks that a shift code has been defined.
+func validateShiftCode(db *gorm.DB, code string) error {
+	var count int64
+	if err := db.Model(&models.ShiftDefinition{}).Where("code = ?", code).Count(&count).Error; err != nil {
+		return err
+	}
+	if count == 0 {
+		return fmt.Errorf("unknown shift %q", code)
+	}
+	return nil
+}
+
+// plantLocation is the time zone shift times are expressed in, Europe/Paris
+// unless PLANNING_TIMEZONE says otherwise.
+func plantLocation() *time.Location {
+	name := os.Getenv("PLANNING_TIMEZONE")
+	if name == "" {
+		name = "Europe/Paris"
+	}
+	location, err := time.LoadLocation(name)
+	if err != nil {
+		return time.Local
+	}
+	return location
+}
+
+// shiftBounds returns the actual start and end of a shift worked on the given
+// date, its times read in the plant time zone so shifts spanning a daylight
+// saving change last what they really do. Shifts crossing midnight end on the
+// following day.
+func shiftBounds(definition models.ShiftDefinition, date time.Time) (time.Time, time.Time) {
+	start, _ := time.Parse(shiftTimeLayout, definition.StartTime)
+	end, _ := time.Parse(shiftTimeLayout, definition.EndTime)
+
+	location := plantLocation()
+	startAt := time.Date(date.Year(), date.Month(), date.Day(), start.Hour(), start.Minute(), 0, 0, location)
+	endDay := date.Day()
+	if definition.CrossesMidnight {
+		endDay++
+	}
+	endAt := time.Date(date.Year(), date.Month(), endDay, end.Hour(), end.Minute(), 0, 0, location)
+	return startAt, endAt
+}
+
+func validateShiftDefinition(definition models.ShiftDefinition) error {
+	if definition.Code == "" {
+		return errors.New("code is required")
+	}
+	start, err := time.Parse(shiftTimeLayout, definition.StartTime)
+	if err != nil {
+		return errors.New("start_time must use the HH:MM format")
+	}
+	end, err := time.Parse(shiftTimeLayout, definition.EndTime)
+	if err != nil {
+		return errors.New("end_time must use the HH:MM format")
+	}
+	if !end.After(start) && !definition.CrossesMidnight {
+		return errors.New("end_time must be after start_time unless the shift crosses midnight")
+	}
+	if end.After(start) && definition.CrossesMidnight {
+		return errors.New("a shift crossing midnight must end before it starts")
+	}
+	return nil
+}
+
+func (h *Handler) GetShiftDefinitions(c *gin.Context) {
+	var definitions []models.ShiftDefinition
+	if err := h.DB.Order("start_time ASC").Find(&definitions).Error; err != nil {
+		h.respondWithError(c, http.StatusInternalServerError, "Failed to fetch shift definitions")
+		return
+	}
+	h.respondWithSuccess(c, http.StatusOK, definitions)
+}
+
+type shiftDefinitionInput struct {
+	Code            string `json:"code" binding:"required"`
+	Label           string `json:"label" binding:"required"`
+	StartTime       string `json:"start_time" binding:"required"`
+	EndTime         string `json:"end_time" binding:"required"`
+	CrossesMidnight bool   `json:"crosses_midnight"`
+	Color           string `json:"color"`
+}
+
+func (input shiftDefinitionInput) apply(definition *models.ShiftDefinition) {
+	definition.Code = input.Code
+	definition.Label = input.Label
+	definition.StartTime = input.StartTime
+	definition.EndTime = input.EndTime
+	definition.CrossesMidnight = input.CrossesMidnight
+	definition.Color = input.Color
+}
+
+func (h *Handler) AddShiftDefinition(c *gin.Context) {
+	var input shiftDefinitionInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		h.respondWithError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	var definition models.ShiftDefinition
+	input.apply(&definition)
+
+	if err := validateShiftDefinition(definition); err != nil {
+		h.respondWithError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if err := h.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&definition).Error; err != nil {
+			return err
+		}
+		return h.audit(c, tx, AuditCreate, "shift_definition", definition.ID, nil, definition)
+	}); err != nil {
+		h.respondWithError(c, http.StatusInternalServerError, "Failed to create shift definition")
+		return
+	}
+
+	h.respondWithSuccess(c, http.StatusCreated, definition)
+}
+
+func (h *Handler) shiftInUse(code string) (bool, error) {
+	var count int64
+	err := h.DB.Model(&models.Planning{}).Where("shift = ?", code).Count(&count).Error
+	return count > 0, err
+}
+
+func (h *Handler) UpdateShiftDefinition(c *gin.Context) {
+	id := c.Param("id")
+	var input shiftDefinitionInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		h.respondWithError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	var definition models.ShiftDefinition
+	if err := h.DB.First(&definition, id).Error; err != nil {
+		h.respondWithError(c, http.StatusNotFound, "Shift definition not found")
+		return
+	}
+
+	if input.Code != definition.Code {
+		inUse, err := h.shiftInUse(definition.Code)
+		if err != nil {
+			h.respondWithError(c, http.StatusInternalServerError, "Failed to check shift usage")
+			return
+		}
+		if inUse {
+			h.respondWithError(c, http.StatusConflict, "Shift code is used by planning entries")
+			return
+		}
+	}
+
+	before := definition
+	input.apply(&definition)
+
+	if err := validateShiftDefinition(definition); err != nil {
+		h.respondWithError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if err := h.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(&definition).Error; err != nil {
+			return err
+		}
+		return h.audit(c, tx, AuditUpdate, "shift_definition", definition.ID, before, definition)
+	}); err != nil {
+		h.respondWithError(c, http.StatusInternalServerError, "Failed to update shift definition")
+		return
+	}
+
+	h.respondWithSuccess(c, http.StatusOK, definition)
+}
+
+func (h *Handler) DeleteShiftDefinition(c *gin.Context) {
+	id := c.Param("id")
+
+	var definition models.ShiftDefinition
+	if err := h.DB.First(&definition, id).Error; err != nil {
+		h.respondWithError(c, http.StatusNotFound, "Shift definition not found")
+		return
+	}
+
+	inUse, err := h.shiftInUse(definition.Code)
+	if err != nil {
+		h.respondWithError(c, http.StatusInternalServerError, "Failed to check shift usage")
+		return
+	}
+	if inUse {
+		h.respondWithError(c, http.StatusConflict, "Shift code is used by planning entries")
+		return
+	}
+
+	if err := h.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Delete(&definition).Error; err != nil {
+			return err
+		}
+		return h.audit(c, tx, AuditDelete, "shift_definition", definition.ID, definition, nil)
+	}); err != nil {
+		h.respondWithError(c, http.StatusInternalServerError, "Failed to delete shift definition")
+		return
+	}
+
+	h.respondWithSuccess(c, http.StatusOK, gin.H{"message": "Shift definition deleted successfully"})
+}
@@ -0,0 +1,539 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"planning_hager/models"
+)
+
+const (
+	SwapStateProposed  = "proposed"
+	SwapStateAccepted  = "accepted"
+	SwapStateDeclined  = "declined"
+	SwapStateApproved  = "approved"
+	SwapStateRejected  = "rejected"
+	SwapStateCancelled = "cancelled"
+)
+
+// openSwapStates are the states in which a swap still holds its planning
+// entries.
+var openSwapStates = []string{SwapStateProposed, SwapStateAccepted}
+
+// swappedPlanningKeys returns the slots employees gave away through a swap
+// approved between from and to, keyed like planningKey.
+func swappedPlanningKeys(tx *gorm.DB, from, to time.Time) (map[string]bool, error) {
+	// Each side of a swap: the planning given away and who held it before
+	sides := [][2]string{
+		{"requester_planning_id", "requester_id"},
+		{"colleague_planning_id", "colleague_id"},
+	}
+
+	keys := make(map[string]bool)
+	for _, side := range sides {
+		var rows []struct {
+			Date       time.Time
+			Shift      string
+			EmployeeID uint
+		}
+		if err := tx.Model(&models.Planning{}).
+			Select("plannings.date, plannings.shift, shift_swap_requests."+side[1]+" AS employee_id").
+			Joins("JOIN shift_swap_requests ON shift_swap_requests."+side[0]+" = plannings.id").
+			Where("shift_swap_requests.state = ? AND plannings.date >= ? AND plannings.date < ?", SwapStateApproved, from, to).
+			Scan(&rows).Error; err != nil {
+			return nil, err
+		}
+		for _, row := range rows {
+			employeeID := row.EmployeeID
+			keys[planningKey(models.Planning{Date: row.Date, Shift: row.Shift, EmployeeID: &employeeID})] = true
+		}
+	}
+	return keys, nil
+}
+
+// worksSlot reports whether the employee already works the shift on that
+// date, on their own planning entry or as a substitute.
+func worksSlot(db *gorm.DB, employeeID uint, date time.Time, shift string) (bool, error) {
+	var count int64
+	err := db.Model(&models.Planning{}).
+		Where("date = ? AND shift = ?", date, shift).
+		Where("employee_id = ? OR substitute_id = ?", employeeID, employeeID).
+		Count(&count).Error
+	return count > 0, err
+}
+
+// swapSlotTaken reports whether either employee of a swap already works the
+// slot the other hands over.
+func swapSlotTaken(db *gorm.DB, own, theirs models.Planning) (bool, error) {
+	taken, err := worksSlot(db, *theirs.EmployeeID, own.Date, own.Shift)
+	if err != nil || taken {
+		return taken, err
+	}
+	return worksSlot(db, *own.EmployeeID, theirs.Date, theirs.Shift)
+}
+
+func (h *Handler) AddShiftSwapRequest(c *gin.Context) {
+	var input struct {
+		PlanningID          uint   `json:"planning_id" binding:"required"`
+		ColleaguePlanningID uint   `json:"colleague_planning_id" binding:"required"`
+		Message             string `json:"message"`
+	}
+
+	if err := c.ShouldBindJSON(&input); err != nil {
+		h.respondWithError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if c.GetString("role") == "readonly" {
+		h.respondWithError(c, http.StatusForbidden, "Read-only users cannot request shift swaps")
+		return
+	}
+
+	employee, err := h.currentEmployee(c)
+	if err != nil {
+		h.respondWithError(c, http.StatusNotFound, "No employee found for the current user")
+		return
+	}
+
+	var own, theirs models.Planning
+	if err := h.DB.First(&own, input.PlanningID).Error; err != nil {
+		h.respondWithError(c, http.StatusNotFound, "Planning entry not found")
+		return
+	}
+	if err := h.DB.First(&theirs, input.ColleaguePlanningID).Error; err != nil {
+		h.respondWithError(c, http.StatusNotFound, "Colleague planning entry not found")
+		return
+	}
+
+	if own.EmployeeID == nil || *own.EmployeeID != employee.ID {
+		h.respondWithError(c, http.StatusForbidden, "Planning entry belongs to another employee")
+		return
+	}
+	if theirs.EmployeeID == nil || *theirs.EmployeeID == employee.ID {
+		h.respondWithError(c, http.StatusBadRequest, "Colleague planning entry must belong to another employee")
+		return
+	}
+	if own.Status != StatusScheduled || theirs.Status != StatusScheduled {
+		h.respondWithError(c, http.StatusBadRequest, "Only scheduled entries can be swapped")
+		return
+	}
+	if own.Date.Equal(theirs.Date) && own.Shift == theirs.Shift {
+		h.respondWithError(c, http.StatusBadRequest, "Both entries are on the same shift")
+		return
+	}
+	taken, err := swapSlotTaken(h.DB, own, theirs)
+	if err != nil {
+		h.respondWithError(c, http.StatusInternalServerError, "Failed to check planning entries")
+		return
+	}
+	if taken {
+		h.respondWithError(c, http.StatusBadRequest, "One of you already works the shift the other hands over")
+		return
+	}
+
+	var pending int64
+	if err := h.DB.Model(&models.ShiftSwapRequest{}).
+		Where("state IN ?", openSwapStates).
+		Where("requester_planning_id IN ? OR colleague_planning_id IN ?",
+			[]uint{own.ID, theirs.ID}, []uint{own.ID, theirs.ID}).
+		Count(&pending).Error; err != nil {
+		h.respondWithError(c, http.StatusInternalServerError, "Failed to check existing swap requests")
+		return
+	}
+	if pending > 0 {
+		h.respondWithError(c, http.StatusConflict, "A swap request is already open for one of these entries")
+		return
+	}
+
+	swap := models.ShiftSwapRequest{
+		RequesterID:         employee.ID,
+		RequesterPlanningID: own.ID,
+		ColleagueID:         *theirs.EmployeeID,
+		ColleaguePlanningID: theirs.ID,
+		Message:             input.Message,
+		State:               SwapStateProposed,
+	}
+
+	if err := h.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&swap).Error; err != nil {
+			return err
+		}
+		return h.audit(c, tx, AuditCreate, "shift_swap_request", swap.ID, nil, swap)
+	}); err != nil {
+		h.respondWithError(c, http.StatusInternalServerError, "Failed to create swap request")
+		return
+	}
+
+	h.respondWithSuccess(c, http.StatusCreated, swap)
+}
+
+func (h *Handler) GetShiftSwapRequests(c *gin.Context) {
+	query := h.DB.Preload("Requester").Preload("Colleague").
+		Preload("RequesterPlanning").Preload("ColleaguePlanning").
+		Order("created_at DESC")
+	if state := c.Query("state"); state != "" {
+		query = query.Where("state = ?", state)
+	}
+
+	var swaps []models.ShiftSwapRequest
+	if err := query.Find(&swaps).Error; err != nil {
+		h.respondWithError(c, http.StatusInternalServerError, "Failed to fetch swap requests")
+		return
+	}
+
+	h.respondWithSuccess(c, http.StatusOK, swaps)
+}
+
+// GetCurrentEmployeeShiftSwapRequests lists the swaps the current employee
+// proposed or was asked for.
+func (h *Handler) GetCurrentEmployeeShiftSwapRequests(c *gin.Context) {
+	employee, err := h.currentEmployee(c)
+	if err != nil {
+		h.respondWithError(c, http.StatusNotFound, "No employee found for the current user")
+		return
+	}
+
+	var swaps []models.ShiftSwapRequest
+	if err := h.DB.Preload("Requester").Preload("Colleague").
+		Preload("RequesterPlanning").Preload("ColleaguePlanning").
+		Where("requester_id = ? OR colleague_id = ?", employee.ID, employee.ID).
+		Order("created_at DESC").
+		Find(&swaps).Error; err != nil {
+		h.respondWithError(c, http.StatusInternalServerError, "Failed to fetch swap requests")
+		return
+	}
+
+	h.respondWithSuccess(c, http.StatusOK, swaps)
+}
+
+// respondToShiftSwap lets the colleague accept or decline a proposed swap.
+func (h *Handler) respondToShiftSwap(c *gin.Context, state string) {
+	id := c.Param("id")
+
+	if c.GetString("role") == "readonly" {
+		h.respondWithError(c, http.StatusForbidden, "Read-only users cannot change shift swaps")
+		return
+	}
+
+	employee, err := h.currentEmployee(c)
+	if err != nil {
+		h.respondWithError(c, http.StatusNotFound, "No employee found for the current user")
+		return
+	}
+
+	var swap models.ShiftSwapRequest
+	if err := h.DB.First(&swap, id).Error; err != nil {
+		h.respondWithError(c, http.StatusNotFound, "Swap request not found")
+		return
+	}
+	if swap.ColleagueID != employee.ID {
+		h.respondWithError(c, http.StatusForbidden, "Swap request is addressed to another employee")
+		return
+	}
+	if swap.State != SwapStateProposed {
+		h.respondWithError(c, http.StatusConflict, "Swap request has already been answered")
+		return
+	}
+
+	before := swap
+	now := time.Now()
+	swap.State = state
+	swap.RespondedAt = &now
+
+	if err := h.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(&swap).Error; err != nil {
+			return err
+		}
+		return h.audit(c, tx, AuditUpdate, "shift_swap_request", swap.ID, before, swap)
+	}); err != nil {
+		h.respondWithError(c, http.StatusInternalServerError, "Failed to update swap request")
+		return
+	}
+
+	h.respondWithSuccess(c, http.StatusOK, swap)
+}
+
+func (h *Handler) AcceptShiftSwapRequest(c *gin.Context) {
+	h.respondToShiftSwap(c, SwapStateAccepted)
+}
+
+func (h *Handler) DeclineShiftSwapRequest(c *gin.Context) {
+	h.respondToShiftSwap(c, SwapStateDeclined)
+}
+
+func (h *Handler) CancelShiftSwapRequest(c *gin.Context) {
+	id := c.Param("id")
+
+	if c.GetString("role") == "readonly" {
+		h.respondWithError(c, http.StatusForbidden, "Read-only users cannot change shift swaps")
+		return
+	}
+
+	employee, err := h.currentEmployee(c)
+	if err != nil {
+		h.respondWithError(c, http.StatusNotFound, "No employee found for the current user")
+		return
+	}
+
+	var swap models.ShiftSwapRequest
+	if err := h.DB.First(&swap, id).Error; err != nil {
+		h.respondWithError(c, http.StatusNotFound, "Swap request not found")
+		return
+	}
+	if swap.RequesterID != employee.ID {
+		h.respondWithError(c, http.StatusForbidden, "Swap request belongs to another employee")
+		return
+	}
+	if swap.State != SwapStateProposed && swap.State != SwapStateAccepted {
+		h.respondWithError(c, http.StatusConflict, "Swap request can no longer be cancelled")
+		return
+	}
+
+	before := swap
+	swap.State = SwapStateCancelled
+	if err := h.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(&swap).Error; err != nil {
+			return err
+		}
+		return h.audit(c, tx, AuditUpdate, "shift_swap_request", swap.ID, before, swap)
+	}); err != nil {
+		h.respondWithError(c, http.StatusInternalServerError, "Failed to update swap request")
+		return
+	}
+
+	h.respondWithSuccess(c, http.StatusOK, swap)
+}
+
+// sectorSkillGaps returns, for an employee taking over a planning entry, the
+// skills of the entry's sector they do not hold.
+func sectorSkillGaps(tx *gorm.DB, employeeID uint, planning models.Planning) ([]string, error) {
+	if planning.SectorID == nil {
+		return nil, nil
+	}
+
+	var sector models.Sector
+	if err := tx.Preload("RequiredSkills").First(&sector, *planning.SectorID).Error; err != nil {
+		return nil, err
+	}
+
+	var employee models.Employee
+	if err := tx.Preload("Skills").First(&employee, employeeID).Error; err != nil {
+		return nil, err
+	}
+
+	return missingSkills(sector.RequiredSkills, employee.Skills), nil
+}
+
+// ApproveShiftSwapRequest applies an accepted swap: both employees exchange
+// their planning entries, provided each holds the skills of the other's
+// sector and still gets the minimum rest.
+func (h *Handler) ApproveShiftSwapRequest(c *gin.Context) {
+	id := c.Param("id")
+	var input reviewInput
+	if err := c.ShouldBindJSON(&input); err != nil && !errors.Is(err, io.EOF) {
+		h.respondWithError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	tx := h.DB.Begin()
+
+	var swap models.ShiftSwapRequest
+	if err := tx.First(&swap, id).Error; err != nil {
+		tx.Rollback()
+		h.respondWithError(c, http.StatusNotFound, "Swap request not found")
+		return
+	}
+	if swap.State != SwapStateAccepted {
+		tx.Rollback()
+		h.respondWithError(c, http.StatusConflict, "Only swaps accepted by the colleague can be approved")
+		return
+	}
+
+	var own, theirs models.Planning
+	if err := tx.First(&own, swap.RequesterPlanningID).Error; err != nil {
+		tx.Rollback()
+		h.respondWithError(c, http.StatusConflict, "Planning entry no longer exists")
+		return
+	}
+	if err := tx.First(&theirs, swap.ColleaguePlanningID).Error; err != nil {
+		tx.Rollback()
+		h.respondWithError(c, http.StatusConflict, "Colleague planning entry no longer exists")
+		return
+	}
+	if !sameUint(own.EmployeeID, &swap.RequesterID) || !sameUint(theirs.EmployeeID, &swap.ColleagueID) ||
+		own.Status != StatusScheduled || theirs.Status != StatusScheduled {
+		tx.Rollback()
+		h.respondWithError(c, http.StatusConflict, "Planning entries changed since the swap was requested")
+		return
+	}
+	taken, err := swapSlotTaken(tx, own, theirs)
+	if err != nil {
+		tx.Rollback()
+		h.respondWithError(c, http.StatusInternalServerError, "Failed to check planning entries")
+		return
+	}
+	if taken {
+		tx.Rollback()
+		h.respondWithError(c, http.StatusConflict, "One of the employees already works the shift the other hands over")
+		return
+	}
+
+	// Each employee must be able to work the other's position
+	for _, check := range []struct {
+		employeeID uint
+		planning   models.Planning
+	}{{swap.RequesterID, theirs}, {swap.ColleagueID, own}} {
+		missing, err := sectorSkillGaps(tx, check.employeeID, check.planning)
+		if err != nil {
+			tx.Rollback()
+			h.respondWithError(c, http.StatusInternalServerError, "Failed to check skills")
+			return
+		}
+		if len(missing) > 0 {
+			tx.Rollback()
+			h.respondWithError(c, http.StatusConflict, "Missing required skills: "+strings.Join(missing, ", "))
+			return
+		}
+	}
+
+	from, to := own.Date, theirs.Date
+	if to.Before(from) {
+		from, to = to, from
+	}
+	to = to.AddDate(0, 0, 1)
+	if !h.checkPlanningUnlocked(c, tx, from, to, overrideReason(c, input.OverrideReason)) {
+		return
+	}
+	baseline, err := h.existingViolations(tx, from, to)
+	if err != nil {
+		tx.Rollback()
+		h.respondWithError(c, http.StatusInternalServerError, "Failed to evaluate labour rules")
+		return
+	}
+
+	ownBefore, theirsBefore := own, theirs
+	own.EmployeeID, theirs.EmployeeID = theirs.EmployeeID, own.EmployeeID
+	own.Source, theirs.Source = PlanningSourceSwap, PlanningSourceSwap
+	if err := tx.Save(&own).Error; err != nil {
+		tx.Rollback()
+		h.respondWithError(c, http.StatusInternalServerError, "Failed to update planning entry")
+		return
+	}
+	if err := tx.Save(&theirs).Error; err != nil {
+		tx.Rollback()
+		h.respondWithError(c, http.StatusInternalServerError, "Failed to update planning entry")
+		return
+	}
+
+	diff := planningDiff{Updated: []planningChange{
+		{Before: ownBefore, After: own},
+		{Before: theirsBefore, After: theirs},
+	}}
+	if err := h.auditPlanningDiff(c, tx, diff); err != nil {
+		tx.Rollback()
+		h.respondWithError(c, http.StatusInternalServerError, "Failed to record audit log")
+		return
+	}
+
+	// The exchange can be undone by reverting its change set
+	changeSet, err := h.recordPlanningChangeSet(c, tx, ChangeSetShiftSwap, fmt.Sprintf("Shift swap %d approved", swap.ID), diff)
+	if err != nil {
+		tx.Rollback()
+		h.respondWithError(c, http.StatusInternalServerError, "Failed to record change set")
+		return
+	}
+
+	violations, blocking, err := h.enforceLabourRules(tx, baseline, []uint{swap.RequesterID, swap.ColleagueID}, from, to)
+	if err != nil {
+		tx.Rollback()
+		h.respondWithError(c, http.StatusInternalServerError, "Failed to evaluate labour rules")
+		return
+	}
+	// Rest is always checked for swaps, whatever the enforcement mode
+	if len(blocking) == 0 {
+		for _, v := range violations {
+			if v.Rule == RuleMinRest && !baseline[violationKey(v)] {
+				blocking = append(blocking, v)
+			}
+		}
+	}
+	if len(blocking) > 0 {
+		tx.Rollback()
+		h.respondWithViolations(c, blocking)
+		return
+	}
+
+	swapBefore := swap
+	now := time.Now()
+	swap.State = SwapStateApproved
+	swap.ReviewedBy = c.GetString("username")
+	swap.ReviewedAt = &now
+	swap.ReviewComment = input.Comment
+
+	if err := tx.Save(&swap).Error; err != nil {
+		tx.Rollback()
+		h.respondWithError(c, http.StatusInternalServerError, "Failed to update swap request")
+		return
+	}
+	if err := h.audit(c, tx, AuditUpdate, "shift_swap_request", swap.ID, swapBefore, swap); err != nil {
+		tx.Rollback()
+		h.respondWithError(c, http.StatusInternalServerError, "Failed to record audit log")
+		return
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		h.respondWithError(c, http.StatusInternalServerError, "Failed to commit transaction")
+		return
+	}
+
+	h.respondWithSuccess(c, http.StatusOK, gin.H{
+		"request":       swap,
+		"plannings":     []models.Planning{own, theirs},
+		"violations":    violations,
+		"change_set_id": changeSet.ID,
+	})
+}
+
+func (h *Handler) RejectShiftSwapRequest(c *gin.Context) {
+	id := c.Param("id")
+	var input reviewInput
+	if err := c.ShouldBindJSON(&input); err != nil && !errors.Is(err, io.EOF) {
+		h.respondWithError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	var swap models.ShiftSwapRequest
+	if err := h.DB.First(&swap, id).Error; err != nil {
+		h.respondWithError(c, http.StatusNotFound, "Swap request not found")
+		return
+	}
+	if swap.State != SwapStateProposed && swap.State != SwapStateAccepted {
+		h.respondWithError(c, http.StatusConflict, "Swap request is no longer open")
+		return
+	}
+
+	before := swap
+	now := time.Now()
+	swap.State = SwapStateRejected
+	swap.ReviewedBy = c.GetString("username")
+	swap.ReviewedAt = &now
+	swap.ReviewComment = input.Comment
+
+	if err := h.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(&swap).Error; err != nil {
+			return err
+		}
+		return h.audit(c, tx, AuditUpdate, "shift_swap_request", swap.ID, before, swap)
+	}); err != nil {
+		h.respondWithError(c, http.StatusInternalServerError, "Failed to update swap request")
+		return
+	}
+
+	h.respondWithSuccess(c, http.StatusOK, swap)
+}
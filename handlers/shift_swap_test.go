@@ -0,0 +1,33 @@
+package handlers
+
+import (
+	"testing"
+
+	"planning_hager/models"
+)
+
+func TestSwapSlotTaken(t *testing.T) {
+	db := newTestDB(t)
+	employees := seedTeams(t, db)
+	jean, paul := employees[0], employees[1]
+
+	plan := func(employeeID uint, day int, shift string) models.Planning {
+		t.Helper()
+		p := models.Planning{Date: utcDate(2030, 3, day), Year: testYear, Week: testWeek, Shift: shift, EmployeeID: &employeeID, Status: StatusScheduled}
+		if err := db.Create(&p).Error; err != nil {
+			t.Fatalf("creating planning: %v", err)
+		}
+		return p
+	}
+	own, theirs := plan(jean.ID, 4, "M"), plan(paul.ID, 5, "S")
+
+	if taken, err := swapSlotTaken(db, own, theirs); err != nil || taken {
+		t.Errorf("swapSlotTaken = %v, %v, want the slots free", taken, err)
+	}
+
+	// Paul already works the morning Jean hands over
+	plan(paul.ID, 4, "M")
+	if taken, err := swapSlotTaken(db, own, theirs); err != nil || !taken {
+		t.Errorf("swapSlotTaken = %v, %v, want the slot taken", taken, err)
+	}
+}
@@ -2,6 +2,7 @@ package handlers
 
 import (
 	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
 	"net/http"
 	"planning_hager/models"
 )
@@ -27,7 +28,13 @@ func (h *Handler) AddSkill(c *gin.Context) {
 
 	skill := models.Skill{Name: input.Name}
 
-	if err := h.DB.Create(&skill).Error; err != nil {
+	err := h.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&skill).Error; err != nil {
+			return err
+		}
+		return h.audit(c, tx, AuditCreate, "skill", skill.ID, nil, skill)
+	})
+	if err != nil {
 		h.respondWithError(c, http.StatusInternalServerError, "Failed to create skill")
 		return
 	}
@@ -52,9 +59,16 @@ func (h *Handler) UpdateSkill(c *gin.Context) {
 		return
 	}
 
+	before := skill
 	skill.Name = input.Name
 
-	if err := h.DB.Save(&skill).Error; err != nil {
+	err := h.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(&skill).Error; err != nil {
+			return err
+		}
+		return h.audit(c, tx, AuditUpdate, "skill", skill.ID, before, skill)
+	})
+	if err != nil {
 		h.respondWithError(c, http.StatusInternalServerError, "Failed to update skill")
 		return
 	}
@@ -65,7 +79,19 @@ func (h *Handler) UpdateSkill(c *gin.Context) {
 func (h *Handler) DeleteSkill(c *gin.Context) {
 	id := c.Param("id")
 
-	if err := h.DB.Delete(&models.Skill{}, id).Error; err != nil {
+	var skill models.Skill
+	if err := h.DB.First(&skill, id).Error; err != nil {
+		h.respondWithError(c, http.StatusNotFound, "Skill not found")
+		return
+	}
+
+	err := h.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Delete(&skill).Error; err != nil {
+			return err
+		}
+		return h.audit(c, tx, AuditDelete, "skill", skill.ID, skill, nil)
+	})
+	if err != nil {
 		h.respondWithError(c, http.StatusInternalServerError, "Failed to delete skill")
 		return
 	}
@@ -0,0 +1,255 @@
+package handlers
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"planning_hager/models"
+)
+
+const (
+	CandidateEmployee  = "employee"
+	CandidateReservist = "reservist"
+)
+
+type substituteCandidate struct {
+	Type    string   `json:"type"`
+	ID      uint     `json:"id"`
+	Name    string   `json:"name"`
+	Score   int      `json:"score"`
+	Reasons []string `json:"reasons"`
+}
+
+// missingSkills returns the required skills not found in held.
+func missingSkills(required, held []models.Skill) []string {
+	heldIDs := make(map[uint]bool, len(held))
+	for _, skill := range held {
+		heldIDs[skill.ID] = true
+	}
+
+	var missing []string
+	for _, skill := range required {
+		if !heldIDs[skill.ID] {
+			missing = append(missing, skill.Name)
+		}
+	}
+	return missing
+}
+
+func formatRest(rest time.Duration) string {
+	if rest < 0 {
+		return "no shift nearby"
+	}
+	return fmt.Sprintf("%.0fh", rest.Hours())
+}
+
+// suggestSubstitutes ranks the employees and reservists able to replace the
+// person planned on the given entry. Candidates must hold every skill the
+// sector requires, be free on that date and shift and get the minimum rest
+// before and after it.
+func (h *Handler) suggestSubstitutes(planning models.Planning) ([]substituteCandidate, error) {
+	candidates := []substituteCandidate{}
+
+	var required []models.Skill
+	if planning.SectorID != nil {
+		var sector models.Sector
+		if err := h.DB.Preload("RequiredSkills").First(&sector, *planning.SectorID).Error; err != nil {
+			return nil, err
+		}
+		required = sector.RequiredSkills
+	}
+
+	shifts, err := loadShiftDefinitions(h.DB)
+	if err != nil {
+		return nil, err
+	}
+	definition, ok := shifts[planning.Shift]
+	if !ok {
+		return nil, fmt.Errorf("unknown shift %q", planning.Shift)
+	}
+	start, end := shiftBounds(definition, planning.Date)
+
+	rules, err := h.labourRules(h.DB)
+	if err != nil {
+		return nil, err
+	}
+	minRest := time.Duration(rules.MinRestHours * float64(time.Hour))
+
+	// Team of the absent employee, which works the same shifts
+	var plannedCEID uint
+	if planning.CEID != nil {
+		plannedCEID = *planning.CEID
+	}
+	if planning.EmployeeID != nil {
+		var planned models.Employee
+		if err := h.DB.First(&planned, *planning.EmployeeID).Error; err == nil {
+			plannedCEID = planned.CEID
+		}
+	}
+
+	var employees []models.Employee
+	if err := h.DB.Preload("Skills").Find(&employees).Error; err != nil {
+		return nil, err
+	}
+
+	employeeIDs := make([]uint, 0, len(employees))
+	for _, emp := range employees {
+		employeeIDs = append(employeeIDs, emp.ID)
+	}
+
+	// Load the whole week for the workload, plus the neighbouring days for
+	// the rest checks
+	weekYear, week := planning.Date.ISOWeek()
+	weekFrom, weekTo := isoWeekRange(weekYear, week)
+	worked, err := employeeWorkedShifts(h.DB, employeeIDs, weekFrom.AddDate(0, 0, -2), weekTo.AddDate(0, 0, 2))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, emp := range employees {
+		if planning.EmployeeID != nil && emp.ID == *planning.EmployeeID {
+			continue
+		}
+		if plannedCEID != 0 && emp.CEID == plannedCEID {
+			continue
+		}
+		if len(missingSkills(required, emp.Skills)) > 0 {
+			continue
+		}
+
+		before, after, overlaps := restAround(worked[emp.ID], start, end, planning.ID)
+		if overlaps {
+			continue
+		}
+		if (before >= 0 && before < minRest) || (after >= 0 && after < minRest) {
+			continue
+		}
+
+		shiftsThisWeek := 0
+		for _, w := range worked[emp.ID] {
+			if day := dayOf(w.Start); !day.Before(weekFrom) && day.Before(weekTo) {
+				shiftsThisWeek++
+			}
+		}
+
+		score := 100 - 5*shiftsThisWeek + restBonus(before) + restBonus(after)
+		candidates = append(candidates, substituteCandidate{
+			Type:  CandidateEmployee,
+			ID:    emp.ID,
+			Name:  emp.Name,
+			Score: score,
+			Reasons: []string{
+				fmt.Sprintf("Holds the %d required skills", len(required)),
+				"Not scheduled on this shift",
+				fmt.Sprintf("Rest before: %s, rest after: %s", formatRest(before), formatRest(after)),
+				fmt.Sprintf("%d shifts already worked this week", shiftsThisWeek),
+			},
+		})
+	}
+
+	var reservists []models.Reservist
+	if err := h.DB.Preload("Skills").Preload("Availabilities").Find(&reservists).Error; err != nil {
+		return nil, err
+	}
+
+	var reservistWeek []models.Planning
+	if err := h.DB.Where("date >= ? AND date < ? AND substitute_reservist_id IS NOT NULL AND id <> ?", weekFrom, weekTo, planning.ID).
+		Find(&reservistWeek).Error; err != nil {
+		return nil, err
+	}
+	reservistShifts := make(map[uint]int)
+	for _, p := range reservistWeek {
+		reservistShifts[*p.SubstituteReservistID]++
+	}
+
+	busyReservists := make(map[uint]bool)
+	for _, p := range reservistWeek {
+		if p.Date.Equal(planning.Date) && p.Shift == planning.Shift {
+			busyReservists[*p.SubstituteReservistID] = true
+		}
+	}
+
+	reservistIDs := make([]uint, 0, len(reservists))
+	for _, reservist := range reservists {
+		reservistIDs = append(reservistIDs, reservist.ID)
+	}
+	reservistWorked, err := reservistWorkedShifts(h.DB, reservistIDs, weekFrom.AddDate(0, 0, -2), weekTo.AddDate(0, 0, 2))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, reservist := range reservists {
+		if busyReservists[reservist.ID] {
+			continue
+		}
+		if len(missingSkills(required, reservist.Skills)) > 0 {
+			continue
+		}
+		available, availability := reservistAvailableFor(reservist.Availabilities, planning.Date, planning.Shift, reservistShifts[reservist.ID])
+		if !available {
+			continue
+		}
+
+		// Reservists get the same rest as employees
+		before, after, overlaps := restAround(reservistWorked[reservist.ID], start, end, planning.ID)
+		if overlaps {
+			continue
+		}
+		if (before >= 0 && before < minRest) || (after >= 0 && after < minRest) {
+			continue
+		}
+
+		candidates = append(candidates, substituteCandidate{
+			Type:  CandidateReservist,
+			ID:    reservist.ID,
+			Name:  reservist.Name,
+			Score: 80 - 5*reservistShifts[reservist.ID],
+			Reasons: []string{
+				fmt.Sprintf("Holds the %d required skills", len(required)),
+				"Not scheduled on this shift",
+				availability,
+				"Reservist, ranked after available employees",
+			},
+		})
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		if candidates[i].Score != candidates[j].Score {
+			return candidates[i].Score > candidates[j].Score
+		}
+		return candidates[i].Name < candidates[j].Name
+	})
+
+	return candidates, nil
+}
+
+// restBonus favours candidates with more rest around the shift, one point per
+// hour up to a day. A missing neighbouring shift counts as a full day.
+func restBonus(rest time.Duration) int {
+	if rest < 0 {
+		return 24
+	}
+	return int(math.Min(rest.Hours(), 24))
+}
+
+func (h *Handler) GetSubstituteSuggestions(c *gin.Context) {
+	id := c.Param("id")
+
+	var planning models.Planning
+	if err := h.DB.First(&planning, id).Error; err != nil {
+		h.respondWithError(c, http.StatusNotFound, "Planning entry not found")
+		return
+	}
+
+	candidates, err := h.suggestSubstitutes(planning)
+	if err != nil {
+		h.respondWithError(c, http.StatusInternalServerError, "Failed to compute substitute suggestions")
+		return
+	}
+
+	h.respondWithSuccess(c, http.StatusOK, candidates)
+}
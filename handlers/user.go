@@ -0,0 +1,443 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+	"planning_hager/models"
+)
+
+// Roles AuthMiddleware accepts.
+const (
+	RoleAdmin    = "admin"
+	RoleUser     = "user"
+	RoleReadonly = "readonly"
+)
+
+var ValidRoles = []string{RoleAdmin, RoleUser, RoleReadonly}
+
+const minPasswordLength = 8
+
+var (
+	ErrUserNotFound     = errors.New("user not found")
+	ErrUserExists       = errors.New("username already taken")
+	ErrUsernameRequired = errors.New("username is required")
+	ErrInvalidRole      = fmt.Errorf("role must be one of %s", strings.Join(ValidRoles, ", "))
+	ErrPasswordTooShort = fmt.Errorf("password must be at least %d characters", minPasswordLength)
+	ErrLastAdmin        = errors.New("at least one active admin must remain")
+	ErrOwnAccount       = errors.New("you cannot deactivate your own account")
+	ErrNoEmployeeLinked = errors.New("no employee linked to the account")
+	ErrEmployeeLinked   = errors.New("employee already linked to another account")
+	ErrEmployeeNotFound = errors.New("employee not found")
+)
+
+// userResponse is a user as the API shows it, without the password hash.
+type userResponse struct {
+	ID         uint      `json:"id"`
+	Username   string    `json:"username"`
+	Role       string    `json:"role"`
+	Active     bool      `json:"active"`
+	EmployeeID *uint     `json:"employee_id"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+func newUserResponse(user models.User) userResponse {
+	return userResponse{
+		ID:         user.ID,
+		Username:   user.Username,
+		Role:       user.Role,
+		Active:     user.Active,
+		EmployeeID: user.EmployeeID,
+		CreatedAt:  user.CreatedAt,
+		UpdatedAt:  user.UpdatedAt,
+	}
+}
+
+func isValidRole(role string) bool {
+	for _, r := range ValidRoles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// hashPassword checks the password is long enough and returns its bcrypt
+// hash.
+func hashPassword(password string) (string, error) {
+	if len(password) < minPasswordLength {
+		return "", ErrPasswordTooShort
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+func findUser(db *gorm.DB, username string) (models.User, error) {
+	var user models.User
+	err := db.Where("username = ?", username).First(&user).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		err = ErrUserNotFound
+	}
+	return user, err
+}
+
+// checkOtherAdmin refuses to demote, deactivate or delete the user when it is
+// the last active admin, so the planning can always be administered.
+func checkOtherAdmin(db *gorm.DB, user models.User) error {
+	if user.Role != RoleAdmin || !user.Active {
+		return nil
+	}
+	var count int64
+	if err := db.Model(&models.User{}).
+		Where("role = ? AND active = ? AND id <> ?", RoleAdmin, true, user.ID).
+		Count(&count).Error; err != nil {
+		return err
+	}
+	if count == 0 {
+		return ErrLastAdmin
+	}
+	return nil
+}
+
+// CreateUser creates an active account with a hashed password.
+func CreateUser(db *gorm.DB, username, password, role string) (models.User, error) {
+	username = strings.TrimSpace(username)
+	if username == "" {
+		return models.User{}, ErrUsernameRequired
+	}
+	if !isValidRole(role) {
+		return models.User{}, ErrInvalidRole
+	}
+
+	// Deleted accounts keep their username, the audit log refers to it
+	var count int64
+	if err := db.Unscoped().Model(&models.User{}).Where("username = ?", username).Count(&count).Error; err != nil {
+		return models.User{}, err
+	}
+	if count > 0 {
+		return models.User{}, ErrUserExists
+	}
+
+	hash, err := hashPassword(password)
+	if err != nil {
+		return models.User{}, err
+	}
+
+	user := models.User{Username: username, Password: hash, Role: role, Active: true}
+	return user, db.Create(&user).Error
+}
+
+func setPassword(db *gorm.DB, user *models.User, password string) error {
+	hash, err := hashPassword(password)
+	if err != nil {
+		return err
+	}
+	user.Password = hash
+	return db.Model(user).Update("password", hash).Error
+}
+
+func setRole(db *gorm.DB, user *models.User, role string) error {
+	if !isValidRole(role) {
+		return ErrInvalidRole
+	}
+	if role != RoleAdmin {
+		if err := checkOtherAdmin(db, *user); err != nil {
+			return err
+		}
+	}
+	user.Role = role
+	return db.Model(user).Update("role", role).Error
+}
+
+// setActive enables or disables an account. Disabled accounts lose their
+// calendar tokens, which would otherwise keep working without a login.
+func setActive(db *gorm.DB, user *models.User, active bool) error {
+	if !active {
+		if err := checkOtherAdmin(db, *user); err != nil {
+			return err
+		}
+		if err := db.Where("created_by = ?", user.Username).Delete(&models.CalendarToken{}).Error; err != nil {
+			return err
+		}
+	}
+	user.Active = active
+	return db.Model(user).Update("active", active).Error
+}
+
+// setEmployee links the account to an employee, or unlinks it when
+// employeeID is nil. An employee has one account at most.
+func setEmployee(db *gorm.DB, user *models.User, employeeID *uint) error {
+	if employeeID != nil {
+		var employee models.Employee
+		if err := db.First(&employee, *employeeID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrEmployeeNotFound
+			}
+			return err
+		}
+
+		var count int64
+		if err := db.Model(&models.User{}).
+			Where("employee_id = ? AND id <> ?", *employeeID, user.ID).
+			Count(&count).Error; err != nil {
+			return err
+		}
+		if count > 0 {
+			return ErrEmployeeLinked
+		}
+	}
+
+	user.EmployeeID = employeeID
+	return db.Model(user).Update("employee_id", employeeID).Error
+}
+
+// ResetPassword replaces the password of an account.
+func ResetPassword(db *gorm.DB, username, password string) (models.User, error) {
+	user, err := findUser(db, username)
+	if err != nil {
+		return user, err
+	}
+	return user, setPassword(db, &user, password)
+}
+
+// SetRole changes the role of an account.
+func SetRole(db *gorm.DB, username, role string) (models.User, error) {
+	user, err := findUser(db, username)
+	if err != nil {
+		return user, err
+	}
+	return user, setRole(db, &user, role)
+}
+
+// userErrorStatus maps the errors of the account operations to a status.
+func userErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, ErrUserNotFound), errors.Is(err, ErrEmployeeNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, ErrUserExists), errors.Is(err, ErrLastAdmin), errors.Is(err, ErrOwnAccount),
+		errors.Is(err, ErrEmployeeLinked):
+		return http.StatusConflict
+	case errors.Is(err, ErrUsernameRequired), errors.Is(err, ErrInvalidRole), errors.Is(err, ErrPasswordTooShort):
+		return http.StatusBadRequest
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+func (h *Handler) respondWithUserError(c *gin.Context, err error, fallback string) {
+	code := userErrorStatus(err)
+	if code == http.StatusInternalServerError {
+		h.respondWithError(c, code, fallback)
+		return
+	}
+	h.respondWithError(c, code, err.Error())
+}
+
+func (h *Handler) GetUsers(c *gin.Context) {
+	query := h.DB.Order("username")
+	if active := c.Query("active"); active != "" {
+		query = query.Where("active = ?", active == "true")
+	}
+
+	var users []models.User
+	if err := query.Find(&users).Error; err != nil {
+		h.respondWithError(c, http.StatusInternalServerError, "Failed to fetch users")
+		return
+	}
+
+	response := make([]userResponse, 0, len(users))
+	for _, user := range users {
+		response = append(response, newUserResponse(user))
+	}
+	h.respondWithSuccess(c, http.StatusOK, response)
+}
+
+func (h *Handler) AddUser(c *gin.Context) {
+	var input struct {
+		Username string `json:"username" binding:"required"`
+		Password string `json:"password" binding:"required"`
+		Role     string `json:"role" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&input); err != nil {
+		h.respondWithError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	var user models.User
+	err := h.DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		if user, err = CreateUser(tx, input.Username, input.Password, input.Role); err != nil {
+			return err
+		}
+		return h.audit(c, tx, AuditCreate, "user", user.ID, nil, newUserResponse(user))
+	})
+	if err != nil {
+		h.respondWithUserError(c, err, "Failed to create user")
+		return
+	}
+
+	h.respondWithSuccess(c, http.StatusCreated, newUserResponse(user))
+}
+
+// updateUser loads the user of the id parameter and applies change to it in
+// a transaction, auditing the user before and after.
+func (h *Handler) updateUser(c *gin.Context, change func(tx *gorm.DB, user *models.User) error) {
+	var user models.User
+	if err := h.DB.First(&user, c.Param("id")).Error; err != nil {
+		h.respondWithError(c, http.StatusNotFound, "User not found")
+		return
+	}
+
+	before := newUserResponse(user)
+	err := h.DB.Transaction(func(tx *gorm.DB) error {
+		if err := change(tx, &user); err != nil {
+			return err
+		}
+		return h.audit(c, tx, AuditUpdate, "user", user.ID, before, newUserResponse(user))
+	})
+	if err != nil {
+		h.respondWithUserError(c, err, "Failed to update user")
+		return
+	}
+
+	h.respondWithSuccess(c, http.StatusOK, newUserResponse(user))
+}
+
+// UpdateUser assigns the role of an account.
+func (h *Handler) UpdateUser(c *gin.Context) {
+	var input struct {
+		Role string `json:"role" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&input); err != nil {
+		h.respondWithError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	h.updateUser(c, func(tx *gorm.DB, user *models.User) error {
+		return setRole(tx, user, input.Role)
+	})
+}
+
+// ResetUserPassword sets a new password chosen by the admin. The audit log
+// records the reset, never the password.
+func (h *Handler) ResetUserPassword(c *gin.Context) {
+	var input struct {
+		Password string `json:"password" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&input); err != nil {
+		h.respondWithError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	h.updateUser(c, func(tx *gorm.DB, user *models.User) error {
+		return setPassword(tx, user, input.Password)
+	})
+}
+
+// LinkUserEmployee links an account to the employee whose planning,
+// absences and shift swaps it manages.
+func (h *Handler) LinkUserEmployee(c *gin.Context) {
+	var input struct {
+		EmployeeID uint `json:"employee_id" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&input); err != nil {
+		h.respondWithError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	h.updateUser(c, func(tx *gorm.DB, user *models.User) error {
+		return setEmployee(tx, user, &input.EmployeeID)
+	})
+}
+
+func (h *Handler) UnlinkUserEmployee(c *gin.Context) {
+	h.updateUser(c, func(tx *gorm.DB, user *models.User) error {
+		return setEmployee(tx, user, nil)
+	})
+}
+
+// DeactivateUser disables an account: it can no longer log in and its
+// current tokens are refused.
+func (h *Handler) DeactivateUser(c *gin.Context) {
+	h.updateUser(c, func(tx *gorm.DB, user *models.User) error {
+		if user.Username == c.GetString("username") {
+			return ErrOwnAccount
+		}
+		return setActive(tx, user, false)
+	})
+}
+
+func (h *Handler) ActivateUser(c *gin.Context) {
+	h.updateUser(c, func(tx *gorm.DB, user *models.User) error {
+		return setActive(tx, user, true)
+	})
+}
+
+func (h *Handler) DeleteUser(c *gin.Context) {
+	var user models.User
+	if err := h.DB.First(&user, c.Param("id")).Error; err != nil {
+		h.respondWithError(c, http.StatusNotFound, "User not found")
+		return
+	}
+
+	if user.Username == c.GetString("username") {
+		h.respondWithError(c, http.StatusConflict, "You cannot delete your own account")
+		return
+	}
+
+	before := newUserResponse(user)
+	err := h.DB.Transaction(func(tx *gorm.DB) error {
+		if err := setActive(tx, &user, false); err != nil {
+			return err
+		}
+		// Free the employee for another account
+		if err := setEmployee(tx, &user, nil); err != nil {
+			return err
+		}
+		if err := tx.Delete(&user).Error; err != nil {
+			return err
+		}
+		return h.audit(c, tx, AuditDelete, "user", user.ID, before, nil)
+	})
+	if err != nil {
+		h.respondWithUserError(c, err, "Failed to delete user")
+		return
+	}
+
+	h.respondWithSuccess(c, http.StatusOK, gin.H{"message": "User deleted successfully"})
+}
+
+// ActiveUserMiddleware refuses the tokens of accounts deleted or deactivated
+// since they logged in, and applies the role the account has now rather than
+// the one in the token.
+func (h *Handler) ActiveUserMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var user models.User
+		if err := h.DB.Where("username = ?", c.GetString("username")).First(&user).Error; err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Account not found"})
+			c.Abort()
+			return
+		}
+		if !user.Active {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Account disabled"})
+			c.Abort()
+			return
+		}
+		c.Set("role", user.Role)
+		c.Next()
+	}
+}
@@ -0,0 +1,260 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"planning_hager/models"
+)
+
+const (
+	RegimeLong   = "4x8 L"
+	RegimeNormal = "4x8 N"
+	RegimeShort  = "4x8 C"
+)
+
+var ValidRegimes = []string{
+	RegimeLong,
+	RegimeNormal,
+	RegimeShort,
+}
+
+// Shift definitions the regimes add on weekends
+const (
+	WeekendMorningShift = "M"
+	WeekendNightShift   = "N"
+)
+
+type weekendShift struct {
+	Day   int
+	Shift string
+	CEID  uint
+}
+
+// regimeWeekendShifts returns the weekend shifts a regime adds on top of the
+// rotation pattern: a long week covers Saturday morning and Sunday night, a
+// normal week Saturday morning only and a short week nothing.
+func regimeWeekendShifts(pattern models.RotationPattern, regime string, week int) []weekendShift {
+	saturdayCEID, sundayCEID := weekendCEs(pattern, week)
+
+	var shifts []weekendShift
+	switch regime {
+	case RegimeLong:
+		shifts = append(shifts,
+			weekendShift{Day: 5, Shift: WeekendMorningShift, CEID: saturdayCEID},
+			weekendShift{Day: 6, Shift: WeekendNightShift, CEID: sundayCEID},
+		)
+	case RegimeNormal:
+		shifts = append(shifts, weekendShift{Day: 5, Shift: WeekendMorningShift, CEID: saturdayCEID})
+	}
+
+	// The rotation pattern may leave a weekend shift without a CE
+	result := shifts[:0]
+	for _, shift := range shifts {
+		if shift.CEID != 0 {
+			result = append(result, shift)
+		}
+	}
+	return result
+}
+
+// validateRegimeShifts checks that the weekend shifts a regime adds have been
+// defined.
+func validateRegimeShifts(db *gorm.DB, regime string) error {
+	var codes []string
+	switch regime {
+	case RegimeLong:
+		codes = []string{WeekendMorningShift, WeekendNightShift}
+	case RegimeNormal:
+		codes = []string{WeekendMorningShift}
+	}
+	for _, code := range codes {
+		if err := validateShiftCode(db, code); err != nil {
+			return fmt.Errorf("regime %s: %w", regime, err)
+		}
+	}
+	return nil
+}
+
+func isValidRegime(regime string) bool {
+	for _, r := range ValidRegimes {
+		if r == regime {
+			return true
+		}
+	}
+	return false
+}
+
+func weekRegimesByWeek(tx *gorm.DB, year, fromWeek, toWeek int) (map[int]string, error) {
+	var regimes []models.WeekRegime
+	if err := tx.Where("year = ? AND week >= ? AND week <= ?", year, fromWeek, toWeek).
+		Find(&regimes).Error; err != nil {
+		return nil, err
+	}
+
+	byWeek := make(map[int]string, len(regimes))
+	for _, r := range regimes {
+		byWeek[r.Week] = r.Regime
+	}
+	return byWeek, nil
+}
+
+// setWeekRegime stores the regime of a week and regenerates its planning,
+// recording both as one change set.
+func (h *Handler) setWeekRegime(c *gin.Context, tx *gorm.DB, year, week int, regime string) (models.WeekRegime, planningDiff, models.ChangeSet, error) {
+	var weekRegime models.WeekRegime
+	err := tx.Where("year = ? AND week = ?", year, week).First(&weekRegime).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return weekRegime, planningDiff{}, models.ChangeSet{}, err
+	}
+
+	var before interface{}
+	action := AuditCreate
+	if weekRegime.ID != 0 {
+		before = weekRegime
+		action = AuditUpdate
+	}
+
+	weekRegime.Year = year
+	weekRegime.Week = week
+	weekRegime.Regime = regime
+	if err := tx.Save(&weekRegime).Error; err != nil {
+		return weekRegime, planningDiff{}, models.ChangeSet{}, err
+	}
+
+	if err := h.audit(c, tx, action, "week_regime", weekRegime.ID, before, weekRegime); err != nil {
+		return weekRegime, planningDiff{}, models.ChangeSet{}, err
+	}
+
+	diff, err := h.reconcilePlanning(tx, year, week, week)
+	if err != nil {
+		return weekRegime, diff, models.ChangeSet{}, err
+	}
+
+	if err := applyPlanningDiff(tx, &diff); err != nil {
+		return weekRegime, diff, models.ChangeSet{}, err
+	}
+
+	if err := h.auditPlanningDiff(c, tx, diff); err != nil {
+		return weekRegime, diff, models.ChangeSet{}, err
+	}
+
+	regimeItem, err := changeSetItem("week_regime", action, weekRegime.ID, before, weekRegime)
+	if err != nil {
+		return weekRegime, diff, models.ChangeSet{}, err
+	}
+	items, err := planningChangeSetItems(diff)
+	if err != nil {
+		return weekRegime, diff, models.ChangeSet{}, err
+	}
+	changeSet, err := h.recordChangeSet(c, tx, ChangeSetWeekRegime,
+		fmt.Sprintf("Week %d-W%02d set to %s", year, week, regime), append([]models.ChangeSetItem{regimeItem}, items...))
+	return weekRegime, diff, changeSet, err
+}
+
+func (h *Handler) GetWeekRegime(c *gin.Context) {
+	year, week, err := parseISOWeek(c.Query("year"), c.Query("week"))
+	if err != nil {
+		h.respondWithError(c, http.StatusBadRequest, "Invalid year or week parameter")
+		return
+	}
+
+	var weekRegime models.WeekRegime
+	if err := h.DB.Where("year = ? AND week = ?", year, week).First(&weekRegime).Error; err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			h.respondWithError(c, http.StatusInternalServerError, "Failed to fetch week regime")
+			return
+		}
+		weekRegime = models.WeekRegime{Year: year, Week: week}
+	}
+
+	h.respondWithSuccess(c, http.StatusOK, weekRegime)
+}
+
+func (h *Handler) GetWeekRegimes(c *gin.Context) {
+	year, _ := time.Now().ISOWeek()
+	if yearStr := c.Query("year"); yearStr != "" {
+		var err error
+		if year, err = strconv.Atoi(yearStr); err != nil {
+			h.respondWithError(c, http.StatusBadRequest, "Invalid year parameter")
+			return
+		}
+	}
+
+	var regimes []models.WeekRegime
+	if err := h.DB.Where("year = ?", year).Order("week ASC").Find(&regimes).Error; err != nil {
+		h.respondWithError(c, http.StatusInternalServerError, "Failed to fetch week regimes")
+		return
+	}
+
+	h.respondWithSuccess(c, http.StatusOK, regimes)
+}
+
+func (h *Handler) SetWeekRegime(c *gin.Context) {
+	var input struct {
+		Year   int    `json:"year" binding:"required"`
+		Week   int    `json:"week" binding:"required"`
+		Regime string `json:"regime" binding:"required"`
+
+		OverrideReason string `json:"override_reason"`
+	}
+
+	if err := c.ShouldBindJSON(&input); err != nil {
+		h.respondWithError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if !isValidISOWeek(input.Year, input.Week) {
+		h.respondWithError(c, http.StatusBadRequest, "Invalid week parameter")
+		return
+	}
+	if !isValidRegime(input.Regime) {
+		h.respondWithError(c, http.StatusBadRequest, "Invalid regime")
+		return
+	}
+	if err := validateRegimeShifts(h.DB, input.Regime); err != nil {
+		h.respondWithError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	tx := h.DB.Begin()
+
+	from, to := isoWeekRange(input.Year, input.Week)
+	if !h.checkPlanningUnlocked(c, tx, from, to, overrideReason(c, input.OverrideReason)) {
+		return
+	}
+	baseline, ok := h.startLabourCheck(c, tx, from, to)
+	if !ok {
+		return
+	}
+
+	weekRegime, diff, changeSet, err := h.setWeekRegime(c, tx, input.Year, input.Week, input.Regime)
+	if err != nil {
+		tx.Rollback()
+		h.respondWithError(c, http.StatusInternalServerError, "Failed to update week regime")
+		return
+	}
+
+	violations, ok := h.checkLabourRules(c, tx, baseline, planningEmployeeIDs(diff.touched()), from, to)
+
+	if !ok {
+		return
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		h.respondWithError(c, http.StatusInternalServerError, "Failed to commit changes")
+		return
+	}
+
+	h.respondWithSuccess(c, http.StatusOK, gin.H{
+		"regime":        weekRegime,
+		"summary":       diff.summary(),
+		"violations":    violations,
+		"change_set_id": changeSet.ID,
+	})
+}
@@ -0,0 +1,75 @@
+package handlers
+
+import (
+	"testing"
+
+	"planning_hager/models"
+)
+
+func TestReconcilePlanningFollowsRegime(t *testing.T) {
+	db := newTestDB(t)
+	h := NewHandler(db)
+	seedTeams(t, db)
+
+	// The default rotation already works every weekend, this one never does
+	pattern := models.RotationPattern{
+		Name:          "Weekdays only",
+		CycleLength:   1,
+		Weeks:         [][]string{{"M", "M", "M", "M", "M", "", ""}},
+		CESlots:       []uint{1, 2, 3, 4},
+		SaturdaySlots: []int{0},
+		SundaySlots:   []int{1},
+		Active:        true,
+	}
+	if err := db.Create(&pattern).Error; err != nil {
+		t.Fatalf("creating rotation pattern: %v", err)
+	}
+	reconcileTestWeek(t, h, db)
+
+	setRegime := func(regime string) {
+		t.Helper()
+		weekRegime := models.WeekRegime{Year: testYear, Week: testWeek}
+		if err := db.Where(weekRegime).Assign(models.WeekRegime{Regime: regime}).FirstOrCreate(&weekRegime).Error; err != nil {
+			t.Fatalf("setting regime: %v", err)
+		}
+	}
+
+	// A long week adds Saturday morning and Sunday night
+	setRegime(RegimeLong)
+	diff := reconcileTestWeek(t, h, db)
+	if len(diff.Created) == 0 || len(diff.Updated) != 0 || len(diff.Deleted) != 0 {
+		t.Fatalf("reconciliation of a long week = %v, want only created rows", diff.summary())
+	}
+	saturday, sunday := isoWeekStart(testYear, testWeek).AddDate(0, 0, 5), isoWeekStart(testYear, testWeek).AddDate(0, 0, 6)
+	for _, p := range diff.Created {
+		onSaturday := p.Date.Equal(saturday) && p.Shift == WeekendMorningShift
+		onSunday := p.Date.Equal(sunday) && p.Shift == WeekendNightShift
+		if p.Source != PlanningSourceRegime || !(onSaturday || onSunday) {
+			t.Errorf("long week created %s with source %q", planningKey(p), p.Source)
+		}
+	}
+
+	// A short week removes them again
+	setRegime(RegimeShort)
+	removed := reconcileTestWeek(t, h, db)
+	if len(removed.Deleted) != len(diff.Created) || len(removed.Created) != 0 || len(removed.Updated) != 0 {
+		t.Fatalf("reconciliation of a short week = %v, want the %d regime rows deleted", removed.summary(), len(diff.Created))
+	}
+}
+
+func TestReconcilePlanningNeedsRegimeShifts(t *testing.T) {
+	db := newTestDB(t)
+	h := NewHandler(db)
+	seedTeams(t, db)
+
+	if err := db.Create(&models.WeekRegime{Year: testYear, Week: testWeek, Regime: RegimeLong}).Error; err != nil {
+		t.Fatalf("setting regime: %v", err)
+	}
+	if err := db.Where("code = ?", WeekendNightShift).Delete(&models.ShiftDefinition{}).Error; err != nil {
+		t.Fatalf("deleting shift definition: %v", err)
+	}
+
+	if _, err := h.reconcilePlanning(db, testYear, testWeek, testWeek); err == nil {
+		t.Errorf("reconcilePlanning generated a long week without a %s shift definition", WeekendNightShift)
+	}
+}
@@ -0,0 +1,142 @@
+package handlers
+
+import (
+	"sort"
+	"time"
+
+	"gorm.io/gorm"
+	"planning_hager/models"
+)
+
+// workedShift is a shift somebody actually works, either on their own
+// planning entry or as the substitute of someone else.
+type workedShift struct {
+	PlanningID uint
+	Shift      string
+	Night      bool
+	Start      time.Time
+	End        time.Time
+}
+
+func (w workedShift) hours() float64 {
+	return w.End.Sub(w.Start).Hours()
+}
+
+func isAbsence(status string) bool {
+	return status == StatusAbsentP || status == StatusAbsentU
+}
+
+// employeeWorkedShifts returns, per employee, the shifts worked between from
+// (inclusive) and to (exclusive), sorted by start time. Shifts without a
+// definition are ignored since their hours are unknown.
+func employeeWorkedShifts(db *gorm.DB, employeeIDs []uint, from, to time.Time) (map[uint][]workedShift, error) {
+	worked := make(map[uint][]workedShift)
+	if len(employeeIDs) == 0 {
+		return worked, nil
+	}
+
+	shifts, err := loadShiftDefinitions(db)
+	if err != nil {
+		return nil, err
+	}
+
+	var plannings []models.Planning
+	if err := db.Where("date >= ? AND date < ?", from, to).
+		Where("employee_id IN ? OR substitute_id IN ?", employeeIDs, employeeIDs).
+		Find(&plannings).Error; err != nil {
+		return nil, err
+	}
+
+	for _, p := range plannings {
+		shift, ok := plannedShift(shifts, p)
+		if !ok {
+			continue
+		}
+		if p.EmployeeID != nil && !isAbsence(p.Status) {
+			worked[*p.EmployeeID] = append(worked[*p.EmployeeID], shift)
+		}
+		if p.SubstituteID != nil {
+			worked[*p.SubstituteID] = append(worked[*p.SubstituteID], shift)
+		}
+	}
+
+	sortWorkedShifts(worked)
+	return worked, nil
+}
+
+// reservistWorkedShifts returns, per reservist, the shifts worked as a
+// substitute between from (inclusive) and to (exclusive), sorted by start
+// time.
+func reservistWorkedShifts(db *gorm.DB, reservistIDs []uint, from, to time.Time) (map[uint][]workedShift, error) {
+	worked := make(map[uint][]workedShift)
+	if len(reservistIDs) == 0 {
+		return worked, nil
+	}
+
+	shifts, err := loadShiftDefinitions(db)
+	if err != nil {
+		return nil, err
+	}
+
+	var plannings []models.Planning
+	if err := db.Where("date >= ? AND date < ?", from, to).
+		Where("substitute_reservist_id IN ?", reservistIDs).
+		Find(&plannings).Error; err != nil {
+		return nil, err
+	}
+
+	for _, p := range plannings {
+		if shift, ok := plannedShift(shifts, p); ok {
+			worked[*p.SubstituteReservistID] = append(worked[*p.SubstituteReservistID], shift)
+		}
+	}
+
+	sortWorkedShifts(worked)
+	return worked, nil
+}
+
+// plannedShift returns the shift of a planning entry, and false when the shift
+// has no definition.
+func plannedShift(shifts map[string]models.ShiftDefinition, p models.Planning) (workedShift, bool) {
+	definition, ok := shifts[p.Shift]
+	if !ok {
+		return workedShift{}, false
+	}
+	start, end := shiftBounds(definition, p.Date)
+	return workedShift{PlanningID: p.ID, Shift: p.Shift, Night: definition.CrossesMidnight, Start: start, End: end}, true
+}
+
+func sortWorkedShifts(worked map[uint][]workedShift) {
+	for id := range worked {
+		sort.Slice(worked[id], func(i, j int) bool {
+			return worked[id][i].Start.Before(worked[id][j].Start)
+		})
+	}
+}
+
+// restAround returns the rest available before and after the given slot, and
+// whether it overlaps a shift already worked. Without a neighbouring shift the
+// rest is reported as -1.
+func restAround(worked []workedShift, start, end time.Time, ignorePlanningID uint) (before, after time.Duration, overlaps bool) {
+	before, after = -1, -1
+	for _, w := range worked {
+		if w.PlanningID == ignorePlanningID {
+			continue
+		}
+		if w.Start.Before(end) && start.Before(w.End) {
+			overlaps = true
+			continue
+		}
+		if !w.End.After(start) {
+			if gap := start.Sub(w.End); before < 0 || gap < before {
+				before = gap
+			}
+		}
+		if !w.Start.Before(end) {
+			if gap := w.Start.Sub(end); after < 0 || gap < after {
+				after = gap
+			}
+		}
+	}
+	return before, after, overlaps
+}
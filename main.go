@@ -3,7 +3,6 @@ package main
 import (
 	"fmt"
 	"github.com/joho/godotenv"
-	"gorm.io/driver/sqlserver"
 	"gorm.io/gorm"
 	"log"
 	"os"
@@ -14,7 +13,30 @@ import (
 
 var db *gorm.DB
 
-// Add this function to your main.go file
+const usage = `usage: planning_hager [command] [arguments]
+
+commands:
+  serve                                        start the API server (default)
+  migrate up | down [steps] | status           manage the database schema
+  user create -username NAME -role ROLE        create an account
+  user reset-password -username NAME           set a new password
+  user set-role -username NAME -role ROLE      change the role of an account
+  seed                                         create the default shifts and leave types
+  planning populate -year YEAR [-dry-run] [-reason TEXT]
+                                               generate the yearly planning
+
+Passwords are read from standard input unless -password is given.
+`
+
+// commands run against the database opened from the environment.
+var commands = map[string]func(db *gorm.DB, args []string) error{
+	"serve":    runServe,
+	"migrate":  runMigrate,
+	"user":     runUser,
+	"seed":     runSeed,
+	"planning": runPlanning,
+}
+
 func getCurrentDirectory() string {
 	dir, err := os.Getwd()
 	if err != nil {
@@ -25,33 +47,47 @@ func getCurrentDirectory() string {
 }
 
 func main() {
-	// At the beginning of your main() function
 	if err := godotenv.Load(); err != nil {
 		log.Printf("Error loading .env file: %v", err)
 		log.Println("Current working directory:", getCurrentDirectory())
 		log.Println("Falling back to environment variables")
 	}
 
-	// Azure SQL Database connection details
-	server := os.Getenv("DB_SERVER")
-	port := os.Getenv("DB_PORT")
-	user := os.Getenv("DB_USER")
-	password := os.Getenv("DB_PASSWORD")
-	database := os.Getenv("DB_NAME")
-
-	// Build connection string
-	connString := fmt.Sprintf("server=%s;user id=%s;password=%s;port=%s;database=%s;",
-		server, user, password, port, database)
+	name, args := "serve", []string{}
+	if len(os.Args) > 1 {
+		name, args = os.Args[1], os.Args[2:]
+	}
+	if name == "help" || name == "-h" || name == "--help" {
+		fmt.Print(usage)
+		return
+	}
+	command, ok := commands[name]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n%s", name, usage)
+		os.Exit(2)
+	}
 
-	// Create connection pool
+	// Connect to the database selected by DB_DRIVER
 	var err error
-	db, err = gorm.Open(sqlserver.Open(connString), &gorm.Config{})
+	db, err = config.OpenDatabase()
 	if err != nil {
 		log.Fatal("Error creating connection pool: ", err.Error())
 	}
 
-	// Run database migrations
-	config.MigrateDB(db)
+	if err := command(db, args); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func runServe(db *gorm.DB, args []string) error {
+	if len(args) > 0 {
+		return fmt.Errorf("usage: planning_hager serve")
+	}
+
+	// Refuse to serve on a schema that failed to migrate
+	if err := config.MigrateDB(db); err != nil {
+		return fmt.Errorf("migrating database: %w", err)
+	}
 
 	// Initialize router
 	r := routes.SetupRouter(db)
@@ -63,6 +99,20 @@ func main() {
 	}
 	log.Printf("Server starting on port %s", serverPort)
 	if err := r.Run(":" + serverPort); err != nil {
-		log.Fatal("Error starting server: ", err.Error())
+		return fmt.Errorf("starting server: %w", err)
+	}
+	return nil
+}
+
+// runSeed creates the reference data a fresh database needs, leaving it alone
+// when it already exists.
+func runSeed(db *gorm.DB, args []string) error {
+	if len(args) > 0 {
+		return fmt.Errorf("usage: planning_hager seed")
+	}
+	if err := config.SeedDB(db); err != nil {
+		return err
 	}
+	fmt.Println("reference data seeded")
+	return nil
 }
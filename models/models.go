@@ -63,13 +63,26 @@ type Planning struct {
 	Status       string
 	SubstituteID *uint
 	Substitute   *Employee `gorm:"foreignKey:SubstituteID"`
+	Source       string
+
+	SubstituteReservistID *uint
+	SubstituteReservist   *Reservist `gorm:"foreignKey:SubstituteReservistID"`
+
+	AbsenceRequestID *uint `gorm:"index"`
+	LeaveType        string
 }
 
 type User struct {
 	gorm.Model
 	Username string `gorm:"unique;not null"`
-	Password string `gorm:"not null"`
+	Password string `gorm:"not null" json:"-"`
 	Role     string `gorm:"not null"`
+	Active   bool   `gorm:"not null;default:true"`
+
+	// EmployeeID links the account to the employee whose planning, absences
+	// and swaps it manages, with at most one account per employee
+	EmployeeID *uint     `gorm:"uniqueIndex:idx_users_employee_id,where:employee_id IS NOT NULL"`
+	Employee   *Employee `gorm:"constraint:OnDelete:SET NULL"`
 }
 
 type Claims struct {
@@ -84,10 +97,208 @@ type SectorRequiredSkill struct {
 }
 
 type Reservist struct {
-	ID        uint           `gorm:"primaryKey" json:"id"`
-	Name      string         `gorm:"not null" json:"name"`
-	Skills    []Skill        `gorm:"many2many:reservist_skills;" json:"skills"`
-	CreatedAt time.Time      `json:"created_at"`
-	UpdatedAt time.Time      `json:"updated_at"`
-	DeletedAt gorm.DeletedAt `gorm:"index" json:"deleted_at"`
+	ID             uint                    `gorm:"primaryKey" json:"id"`
+	Name           string                  `gorm:"not null" json:"name"`
+	Skills         []Skill                 `gorm:"many2many:reservist_skills;" json:"skills"`
+	Availabilities []ReservistAvailability `gorm:"foreignKey:ReservistID" json:"availabilities"`
+	CreatedAt      time.Time               `json:"created_at"`
+	UpdatedAt      time.Time               `json:"updated_at"`
+	DeletedAt      gorm.DeletedAt          `gorm:"index" json:"deleted_at"`
+}
+
+type RotationPattern struct {
+	ID            uint           `gorm:"primaryKey" json:"id"`
+	Name          string         `gorm:"not null" json:"name"`
+	CycleLength   int            `gorm:"not null" json:"cycle_length"`
+	Weeks         [][]string     `gorm:"serializer:json" json:"weeks"`
+	CESlots       []uint         `gorm:"serializer:json" json:"ce_slots"`
+	SaturdaySlots []int          `gorm:"serializer:json" json:"saturday_slots"`
+	SundaySlots   []int          `gorm:"serializer:json" json:"sunday_slots"`
+	Active        bool           `json:"active"`
+	CreatedAt     time.Time      `json:"created_at"`
+	UpdatedAt     time.Time      `json:"updated_at"`
+	DeletedAt     gorm.DeletedAt `gorm:"index" json:"deleted_at"`
+}
+
+type WeekRegime struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	Year      int       `gorm:"not null;uniqueIndex:idx_week_regime_year_week" json:"year"`
+	Week      int       `gorm:"not null;uniqueIndex:idx_week_regime_year_week" json:"week"`
+	Regime    string    `gorm:"not null" json:"regime"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+type ShiftDefinition struct {
+	ID              uint      `gorm:"primaryKey" json:"id"`
+	Code            string    `gorm:"size:10;not null;uniqueIndex" json:"code"`
+	Label           string    `gorm:"not null" json:"label"`
+	StartTime       string    `gorm:"size:5;not null" json:"start_time"`
+	EndTime         string    `gorm:"size:5;not null" json:"end_time"`
+	CrossesMidnight bool      `json:"crosses_midnight"`
+	Color           string    `json:"color"`
+	CreatedAt       time.Time `json:"created_at"`
+	UpdatedAt       time.Time `json:"updated_at"`
+}
+
+type ReservistAvailability struct {
+	ID               uint           `gorm:"primaryKey" json:"id"`
+	ReservistID      uint           `gorm:"not null;index" json:"reservist_id"`
+	StartDate        time.Time      `gorm:"not null" json:"start_date"`
+	EndDate          time.Time      `gorm:"not null" json:"end_date"`
+	Shifts           []string       `gorm:"serializer:json" json:"shifts"`
+	MaxShiftsPerWeek int            `json:"max_shifts_per_week"`
+	CreatedAt        time.Time      `json:"created_at"`
+	UpdatedAt        time.Time      `json:"updated_at"`
+	DeletedAt        gorm.DeletedAt `gorm:"index" json:"deleted_at"`
+}
+
+type LabourRules struct {
+	ID                   uint      `gorm:"primaryKey" json:"id"`
+	MinRestHours         float64   `json:"min_rest_hours"`
+	MaxConsecutiveDays   int       `json:"max_consecutive_days"`
+	MaxNightShifts       int       `json:"max_night_shifts"`
+	NightShiftPeriodDays int       `json:"night_shift_period_days"`
+	WeeklyHourCap        float64   `json:"weekly_hour_cap"`
+	Enforcement          string    `gorm:"size:10;not null" json:"enforcement"`
+	CreatedAt            time.Time `json:"created_at"`
+	UpdatedAt            time.Time `json:"updated_at"`
+}
+
+type AbsenceRequest struct {
+	ID            uint       `gorm:"primaryKey" json:"id"`
+	EmployeeID    uint       `gorm:"not null;index" json:"employee_id"`
+	Employee      *Employee  `gorm:"foreignKey:EmployeeID" json:"employee,omitempty"`
+	StartDate     time.Time  `gorm:"not null" json:"start_date"`
+	EndDate       time.Time  `gorm:"not null" json:"end_date"`
+	Reason        string     `gorm:"size:255" json:"reason"`
+	LeaveType     string     `gorm:"size:20" json:"leave_type"`
+	State         string     `gorm:"size:20;not null;index" json:"state"`
+	RequestedBy   string     `gorm:"size:50" json:"requested_by"`
+	ReviewedBy    string     `gorm:"size:50" json:"reviewed_by"`
+	ReviewedAt    *time.Time `json:"reviewed_at"`
+	ReviewComment string     `gorm:"size:255" json:"review_comment"`
+	CreatedAt     time.Time  `json:"created_at"`
+	UpdatedAt     time.Time  `json:"updated_at"`
+}
+
+// LeaveAccrualRule sets what a leave type grants each year. CarryOverMax caps
+// the balance carried to the next year; a negative value carries all of it.
+type LeaveAccrualRule struct {
+	ID           uint      `gorm:"primaryKey" json:"id"`
+	Type         string    `gorm:"size:20;uniqueIndex" json:"type"`
+	Label        string    `gorm:"size:50" json:"label"`
+	Unit         string    `gorm:"size:10;not null" json:"unit"`
+	YearlyAmount float64   `json:"yearly_amount"`
+	CarryOverMax float64   `json:"carry_over_max"`
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
+
+// LeaveMovement is one line of an employee's leave account for a type and a
+// year. Debits coming from the planning keep the planning entry they belong
+// to.
+type LeaveMovement struct {
+	ID         uint      `gorm:"primaryKey" json:"id"`
+	EmployeeID uint      `gorm:"not null;index" json:"employee_id"`
+	Type       string    `gorm:"size:20;not null" json:"type"`
+	Year       int       `gorm:"not null;index" json:"year"`
+	Kind       string    `gorm:"size:20;not null" json:"kind"`
+	Amount     float64   `json:"amount"`
+	PlanningID *uint     `gorm:"index" json:"planning_id"`
+	Note       string    `gorm:"size:255" json:"note"`
+	CreatedBy  string    `gorm:"size:50" json:"created_by"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+type ShiftSwapRequest struct {
+	ID                  uint       `gorm:"primaryKey" json:"id"`
+	RequesterID         uint       `gorm:"not null;index" json:"requester_id"`
+	Requester           *Employee  `gorm:"foreignKey:RequesterID" json:"requester,omitempty"`
+	RequesterPlanningID uint       `gorm:"not null" json:"requester_planning_id"`
+	RequesterPlanning   *Planning  `gorm:"foreignKey:RequesterPlanningID" json:"requester_planning,omitempty"`
+	ColleagueID         uint       `gorm:"not null;index" json:"colleague_id"`
+	Colleague           *Employee  `gorm:"foreignKey:ColleagueID" json:"colleague,omitempty"`
+	ColleaguePlanningID uint       `gorm:"not null" json:"colleague_planning_id"`
+	ColleaguePlanning   *Planning  `gorm:"foreignKey:ColleaguePlanningID" json:"colleague_planning,omitempty"`
+	Message             string     `gorm:"size:255" json:"message"`
+	State               string     `gorm:"size:20;not null;index" json:"state"`
+	RespondedAt         *time.Time `json:"responded_at"`
+	ReviewedBy          string     `gorm:"size:50" json:"reviewed_by"`
+	ReviewedAt          *time.Time `json:"reviewed_at"`
+	ReviewComment       string     `gorm:"size:255" json:"review_comment"`
+	CreatedAt           time.Time  `json:"created_at"`
+	UpdatedAt           time.Time  `json:"updated_at"`
+}
+
+type WeekPublication struct {
+	ID          uint       `gorm:"primaryKey" json:"id"`
+	Year        int        `gorm:"not null;uniqueIndex:idx_week_publication_year_week" json:"year"`
+	Week        int        `gorm:"not null;uniqueIndex:idx_week_publication_year_week" json:"week"`
+	State       string     `gorm:"size:20;not null" json:"state"`
+	PublishedBy string     `gorm:"size:50" json:"published_by"`
+	PublishedAt *time.Time `json:"published_at"`
+	LockedBy    string     `gorm:"size:50" json:"locked_by"`
+	LockedAt    *time.Time `json:"locked_at"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+}
+
+// AuditLog records one change made through the API. Before and After hold the
+// JSON of the entity, empty when it did not exist.
+type AuditLog struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	Username  string    `gorm:"size:50;index" json:"username"`
+	Action    string    `gorm:"size:30;not null" json:"action"`
+	Entity    string    `gorm:"size:50;not null;index:idx_audit_entity" json:"entity"`
+	EntityID  uint      `gorm:"index:idx_audit_entity" json:"entity_id"`
+	Before    string    `json:"before"`
+	After     string    `json:"after"`
+	Reason    string    `gorm:"size:255" json:"reason"`
+	CreatedAt time.Time `gorm:"index" json:"created_at"`
+}
+
+// ChangeSet groups the rows changed by one planning operation, so the
+// operation can be reverted as a whole.
+type ChangeSet struct {
+	ID                uint            `gorm:"primaryKey" json:"id"`
+	Operation         string          `gorm:"size:50;not null;index" json:"operation"`
+	Description       string          `gorm:"size:255" json:"description"`
+	CreatedBy         string          `gorm:"size:50" json:"created_by"`
+	RevertedBy        string          `gorm:"size:50" json:"reverted_by"`
+	RevertedAt        *time.Time      `json:"reverted_at"`
+	RevertChangeSetID *uint           `json:"revert_change_set_id"`
+	Items             []ChangeSetItem `json:"items,omitempty"`
+	CreatedAt         time.Time       `gorm:"index" json:"created_at"`
+}
+
+// ChangeSetItem holds one entity changed by a change set. Before and After
+// hold the JSON of the entity, empty when it did not exist.
+type ChangeSetItem struct {
+	ID          uint   `gorm:"primaryKey" json:"id"`
+	ChangeSetID uint   `gorm:"not null;index" json:"change_set_id"`
+	Entity      string `gorm:"size:50;not null" json:"entity"`
+	EntityID    uint   `json:"entity_id"`
+	Action      string `gorm:"size:30;not null" json:"action"`
+	Before      string `json:"before"`
+	After       string `json:"after"`
+}
+
+// CalendarToken gives calendar clients, which cannot send a Bearer header,
+// access to the iCalendar feed of an employee, a CE or a sector.
+type CalendarToken struct {
+	ID         uint       `gorm:"primaryKey" json:"id"`
+	Token      string     `gorm:"size:64;not null;uniqueIndex" json:"token"`
+	Scope      string     `gorm:"size:20;not null" json:"scope"`
+	ScopeID    uint       `gorm:"not null" json:"scope_id"`
+	CreatedBy  string     `gorm:"size:50;index" json:"created_by"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+// SchemaMigration records a migration applied to the database.
+type SchemaMigration struct {
+	Version   uint      `gorm:"primaryKey;autoIncrement:false" json:"version"`
+	Name      string    `gorm:"size:100;not null" json:"name"`
+	AppliedAt time.Time `json:"applied_at"`
 }
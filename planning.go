@@ -0,0 +1,49 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+
+	"gorm.io/gorm"
+
+	"planning_hager/handlers"
+)
+
+const planningUsage = "usage: planning_hager planning populate -year YEAR [-dry-run] [-reason TEXT]"
+
+// cliUsername is the name the audit log and change sets record for changes
+// made from the command line.
+const cliUsername = "cli"
+
+// runPlanning handles "planning populate", which generates the planning of a
+// year like the populate_yearly_planning route and prints its summary.
+func runPlanning(db *gorm.DB, args []string) error {
+	if len(args) == 0 || args[0] != "populate" {
+		return errors.New(planningUsage)
+	}
+
+	flags := flag.NewFlagSet("planning populate", flag.ExitOnError)
+	year := flags.Int("year", 0, "year to populate")
+	dryRun := flags.Bool("dry-run", false, "show the changes without applying them")
+	reason := flags.String("reason", "", "reason overriding past and published weeks, recorded in the audit log")
+	flags.Parse(args[1:])
+	if *year == 0 || flags.NArg() > 0 {
+		return errors.New(planningUsage)
+	}
+
+	result, err := handlers.PopulateYear(db, *year, *dryRun, cliUsername, *reason)
+	if err != nil {
+		return fmt.Errorf("populating %d: %w", *year, err)
+	}
+
+	// The full diff of a year is too long to read in a terminal, so only
+	// the summary is printed
+	out, err := json.MarshalIndent(result, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(out))
+	return nil
+}
@@ -19,13 +19,19 @@ func SetupRouter(db *gorm.DB) *gin.Engine {
 
 	// Public Routes
 	r.POST("/login", h.Login)
+	r.GET("/calendar/feed/:token", h.GetCalendarFeed)
 
 	// Protected routes
 	protected := r.Group("/")
-	protected.Use(handlers.AuthMiddleware())
+	protected.Use(handlers.AuthMiddleware(), h.ActiveUserMiddleware())
 	{
 		protected.GET("/verify-token", handlers.VerifyToken)
 		protected.GET("/planning", h.GetPlannings)
+		protected.GET("/planning/coverage", h.GetPlanningCoverage)
+		protected.GET("/planning/violations", h.GetPlanningViolations)
+		protected.GET("/planning/publication", h.GetWeekPublication)
+		protected.GET("/planning/export", h.ExportRoster)
+		protected.GET("/planning/pdf", h.ExportRosterPDF)
 		protected.GET("/employees", h.GetEmployees)
 		protected.GET("/sectors", h.GetSectors)
 		protected.GET("/ces", h.GetCEs)
@@ -33,6 +39,27 @@ func SetupRouter(db *gorm.DB) *gin.Engine {
 		protected.GET("/employee_skills/:id", h.GetEmployeeSkills)
 		protected.GET("/sector_required_skills", h.GetSectorRequiredSkills)
 		protected.GET("/api/current-employee", h.GetCurrentEmployee)
+		protected.GET("/api/current-employee/absence_requests", h.GetCurrentEmployeeAbsenceRequests)
+		protected.GET("/api/current-employee/leave_balance", h.GetCurrentEmployeeLeaveBalance)
+		protected.GET("/leave_accrual_rules", h.GetLeaveAccrualRules)
+		protected.GET("/api/current-employee/shift_swap_requests", h.GetCurrentEmployeeShiftSwapRequests)
+		protected.POST("/add_shift_swap_request", h.AddShiftSwapRequest)
+		protected.PUT("/accept_shift_swap_request/:id", h.AcceptShiftSwapRequest)
+		protected.PUT("/decline_shift_swap_request/:id", h.DeclineShiftSwapRequest)
+		protected.PUT("/cancel_shift_swap_request/:id", h.CancelShiftSwapRequest)
+		protected.POST("/add_absence_request", h.AddAbsenceRequest)
+		protected.PUT("/cancel_absence_request/:id", h.CancelAbsenceRequest)
+		protected.GET("/rotation_patterns", h.GetRotationPatterns)
+		protected.GET("/week_regime", h.GetWeekRegime)
+		protected.GET("/week_regimes", h.GetWeekRegimes)
+		protected.GET("/shift_definitions", h.GetShiftDefinitions)
+		protected.GET("/labour_rules", h.GetLabourRules)
+		protected.GET("/calendar/employee/:id", h.GetEmployeeCalendar)
+		protected.GET("/calendar/ce/:id", h.GetCECalendar)
+		protected.GET("/calendar/sector/:id", h.GetSectorCalendar)
+		protected.GET("/calendar_tokens", h.GetCalendarTokens)
+		protected.POST("/add_calendar_token", h.AddCalendarToken)
+		protected.DELETE("/delete_calendar_token/:id", h.DeleteCalendarToken)
 
 		// Admin only routes
 		admin := protected.Group("/")
@@ -52,17 +79,62 @@ func SetupRouter(db *gorm.DB) *gin.Engine {
 			admin.DELETE("/delete_ce/:id", h.DeleteCE)
 			admin.POST("/add_planning", h.AddPlanning)
 			admin.PUT("/update_planning/:id", h.UpdatePlanning)
+			admin.GET("/planning/:id/substitutes", h.GetSubstituteSuggestions)
 			admin.DELETE("/delete_planning/:id", h.DeletePlanning)
 			admin.POST("/add_ce_planning", h.AddCEPlanning)
 			admin.PUT("/update_ce_planning/:id", h.UpdateCEPlanning)
 			admin.DELETE("/delete_ce_planning/:id", h.DeleteCEPlanning)
+			admin.POST("/add_ce_week_planning", h.AddCEWeekPlanning)
 			admin.POST("/update_planning_shift_type", h.UpdatePlanningShiftType)
+			admin.PUT("/week_regime", h.SetWeekRegime)
 			admin.POST("/populate_yearly_planning", h.PopulateYearlyPlanning)
 			admin.POST("/bulk_update_planning", h.BulkUpdatePlanning)
+			admin.GET("/planning/publications", h.GetWeekPublications)
+			admin.PUT("/publish_week", h.PublishWeek)
+			admin.PUT("/unpublish_week", h.UnpublishWeek)
+			admin.PUT("/lock_week", h.LockWeek)
+			admin.PUT("/unlock_week", h.UnlockWeek)
 			admin.GET("/reservists", h.GetReservists)
+			admin.GET("/reservist_usage", h.GetReservistUsage)
 			admin.POST("/add_reservist", h.AddReservist)
 			admin.PUT("/update_reservist/:id", h.UpdateReservist)
 			admin.DELETE("/delete_reservist/:id", h.DeleteReservist)
+			admin.GET("/reservist_availability/:id", h.GetReservistAvailability)
+			admin.POST("/add_reservist_availability", h.AddReservistAvailability)
+			admin.PUT("/update_reservist_availability/:id", h.UpdateReservistAvailability)
+			admin.DELETE("/delete_reservist_availability/:id", h.DeleteReservistAvailability)
+			admin.POST("/add_rotation_pattern", h.AddRotationPattern)
+			admin.PUT("/update_rotation_pattern/:id", h.UpdateRotationPattern)
+			admin.DELETE("/delete_rotation_pattern/:id", h.DeleteRotationPattern)
+			admin.POST("/add_shift_definition", h.AddShiftDefinition)
+			admin.PUT("/update_shift_definition/:id", h.UpdateShiftDefinition)
+			admin.DELETE("/delete_shift_definition/:id", h.DeleteShiftDefinition)
+			admin.PUT("/labour_rules", h.UpdateLabourRules)
+			admin.GET("/absence_requests", h.GetAbsenceRequests)
+			admin.PUT("/approve_absence_request/:id", h.ApproveAbsenceRequest)
+			admin.PUT("/reject_absence_request/:id", h.RejectAbsenceRequest)
+			admin.GET("/leave_balance/:id", h.GetLeaveBalance)
+			admin.GET("/leave_movements/:id", h.GetLeaveMovements)
+			admin.POST("/add_leave_adjustment", h.AddLeaveAdjustment)
+			admin.PUT("/update_leave_accrual_rule/:id", h.UpdateLeaveAccrualRule)
+			admin.POST("/accrue_leave", h.AccrueLeave)
+			admin.GET("/shift_swap_requests", h.GetShiftSwapRequests)
+			admin.PUT("/approve_shift_swap_request/:id", h.ApproveShiftSwapRequest)
+			admin.PUT("/reject_shift_swap_request/:id", h.RejectShiftSwapRequest)
+			admin.GET("/audit", h.GetAuditLogs)
+			admin.POST("/import_master_data", h.ImportMasterData)
+			admin.GET("/change_sets", h.GetChangeSets)
+			admin.GET("/change_sets/:id", h.GetChangeSet)
+			admin.PUT("/revert_change_set/:id", h.RevertChangeSet)
+			admin.GET("/users", h.GetUsers)
+			admin.POST("/add_user", h.AddUser)
+			admin.PUT("/update_user/:id", h.UpdateUser)
+			admin.PUT("/reset_user_password/:id", h.ResetUserPassword)
+			admin.PUT("/link_user_employee/:id", h.LinkUserEmployee)
+			admin.PUT("/unlink_user_employee/:id", h.UnlinkUserEmployee)
+			admin.PUT("/deactivate_user/:id", h.DeactivateUser)
+			admin.PUT("/activate_user/:id", h.ActivateUser)
+			admin.DELETE("/delete_user/:id", h.DeleteUser)
 		}
 	}
 
@@ -73,7 +145,7 @@ func CORSMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
 		c.Writer.Header().Set("Access-Control-Allow-Credentials", "true")
-		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, accept, origin, Cache-Control, X-Requested-With")
+		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, accept, origin, Cache-Control, X-Requested-With, X-Change-Reason")
 		c.Writer.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS, GET, PUT, DELETE")
 
 		if c.Request.Method == "OPTIONS" {
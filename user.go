@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"gorm.io/gorm"
+
+	"planning_hager/handlers"
+)
+
+const userUsage = "usage: planning_hager user create | reset-password | set-role -username NAME [-role ROLE] [-password PASSWORD]"
+
+// readPassword reads the password from the first line of standard input, so
+// it stays out of the shell history.
+func readPassword() (string, error) {
+	fmt.Fprint(os.Stderr, "Password: ")
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && line == "" {
+		return "", errors.New("no password given on standard input")
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// runUser creates accounts, resets their password and changes their role
+// through the same rules as the API.
+func runUser(db *gorm.DB, args []string) error {
+	if len(args) == 0 {
+		return errors.New(userUsage)
+	}
+
+	flags := flag.NewFlagSet("user "+args[0], flag.ExitOnError)
+	username := flags.String("username", "", "account name")
+	role := flags.String("role", "", "admin, user or readonly")
+	password := flags.String("password", "", "new password, read from standard input when omitted")
+	flags.Parse(args[1:])
+
+	if *username == "" || flags.NArg() > 0 {
+		return errors.New(userUsage)
+	}
+	if args[0] == "create" || args[0] == "reset-password" {
+		if *password == "" {
+			var err error
+			if *password, err = readPassword(); err != nil {
+				return err
+			}
+		}
+	}
+
+	switch args[0] {
+	case "create":
+		user, err := handlers.CreateUser(db, *username, *password, *role)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("user %s created with role %s\n", user.Username, user.Role)
+
+	case "reset-password":
+		user, err := handlers.ResetPassword(db, *username, *password)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("password of %s reset\n", user.Username)
+
+	case "set-role":
+		user, err := handlers.SetRole(db, *username, *role)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("user %s now has role %s\n", user.Username, user.Role)
+
+	default:
+		return errors.New(userUsage)
+	}
+	return nil
+}